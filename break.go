@@ -0,0 +1,39 @@
+package vmodem
+
+func (m *Modem) sendBreak() error {
+	if m.status() != StatusConnected && m.status() != StatusConnectedCmd {
+		return ErrNoCarrier
+	}
+	bs, ok := m.conn.(BreakSender)
+	if !ok {
+		return ErrBreakNotSupported
+	}
+	return bs.SendBreak()
+}
+
+// SendBreak transmits a break signal to the remote side of the call (same as AT\B), e.g.
+// when the embedder detects a break from the DTE. Returns ErrNoCarrier without call and
+// ErrBreakNotSupported when the connection can't carry breaks (see BreakSender).
+// Modem lock must be held.
+func (m *Modem) SendBreak() error {
+	m.checkLock()
+	return m.sendBreak()
+}
+
+// SendBreakSync transmits a break signal to the remote side of the call. Modem lock is acquired and released.
+func (m *Modem) SendBreakSync() error {
+	m.Lock()
+	defer m.Unlock()
+	return m.sendBreak()
+}
+
+// remoteBreak returns the handler of breaks received from the remote side of a call
+func (m *Modem) remoteBreak(call *CallRecord) func() {
+	return func() {
+		m.Lock()
+		defer m.Unlock()
+		if m.call == call && m.breakHook != nil {
+			m.breakHook(m)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package vmodem
+
+import "io"
+
+// CallInfo describes the caller of an incoming call (reported with AT+VCID=1)
+type CallInfo struct {
+	Number string `json:"number,omitempty"` // Caller number ("" = unavailable)
+	Name   string `json:"name,omitempty"`   // Caller name ("" = not reported)
+	Remote string `json:"remote,omitempty"` // Remote address of the caller ("" = taken from the connection when it has one)
+}
+
+// IncomingCallWithInfo simulates an incoming call carrying caller ID information. Modem lock must be held.
+func (m *Modem) IncomingCallWithInfo(conn io.ReadWriteCloser, info CallInfo) error {
+	m.checkLock()
+	return m.incomingCallWithInfo(conn, info)
+}
+
+// IncomingCallWithInfoSync simulates an incoming call carrying caller ID information.
+// Modem lock is acquired and released.
+func (m *Modem) IncomingCallWithInfoSync(conn io.ReadWriteCloser, info CallInfo) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.incomingCallWithInfo(conn, info)
+}
+
+func (m *Modem) incomingCallWithInfo(conn io.ReadWriteCloser, info CallInfo) error {
+	return m.incomingCall(conn, &info)
+}
+
+func (m *Modem) remoteInfo() CallInfo {
+	info := CallInfo{}
+	if m.conn == nil {
+		return info
+	}
+	if m.callInfo != nil {
+		info = *m.callInfo
+	}
+	switch {
+	case m.call != nil:
+		info.Number = m.call.Number
+		info.Remote = m.call.Remote
+	case info.Remote == "":
+		info.Remote = connRemoteAddr(m.conn)
+	}
+	return info
+}
+
+// RemoteInfo returns the number, caller name and remote address of the ringing or
+// connected call (zero value without call). Numbers of outgoing calls are the dialed
+// ones. Modem lock must be held.
+func (m *Modem) RemoteInfo() CallInfo {
+	m.checkLock()
+	return m.remoteInfo()
+}
+
+// RemoteInfoSync returns the number, caller name and remote address of the ringing or
+// connected call. Modem lock is acquired and released.
+func (m *Modem) RemoteInfoSync() CallInfo {
+	m.Lock()
+	defer m.Unlock()
+	return m.remoteInfo()
+}
+
+// printCallerId sends the formatted caller ID report between the first and second ring
+func (m *Modem) printCallerId() {
+	if m.callerIdMode == 0 || m.callInfo == nil {
+		return
+	}
+	number := m.callInfo.Number
+	if number == "" {
+		number = "O" // out of area
+	}
+	out := m.eol() + "DATE = " + m.callTime.Format("0102") + m.eol()
+	out += "TIME = " + m.callTime.Format("1504") + m.eol()
+	out += "NMBR = " + number + m.eol()
+	if m.callInfo.Name != "" {
+		out += "NAME = " + m.callInfo.Name + m.eol()
+	}
+	m.ttyWriteStr(out)
+}
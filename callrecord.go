@@ -0,0 +1,187 @@
+package vmodem
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// CallDirection tells whether a call was placed or answered by the modem
+type CallDirection int
+
+const (
+	CallOutgoing CallDirection = iota // Dialed by the modem (ATD, Dial)
+	CallIncoming                      // Answered by the modem (IncomingCall)
+)
+
+func (d CallDirection) String() string {
+	switch d {
+	case CallOutgoing:
+		return "Outgoing"
+	case CallIncoming:
+		return "Incoming"
+	default:
+		return "Unknown"
+	}
+}
+
+// DisconnectReason tells why a call ended
+type DisconnectReason int
+
+const (
+	DisconnectLocal       DisconnectReason = iota // Hung up by the DTE or the host (ATH, ATZ, SetStatus)
+	DisconnectCarrierLost                         // Remote side closed the connection
+	DisconnectDTR                                 // DTR dropped by the DTE
+	DisconnectClosed                              // Modem closed during the call
+	DisconnectIdle                                // No data flowed for the S30 inactivity time
+	DisconnectMaxDuration                         // Max call duration reached
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectLocal:
+		return "Local"
+	case DisconnectCarrierLost:
+		return "CarrierLost"
+	case DisconnectDTR:
+		return "DTR"
+	case DisconnectClosed:
+		return "Closed"
+	case DisconnectIdle:
+		return "Idle"
+	case DisconnectMaxDuration:
+		return "MaxDuration"
+	default:
+		return "Unknown"
+	}
+}
+
+// CallRecord holds the statistics of a single connected call
+type CallRecord struct {
+	Direction CallDirection
+	Number    string // Dialed number or caller ID number ("" = unknown)
+	Remote    string // Remote address of the connection ("" = not a network connection)
+	Start     time.Time
+	End       time.Time
+	TxBytes   int // Bytes sent from the DTE to the remote side
+	RxBytes   int // Bytes received from the remote side
+	Reason    DisconnectReason
+}
+
+// Duration returns the connected time of the call
+func (r CallRecord) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+type CallEndedType func(m *Modem, rec CallRecord)
+
+// beginCall starts the record of a call entering online mode. Must be called before the connection is wrapped.
+func (m *Modem) beginCall(prevStatus ModemStatus) {
+	rec := &CallRecord{Direction: CallOutgoing, Number: m.dialNumber, Start: m.clock.Now()}
+	if prevStatus == StatusRinging {
+		rec.Direction = CallIncoming
+		rec.Number = ""
+		if m.callInfo != nil {
+			rec.Number = m.callInfo.Number
+			rec.Remote = m.callInfo.Remote
+		}
+	}
+	if rec.Remote == "" {
+		rec.Remote = connRemoteAddr(m.conn)
+	}
+	if rec.Remote != "" {
+		m.metrics.setLastRemote(rec.Remote)
+	} else {
+		m.metrics.setLastRemote(rec.Number)
+	}
+	m.call = rec
+	m.metrics.callTx.Store(0)
+	m.metrics.callRx.Store(0)
+	m.metrics.lastActivity.Store(rec.Start.UnixNano())
+	m.metrics.callStart.Store(rec.Start.UnixNano())
+	m.startRecording(rec)
+	m.goTask(func() { m.callTimers(rec) })
+}
+
+// connRemoteAddr returns the remote address of network connections ("" = not a network connection)
+func connRemoteAddr(conn io.ReadWriteCloser) string {
+	if ra, ok := conn.(interface{ RemoteAddr() net.Addr }); ok && ra.RemoteAddr() != nil {
+		return ra.RemoteAddr().String()
+	}
+	return ""
+}
+
+// endCall completes the record of the current call and delivers it to the CallEnded hook
+func (m *Modem) endCall(reason DisconnectReason) {
+	rec := m.call
+	m.call = nil
+	if rec == nil {
+		return
+	}
+	rec.End = m.clock.Now()
+	rec.Reason = reason
+	rec.TxBytes = int(m.metrics.callTx.Load())
+	rec.RxBytes = int(m.metrics.callRx.Load())
+	m.metrics.callStart.Store(0)
+	m.log.Info("call ended", "direction", rec.Direction.String(), "number", rec.Number, "remote", rec.Remote,
+		"duration", rec.Duration(), "tx", rec.TxBytes, "rx", rec.RxBytes, "reason", rec.Reason.String())
+	if m.callEnded != nil {
+		m.callEnded(m, *rec)
+	}
+}
+
+// countCallTx counts data sent during the call, modem lock not required
+func (m *Modem) countCallTx(n int) {
+	now := m.clock.Now()
+	m.metrics.connTx.Add(int64(n))
+	m.metrics.callTx.Add(int64(n))
+	m.metrics.connTxRate.add(now, n)
+	m.metrics.lastActivity.Store(now.UnixNano())
+}
+
+// countCallRx counts data received during the call, modem lock not required
+func (m *Modem) countCallRx(n int) {
+	now := m.clock.Now()
+	m.metrics.connRx.Add(int64(n))
+	m.metrics.callRx.Add(int64(n))
+	m.metrics.connRxRate.add(now, n)
+	m.metrics.lastActivity.Store(now.UnixNano())
+}
+
+// callTimerPeriod is the max time between checks of the call timers (S30 can change during the call)
+const callTimerPeriod = time.Second
+
+// callTimers hangs up the call when no data flows in online mode for the S30 inactivity
+// time (10 s units) or when the max call duration is reached.
+func (m *Modem) callTimers(call *CallRecord) {
+	m.Lock()
+	defer m.Unlock()
+	for m.call == call {
+		now := m.clock.Now()
+		wait := callTimerPeriod
+		if m.maxCallDuration > 0 {
+			left := call.Start.Add(m.maxCallDuration).Sub(now)
+			if left <= 0 {
+				m.hangupReason = DisconnectMaxDuration
+				m.setStatus(StatusIdle)
+				return
+			}
+			wait = min(wait, left)
+		}
+		if idle := time.Duration(m.sregs[30]) * 10 * time.Second; idle > 0 && m.status() == StatusConnected {
+			left := loadTime(&m.metrics.lastActivity).Add(idle).Sub(now)
+			if left <= 0 {
+				m.hangupReason = DisconnectIdle
+				m.setStatus(StatusIdle)
+				return
+			}
+			wait = min(wait, left)
+		}
+		m.Unlock()
+		select {
+		case <-m.clock.After(wait):
+		case <-m.closed:
+		}
+		m.Lock()
+	}
+}
@@ -0,0 +1,322 @@
+package vmodem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Chat scripts follow the chat(8) syntax: expect and send strings alternate, separated by
+// spaces and quoted with ' or " when they contain spaces or are empty ('' expects nothing).
+//
+//	ABORT BUSY ABORT 'NO CARRIER' TIMEOUT 30 '' ATZ OK ATDT123 CONNECT
+//
+// Send strings are followed by CR and take the escapes \c (no CR), \d (1 s delay) and \p
+// (1/10 s pause). Both take \r, \n, \t, \s (space) and \\. ABORT adds a text failing the
+// script when received while expecting, TIMEOUT sets the seconds the next expects wait.
+// Lines starting with # are comments.
+
+// defaultChatTimeout is the expect timeout of scripts without TIMEOUT (as chat(8))
+const defaultChatTimeout = 45 * time.Second
+
+// ChatStep is an expect and send pair of a chat script
+type ChatStep struct {
+	Expect  string        // Text waited for before sending ("" = none)
+	Send    string        // Send string in chat syntax, followed by CR unless it has \c ("\c" = none)
+	Timeout time.Duration // Max wait for Expect (0 = script timeout)
+}
+
+// ChatScript is a sequence of expect and send steps
+type ChatScript struct {
+	Steps   []ChatStep
+	Aborts  []string      // Texts failing the script when received while expecting (e.g. "NO CARRIER")
+	Timeout time.Duration // Max wait of the expects without timeout (0 = 45 s)
+}
+
+// ChatError is a failed chat script step
+type ChatError struct {
+	Step     int    // Index of the failed step
+	Expect   string // Text expected
+	Received string // Text received since the previous step
+	Err      error  // ErrChatTimeout, ErrChatAborted or the TTY error
+}
+
+func (e *ChatError) Error() string {
+	return fmt.Sprintf("chat step %d expecting %q: %v (received %q)", e.Step, e.Expect, e.Err, e.Received)
+}
+
+func (e *ChatError) Unwrap() error {
+	return e.Err
+}
+
+// ParseChatScript parses a chat script in chat(8) syntax
+func ParseChatScript(src string) (*ChatScript, error) {
+	words, err := chatWords(src)
+	if err != nil {
+		return nil, err
+	}
+	s := &ChatScript{}
+	timeout := time.Duration(0)
+	for i := 0; i < len(words); i++ {
+		switch words[i] {
+		case "ABORT", "TIMEOUT":
+			if i+1 >= len(words) {
+				return nil, fmt.Errorf("%w: %s without value", ErrInvalidValue, words[i])
+			}
+			i++
+			if words[i-1] == "ABORT" {
+				s.Aborts = append(s.Aborts, chatUnescape(words[i]))
+				continue
+			}
+			secs, err := strconv.Atoi(words[i])
+			if err != nil || secs <= 0 {
+				return nil, fmt.Errorf("%w: TIMEOUT %s", ErrInvalidValue, words[i])
+			}
+			timeout = time.Duration(secs) * time.Second
+		default:
+			step := ChatStep{Expect: chatUnescape(words[i]), Send: `\c`, Timeout: timeout}
+			if i+1 < len(words) {
+				i++
+				step.Send = words[i]
+			}
+			s.Steps = append(s.Steps, step)
+		}
+	}
+	return s, nil
+}
+
+// chatWords splits a chat script into its words, removing quotes and comments. Escapes are
+// kept, so \' and \" quote characters do not end strings.
+func chatWords(src string) ([]string, error) {
+	words := []string{}
+	for _, line := range strings.Split(src, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		word := strings.Builder{}
+		inWord := false
+		quote := rune(0)
+		escaped := false
+		for _, c := range line {
+			switch {
+			case escaped:
+				word.WriteRune(c)
+				escaped = false
+			case c == '\\':
+				word.WriteRune(c)
+				escaped = true
+				inWord = true
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote != 0:
+				word.WriteRune(c)
+			case c == '\'' || c == '"':
+				quote = c
+				inWord = true
+			case unicode.IsSpace(c):
+				if inWord {
+					words = append(words, word.String())
+					word.Reset()
+					inWord = false
+				}
+			default:
+				word.WriteRune(c)
+				inWord = true
+			}
+		}
+		if quote != 0 {
+			return nil, fmt.Errorf("%w: unterminated string %q", ErrInvalidValue, word.String())
+		}
+		if inWord {
+			words = append(words, word.String())
+		}
+	}
+	return words, nil
+}
+
+// chatEscape returns the character of the escape \c common to expect and send strings
+func chatEscape(c byte) (byte, bool) {
+	switch c {
+	case 'r':
+		return '\r', true
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 's':
+		return ' ', true
+	case '\\', '\'', '"':
+		return c, true
+	}
+	return 0, false
+}
+
+// chatUnescape decodes the escapes of an expect string
+func chatUnescape(s string) string {
+	out := []byte{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			if ch, ok := chatEscape(s[i]); ok {
+				out = append(out, ch)
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+// Chat runs chat scripts on a TTY, typically the TTY of a modem
+type Chat struct {
+	rw    io.ReadWriter
+	data  chan []byte
+	err   error     // Read error, set before data is closed
+	buf   []byte    // Text received and not consumed by an expect
+	Trace io.Writer // Receives a copy of the text sent and received (nil = none)
+	Clock Clock     // Time source of the expect timeouts and send pauses (nil = system clock)
+}
+
+// NewChat returns a chat reading rw until a read fails. The caller closes rw when done.
+func NewChat(rw io.ReadWriter) *Chat {
+	c := &Chat{rw: rw, data: make(chan []byte, 16)}
+	go c.readTask()
+	return c
+}
+
+func (c *Chat) readTask() {
+	defer close(c.data)
+	for {
+		b := make([]byte, 1024)
+		n, err := c.rw.Read(b)
+		if n > 0 {
+			c.data <- b[:n]
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+	}
+}
+
+func (c *Chat) clock() Clock {
+	if c.Clock == nil {
+		return systemClock{}
+	}
+	return c.Clock
+}
+
+// Expect waits until text is received, consuming the text received up to it. Fails with
+// ErrChatTimeout after timeout (0 = 45 s) and ErrChatAborted when one of the aborts texts is
+// received first.
+func (c *Chat) Expect(ctx context.Context, text string, timeout time.Duration, aborts []string) error {
+	if timeout <= 0 {
+		timeout = defaultChatTimeout
+	}
+	expired := c.clock().After(timeout)
+	for {
+		abortAt := -1
+		for _, abort := range aborts {
+			if i := bytes.Index(c.buf, []byte(abort)); i >= 0 && (abortAt < 0 || i < abortAt) {
+				abortAt = i
+			}
+		}
+		i := bytes.Index(c.buf, []byte(text))
+		if i >= 0 && (abortAt < 0 || i <= abortAt) {
+			c.buf = c.buf[i+len(text):]
+			return nil
+		}
+		if abortAt >= 0 {
+			return ErrChatAborted
+		}
+		select {
+		case b, ok := <-c.data:
+			if !ok {
+				return c.err
+			}
+			if c.Trace != nil {
+				c.Trace.Write(b)
+			}
+			c.buf = append(c.buf, b...)
+		case <-expired:
+			return ErrChatTimeout
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Send writes a send string in chat syntax, followed by CR unless it has \c
+func (c *Chat) Send(ctx context.Context, s string) error {
+	out := []byte{}
+	cr := true
+	flush := func() error {
+		if len(out) == 0 {
+			return nil
+		}
+		if c.Trace != nil {
+			c.Trace.Write(out)
+		}
+		_, err := c.rw.Write(out)
+		out = out[:0]
+		return err
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			out = append(out, s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'c':
+			cr = false
+		case 'd', 'p':
+			if err := flush(); err != nil {
+				return err
+			}
+			pause := time.Second
+			if s[i] == 'p' {
+				pause = 100 * time.Millisecond
+			}
+			if !clockSleep(ctx, c.clock(), pause) {
+				return ctx.Err()
+			}
+		default:
+			if ch, ok := chatEscape(s[i]); ok {
+				out = append(out, ch)
+			} else {
+				out = append(out, '\\', s[i])
+			}
+		}
+	}
+	if cr {
+		out = append(out, '\r')
+	}
+	return flush()
+}
+
+// Run runs the steps of a chat script. Returns a *ChatError locating the failed step.
+func (c *Chat) Run(ctx context.Context, s *ChatScript) error {
+	for i, step := range s.Steps {
+		if step.Expect != "" {
+			timeout := step.Timeout
+			if timeout == 0 {
+				timeout = s.Timeout
+			}
+			if err := c.Expect(ctx, step.Expect, timeout, s.Aborts); err != nil {
+				received := string(c.buf)
+				c.buf = nil
+				return &ChatError{Step: i, Expect: step.Expect, Received: received, Err: err}
+			}
+		}
+		if err := c.Send(ctx, step.Send); err != nil {
+			return &ChatError{Step: i, Expect: step.Expect, Err: err}
+		}
+	}
+	return nil
+}
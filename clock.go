@@ -0,0 +1,51 @@
+package vmodem
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the time source of the modem timing logic: escape sequence guard time, ring
+// interval, dialing delays and timeouts (S6, S7 and S8), carrier loss and inactivity timers,
+// rate limits and line speed pacing, write coalescing and link impairment delays, dial plan
+// time windows, and the timestamps of calls, metrics and recordings. Tests can provide a
+// fake clock to drive them deterministically.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the default Clock backed by the time package
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clockSleep waits for d on the clock or until ctx is done. Returns false if ctx is done.
+func clockSleep(ctx context.Context, clock Clock, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-clock.After(d):
+		return true
+	}
+}
+
+// clockTimeout is like context.WithTimeout measuring the timeout on the clock
+func clockTimeout(ctx context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := clock.(systemClock); ok {
+		return context.WithTimeout(ctx, d)
+	}
+	tctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-clock.After(d):
+			cancel(context.DeadlineExceeded)
+		case <-tctx.Done():
+		}
+	}()
+	return tctx, func() { cancel(context.Canceled) }
+}
@@ -0,0 +1,179 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock is a Clock that only moves forward when the test advances it
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []manualWaiter
+}
+
+type manualWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, manualWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward firing the waiters due
+func (c *manualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = pending
+}
+
+func (c *manualClock) waiting(d time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.waiters {
+		if w.at.Equal(c.now.Add(d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// awaitTimer waits until a timer of d is started on the clock
+func (c *manualClock) awaitTimer(t *testing.T, d time.Duration) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !c.waiting(d); {
+		if time.Now().After(deadline) {
+			t.Fatalf("no %v timer started on the clock", d)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// dteOutput collects the data the modem writes to its TTY
+type dteOutput struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (o *dteOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(b)
+}
+
+func (o *dteOutput) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// await waits until the output has text after the first from bytes, returning its end offset
+func (o *dteOutput) await(t *testing.T, from int, text string) int {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if i := strings.Index(o.String()[from:], text); i >= 0 {
+			return from + i + len(text)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q not received, got %q", text, o.String()[from:])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// connectedModem returns a modem running on clock with an established outgoing call, the
+// DTE side of its TTY and the data received by the DTE and by the remote side of the call
+func connectedModem(t *testing.T, clock Clock) (*Modem, net.Conn, *dteOutput, *dteOutput) {
+	t.Helper()
+	dte, tty := net.Pipe()
+	out, line := &dteOutput{}, &dteOutput{}
+	go io.Copy(out, dte)
+	m, err := NewModem(&ModemConfig{
+		TTY:       tty,
+		Clock:     clock,
+		GuardTime: 30, // 1.5 s, other modem timers tick every second
+		OutgoingCallCtx: func(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error) {
+			local, remote := net.Pipe()
+			go io.Copy(line, remote)
+			return local, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		m.Shutdown(ctx)
+		dte.Close()
+	})
+	dte.Write([]byte("ATX4D1\r"))
+	out.await(t, 0, "CONNECT")
+	return m, dte, out, line
+}
+
+func TestEscapeGuardTime(t *testing.T) {
+	clock := &manualClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m, dte, out, _ := connectedModem(t, clock)
+	guardTime := 1500 * time.Millisecond
+	from := len(out.String())
+
+	clock.Advance(guardTime) // silence before the escape sequence
+	dte.Write([]byte("+++"))
+	clock.awaitTimer(t, guardTime)
+	clock.Advance(guardTime - time.Millisecond)
+	if m.StatusSync() != StatusConnected {
+		t.Fatalf("escaped before the guard time")
+	}
+	clock.Advance(time.Millisecond)
+	out.await(t, from, "OK")
+	if status := m.StatusSync(); status != StatusConnectedCmd {
+		t.Fatalf("status %v after the guard time, want %v", status, StatusConnectedCmd)
+	}
+}
+
+func TestEscapeGuardTimeBroken(t *testing.T) {
+	clock := &manualClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m, dte, _, line := connectedModem(t, clock)
+	guardTime := 1500 * time.Millisecond
+
+	clock.Advance(guardTime) // silence before the escape sequence
+	dte.Write([]byte("+++"))
+	clock.awaitTimer(t, guardTime)
+	dte.Write([]byte("x")) // data inside the guard time cancels the escape
+	line.await(t, 0, "+++x")
+	clock.Advance(guardTime)
+	time.Sleep(50 * time.Millisecond)
+	if status := m.StatusSync(); status != StatusConnected {
+		t.Fatalf("status %v after data in the guard time, want %v", status, StatusConnected)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// ModemState is the summary of a modem returned by the management API
+type ModemState struct {
+	// Id is the modem identifier
+	Id string `json:"id"`
+	// Status is the current modem status
+	Status string `json:"status"`
+	// DTR is the DTR line state
+	DTR bool `json:"dtr"`
+	// Remote is the caller ID and remote address of the current call (omitted without call)
+	Remote *vm.CallInfo `json:"remote,omitempty"`
+}
+
+// CallRequest is the body of an incoming call request. The modem rings with a call
+// connected to Addr, reporting Number and Name as caller ID.
+type CallRequest struct {
+	Addr   string `json:"addr"`
+	Number string `json:"number"`
+	Name   string `json:"name"`
+}
+
+func modemState(m *vm.Modem) ModemState {
+	m.Lock()
+	defer m.Unlock()
+	state := ModemState{Id: m.Id(), Status: m.Status().String(), DTR: m.DTR()}
+	if info := m.RemoteInfo(); info != (vm.CallInfo{}) {
+		state.Remote = &info
+	}
+	return state
+}
+
+func findModem(id string) *vm.Modem {
+	for _, m := range modemList() {
+		if m.Id() == id {
+			return m
+		}
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiModem wraps handlers of /modems/{id} resources looking up the modem
+func apiModem(handler func(w http.ResponseWriter, r *http.Request, m *vm.Modem)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m := findModem(r.PathValue("id"))
+		if m == nil {
+			http.Error(w, "modem not found", http.StatusNotFound)
+			return
+		}
+		handler(w, r, m)
+	}
+}
+
+func apiHangup(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+	m.Lock()
+	defer m.Unlock()
+	switch m.Status() {
+	case vm.StatusIdle:
+		http.Error(w, "no call in progress", http.StatusConflict)
+		return
+	case vm.StatusClosed:
+		http.Error(w, "modem closed", http.StatusConflict)
+		return
+	}
+	if err := m.SetStatus(vm.StatusIdle); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, ModemState{Id: m.Id(), Status: m.Status().String(), DTR: m.DTR()})
+}
+
+func apiCall(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+	var req CallRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Addr == "" {
+		http.Error(w, "invalid call request", http.StatusBadRequest)
+		return
+	}
+	conn, err := net.DialTimeout("tcp", req.Addr, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	err = m.IncomingCallWithInfoSync(conn, vm.CallInfo{Number: req.Number, Name: req.Name})
+	if err != nil {
+		conn.Close()
+		code := http.StatusInternalServerError
+		if errors.Is(err, vm.ErrModemBusy) || errors.Is(err, vm.ErrNoDTE) {
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	writeJSON(w, http.StatusOK, modemState(m))
+}
+
+// apiAuth wraps the API handlers requiring the "Authorization: Bearer <token>" header
+func apiAuth(token string, handler http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// enableAPI adds the management API to the metrics server, authenticated with token:
+//
+//	GET  /modems              list modems and their states
+//	GET  /modems/{id}         state of a modem
+//	GET  /modems/{id}/metrics metrics of a modem
+//	POST /modems/{id}/hangup  hang up the current call
+//	POST /modems/{id}/call    ring the modem with a call connected to a TCP address (CallRequest body)
+func enableAPI(token string) {
+	http.HandleFunc("GET /modems", apiAuth(token, func(w http.ResponseWriter, r *http.Request) {
+		ms := modemList()
+		list := make([]ModemState, 0, len(ms))
+		for _, m := range ms {
+			list = append(list, modemState(m))
+		}
+		writeJSON(w, http.StatusOK, list)
+	}))
+	http.HandleFunc("GET /modems/{id}", apiAuth(token, apiModem(func(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+		writeJSON(w, http.StatusOK, modemState(m))
+	})))
+	http.HandleFunc("GET /modems/{id}/metrics", apiAuth(token, apiModem(func(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+		writeJSON(w, http.StatusOK, m.MetricsSnapshot())
+	})))
+	http.HandleFunc("POST /modems/{id}/hangup", apiAuth(token, apiModem(apiHangup)))
+	http.HandleFunc("POST /modems/{id}/call", apiAuth(token, apiModem(apiCall)))
+}
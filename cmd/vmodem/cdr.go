@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// cdrQueueLen is the number of call detail records waiting to be written before dropping them
+const cdrQueueLen = 256
+
+// callDetailRecord is the JSON line written for every completed call
+type callDetailRecord struct {
+	ModemId    string    `json:"modemId"`
+	Direction  string    `json:"direction"`
+	Number     string    `json:"number,omitempty"`
+	Remote     string    `json:"remote,omitempty"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	DurationMs int64     `json:"durationMs"`
+	TxBytes    int       `json:"txBytes"`
+	RxBytes    int       `json:"rxBytes"`
+	Reason     string    `json:"reason"`
+}
+
+var (
+	cdrFile  *os.File
+	cdrQueue chan []byte
+	cdrDone  chan struct{}
+)
+
+// openCDR opens the --cdr-file and starts the writer of call detail records
+func openCDR() error {
+	if options.CdrFile == "" && options.CdrURL == "" {
+		return nil
+	}
+	if options.CdrFile != "" {
+		f, err := os.OpenFile(options.CdrFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		cdrFile = f
+	}
+	cdrQueue = make(chan []byte, cdrQueueLen)
+	cdrDone = make(chan struct{})
+	go cdrTask()
+	return nil
+}
+
+// closeCDR writes the pending records and closes the --cdr-file
+func closeCDR() {
+	if cdrQueue == nil {
+		return
+	}
+	close(cdrQueue)
+	<-cdrDone
+	if cdrFile != nil {
+		cdrFile.Close()
+	}
+}
+
+// callEnded is the CallEnded hook queuing the record of the call (modem lock held, must not block)
+func callEnded(m *vm.Modem, rec vm.CallRecord) {
+	line, err := json.Marshal(callDetailRecord{
+		ModemId:    m.Id(),
+		Direction:  rec.Direction.String(),
+		Number:     rec.Number,
+		Remote:     rec.Remote,
+		Start:      rec.Start,
+		End:        rec.End,
+		DurationMs: rec.Duration().Milliseconds(),
+		TxBytes:    rec.TxBytes,
+		RxBytes:    rec.RxBytes,
+		Reason:     rec.Reason.String(),
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case cdrQueue <- line:
+	default:
+		fmt.Fprintf(os.Stderr, "%s: CDR queue full, call detail record dropped\n", m.Id())
+	}
+}
+
+// cdrTask writes the queued records to the --cdr-file and posts them to the --cdr-url webhook
+func cdrTask() {
+	defer close(cdrDone)
+	client := &http.Client{Timeout: 10 * time.Second}
+	for line := range cdrQueue {
+		if cdrFile != nil {
+			if _, err := cdrFile.Write(append(line, '\n')); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing CDR file: %v\n", err)
+			}
+		}
+		if options.CdrURL != "" {
+			resp, err := client.Post(options.CdrURL, "application/json", bytes.NewReader(line))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error posting CDR: %v\n", err)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				fmt.Fprintf(os.Stderr, "Error posting CDR: %s\n", resp.Status)
+			}
+		}
+	}
+}
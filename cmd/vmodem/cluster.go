@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// ClusterStatus is the bank status a node publishes to its cluster peers
+type ClusterStatus struct {
+	// ListenAddr is the address where the node accepts incoming calls
+	ListenAddr string `json:"listenAddr"`
+	// Free is the number of idle modems
+	Free int `json:"free"`
+	// Extensions are the TTY numbers served by the node
+	Extensions []int `json:"extensions"`
+}
+
+var clusterClient = &http.Client{Timeout: 2 * time.Second}
+
+func localClusterStatus() ClusterStatus {
+	st := ClusterStatus{ListenAddr: options.ClusterAdvertise}
+	if st.ListenAddr == "" {
+		st.ListenAddr = options.ListenAddr
+	}
+	for i, m := range modemList() {
+		st.Extensions = append(st.Extensions, options.StartNum+i)
+		if m.StatusSync() == vm.StatusIdle {
+			st.Free++
+		}
+	}
+	return st
+}
+
+func peerClusterStatus(peer string) (*ClusterStatus, error) {
+	resp, err := clusterClient.Get(fmt.Sprintf("http://%s/cluster", peer))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s: %s", peer, resp.Status)
+	}
+	st := &ClusterStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// checkClusterExtensions verifies that no reachable peer serves any of the local extensions
+func checkClusterExtensions() error {
+	local := localClusterStatus()
+	for _, peer := range options.ClusterPeers {
+		st, err := peerClusterStatus(peer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cluster peer %s unreachable: %v\n", peer, err)
+			continue
+		}
+		for _, ext := range st.Extensions {
+			if slices.Contains(local.Extensions, ext) {
+				return fmt.Errorf("extension %d already served by peer %s", ext, peer)
+			}
+		}
+	}
+	return nil
+}
+
+// clusterRoute returns the listen address of a peer with a free modem
+func clusterRoute() string {
+	for _, peer := range options.ClusterPeers {
+		st, err := peerClusterStatus(peer)
+		if err != nil {
+			continue
+		}
+		if st.Free > 0 {
+			return st.ListenAddr
+		}
+	}
+	return ""
+}
+
+func clusterHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(localClusterStatus())
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	vm "github.com/jaracil/vmodem"
+	"gopkg.in/yaml.v3"
+)
+
+// ModemFileConfig holds the settings of one modem in the config file.
+// Unset fields take the value of the command line flags.
+type ModemFileConfig struct {
+	// Name is the TTY name and modem id (default ttyN)
+	Name string `yaml:"name"`
+	// RingMax is the max number of rings before hangup
+	RingMax *int `yaml:"ringMax"`
+	// AutoAnswer is the number of rings before answering (factory value of S0)
+	AutoAnswer *int `yaml:"autoAnswer"`
+	// AnswerChar is sent when the call is answered
+	AnswerChar *string `yaml:"answerChar"`
+	// AbortChar is the only character aborting dialing and pending commands
+	AbortChar *string `yaml:"abortChar"`
+	// GuardTime is the escape guard time in 50ms increments
+	GuardTime *int `yaml:"guardTime"`
+	// EscapeChar is the escape character code (S2), > 127 disables the escape sequence
+	EscapeChar *int `yaml:"escapeChar"`
+	// TIES escapes with +++ followed by an AT command line and no guard times
+	TIES *bool `yaml:"ties"`
+	// LineSpeed is the simulated line speed in bps (0 = unlimited)
+	LineSpeed *int `yaml:"lineSpeed"`
+	// Translate are phone number translations checked before the global ones. Format: regexp->format[->HH:MM-HH:MM]
+	Translate []string `yaml:"translate"`
+	// Command are command hooks checked before the global ones. Format: regexp->response->result
+	Command []string `yaml:"command"`
+
+	commands []*Command
+}
+
+// FileConfig is the content of the --config file
+type FileConfig struct {
+	// DialPlan are dial plan rules applied after the --dialplan ones
+	DialPlan []string `yaml:"dialplan"`
+	// Translate are phone number translations checked after the --translate ones
+	Translate []string `yaml:"translate"`
+	// Command are command hooks checked after the --command ones
+	Command []string `yaml:"command"`
+	// Modems are the modem settings, in TTY order
+	Modems []*ModemFileConfig `yaml:"modems"`
+}
+
+var (
+	fileConfig FileConfig
+	// settingsMu guards fileConfig, dialRules and commands, which are replaced on reload
+	settingsMu sync.RWMutex
+)
+
+// name returns the TTY name and id of modem number i
+func (fc *FileConfig) name(i int) string {
+	if i < len(fc.Modems) && fc.Modems[i].Name != "" {
+		return fc.Modems[i].Name
+	}
+	return fmt.Sprintf("tty%d", options.StartNum+i)
+}
+
+// readConfig parses the config file
+func readConfig(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc := &FileConfig{}
+	if err := yaml.Unmarshal(data, fc); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for i, mc := range fc.Modems {
+		if mc == nil {
+			fc.Modems[i] = &ModemFileConfig{}
+		}
+		name := fc.name(i)
+		if names[name] {
+			return nil, fmt.Errorf("duplicated modem name: %s", name)
+		}
+		names[name] = true
+	}
+	return fc, nil
+}
+
+// loadConfig reads the --config file at startup. The number of TTYs grows to the number of modems configured.
+func loadConfig(path string) error {
+	fc, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	fileConfig = *fc
+	if len(fileConfig.Modems) > options.NumTTYs {
+		options.NumTTYs = len(fileConfig.Modems)
+	}
+	return nil
+}
+
+// modemName returns the TTY name and id of modem number i
+func modemName(i int) string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return fileConfig.name(i)
+}
+
+// modemTranslations returns the translations of modem number i, in lookup order
+func modemTranslations(fc *FileConfig, i int) []string {
+	var list []string
+	if i < len(fc.Modems) {
+		list = append(list, fc.Modems[i].Translate...)
+	}
+	list = append(list, options.Translate...)
+	return append(list, fc.Translate...)
+}
+
+// modemPhonebook creates the dial plan of modem number i
+func modemPhonebook(i int) (*vm.DialPlan, error) {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return newPhonebook(modemTranslations(&fileConfig, i))
+}
+
+// applySettings builds the dial plan rules, translations and commands of the flags and fc
+// and installs them. Active calls are not affected.
+func applySettings(fc *FileConfig) error {
+	rules, err := parseDialRules(append(append([]string{}, options.DialPlan...), fc.DialPlan...))
+	if err != nil {
+		return err
+	}
+	pb, err := newPhonebook(append(append([]string{}, options.Translate...), fc.Translate...))
+	if err != nil {
+		return err
+	}
+	cmds, err := parseCommands(append(append([]string{}, options.Command...), fc.Command...))
+	if err != nil {
+		return err
+	}
+	ms := modemList()
+	pbs := make([]*vm.DialPlan, len(ms))
+	for i := range ms {
+		if pbs[i], err = newPhonebook(modemTranslations(fc, i)); err != nil {
+			return fmt.Errorf("%s: %v", fc.name(i), err)
+		}
+	}
+	for i, mc := range fc.Modems {
+		if len(mc.Command) > 0 {
+			mcmds, err := parseCommands(mc.Command)
+			if err != nil {
+				return fmt.Errorf("%s: %v", fc.name(i), err)
+			}
+			mc.commands = append(mcmds, cmds...)
+		}
+	}
+
+	settingsMu.Lock()
+	fileConfig = *fc
+	dialRules = rules
+	commands = cmds
+	settingsMu.Unlock()
+	if phonebook == nil {
+		phonebook = pb
+	} else {
+		phonebook.Replace(pb)
+	}
+	for i, m := range ms {
+		m.DialPlan().Replace(pbs[i])
+	}
+	return nil
+}
+
+// reloadSettings reads the config file again and installs the new dial plan rules,
+// translations and commands. Names and number of modems are kept until restart, other
+// modem settings apply when a modem is recreated.
+func reloadSettings() error {
+	fc := &FileConfig{}
+	if options.Config != "" {
+		var err error
+		if fc, err = readConfig(options.Config); err != nil {
+			return err
+		}
+	}
+	n := len(modemList())
+	for len(fc.Modems) < n {
+		fc.Modems = append(fc.Modems, &ModemFileConfig{})
+	}
+	for i := range n {
+		if name := modemName(i); fc.name(i) != name {
+			fmt.Fprintf(os.Stderr, "%s: name change to %s ignored until restart\n", name, fc.name(i))
+			fc.Modems[i].Name = name
+		}
+	}
+	return applySettings(fc)
+}
+
+// reloadTask reloads settings on SIGHUP
+func reloadTask() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for {
+		select {
+		case <-ctx.Done():
+			signal.Stop(c)
+			return
+		case <-c:
+			if err := reloadSettings(); err != nil {
+				fmt.Fprintf(os.Stderr, "Error reloading settings: %v\n", err)
+				continue
+			}
+			if len(options.Verbose) > 0 {
+				fmt.Printf("Settings reloaded\n")
+			}
+		}
+	}
+}
+
+// applyFileConfig overrides the modem config with the config file settings of modem number i
+func applyFileConfig(i int, cfg *vm.ModemConfig) {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	if i >= len(fileConfig.Modems) {
+		return
+	}
+	mc := fileConfig.Modems[i]
+	if mc.RingMax != nil {
+		cfg.RingMax = *mc.RingMax
+	}
+	if mc.AutoAnswer != nil {
+		cfg.AutoAnswerRings = *mc.AutoAnswer
+	}
+	if mc.AnswerChar != nil {
+		cfg.AnswerChar = *mc.AnswerChar
+	}
+	if mc.AbortChar != nil {
+		cfg.AbortChar = *mc.AbortChar
+	}
+	if mc.GuardTime != nil {
+		cfg.GuardTime = *mc.GuardTime
+	}
+	if mc.EscapeChar != nil {
+		cfg.EscapeChar = *mc.EscapeChar
+	}
+	if mc.TIES != nil {
+		cfg.TIES = *mc.TIES
+	}
+	if mc.LineSpeed != nil {
+		cfg.LineSpeed = *mc.LineSpeed
+	}
+}
+
+// modemCommands returns the command hooks of the modem
+func modemCommands(m *vm.Modem) []*Command {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	for i, mc := range fileConfig.Modems {
+		if mc.commands != nil && fileConfig.name(i) == m.Id() {
+			return mc.commands
+		}
+	}
+	return commands
+}
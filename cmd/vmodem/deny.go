@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+var denyNets []netip.Prefix
+
+// parseDeny parses the --deny addresses and networks (a.b.c.d or a.b.c.d/nn)
+func parseDeny(list []string) ([]netip.Prefix, error) {
+	nets := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			nets = append(nets, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny address %q", s)
+		}
+		nets = append(nets, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return nets, nil
+}
+
+// incomingCallFilter rejects incoming calls from the --deny networks
+func incomingCallFilter(m *vm.Modem, info vm.CallInfo) bool {
+	host, _, err := net.SplitHostPort(info.Remote)
+	if err != nil {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, p := range denyNets {
+		if p.Contains(addr) {
+			if len(options.Verbose) > 0 {
+				fmt.Printf("%s: Incoming call from %s rejected\n", m.Id(), info.Remote)
+			}
+			return false
+		}
+	}
+	return true
+}
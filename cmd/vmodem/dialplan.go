@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// DialRule rewrites dialed numbers before phone translations. Rewrite and Route are
+// regexp replacement templates ($1, ${name}...). When Route is not empty the call is
+// sent to that host instead of going through translations.
+type DialRule struct {
+	ReStr   string
+	Rewrite string
+	Route   string
+	Window  *vm.TimeWindow
+	re      *regexp.Regexp
+}
+
+func NewDialRule(reStr, rewrite, route string) (*DialRule, error) {
+	re, err := regexp.Compile(reStr)
+	if err != nil {
+		return nil, err
+	}
+	return &DialRule{
+		ReStr:   reStr,
+		Rewrite: rewrite,
+		Route:   route,
+		re:      re,
+	}, nil
+}
+
+// Apply returns the rewritten number and route if the rule matches the number.
+func (d *DialRule) Apply(num string) (number string, route string, ok bool) {
+	if !d.Window.Contains(time.Now()) {
+		return "", "", false
+	}
+	match := d.re.FindStringSubmatchIndex(num)
+	if match == nil {
+		return "", "", false
+	}
+	number = string(d.re.ExpandString(nil, d.Rewrite, num, match))
+	if d.Route != "" {
+		route = string(d.re.ExpandString(nil, d.Route, num, match))
+	}
+	return number, route, true
+}
+
+var dialRules []*DialRule
+
+// applyDialPlan runs the number through the first matching dial plan rule
+func applyDialPlan(num string) (string, string) {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	for _, d := range dialRules {
+		if number, route, ok := d.Apply(num); ok {
+			return number, route
+		}
+	}
+	return num, ""
+}
+
+// parseDialRules creates the dial plan rules. Format: regexp->rewrite[->route[->HH:MM-HH:MM]]
+func parseDialRules(list []string) ([]*DialRule, error) {
+	var rules []*DialRule
+	for _, r := range list {
+		parts := strings.Split(r, "->")
+		if len(parts) < 2 || len(parts) > 4 {
+			return nil, fmt.Errorf("invalid dial plan rule: %s", r)
+		}
+		route := ""
+		if len(parts) >= 3 {
+			route = parts[2]
+		}
+		rule, err := NewDialRule(parts[0], parts[1], route)
+		if err != nil {
+			return nil, fmt.Errorf("error creating dial plan rule: %v", err)
+		}
+		if len(parts) == 4 {
+			rule.Window, err = vm.ParseTimeWindow(parts[3])
+			if err != nil {
+				return nil, fmt.Errorf("invalid dial plan rule: %v", err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
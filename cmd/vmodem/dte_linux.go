@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	vm "github.com/jaracil/vmodem"
+	"golang.org/x/sys/unix"
+)
+
+// watchDte tracks opens and closes of the PTY slave by other processes and reports
+// the DTE presence to the modem, also as the DTR line when dtr is set.
+func watchDte(m *vm.Modem, name string, dtr bool) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	_, err = unix.InotifyAddWatch(fd, name, unix.IN_OPEN|unix.IN_CLOSE)
+	if err != nil {
+		unix.Close(fd)
+		return err
+	}
+	opened := openCount(name) // DTEs attached before the watch
+	m.SetDTEPresentSync(opened > 0)
+	if dtr {
+		m.SetDTRSync(opened > 0)
+	}
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 4096)
+		for ctx.Err() == nil {
+			n, err := unix.Read(fd, buf)
+			if err != nil {
+				if err == unix.EINTR {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "%s: DTE watcher error: %v\n", m.Id(), err)
+				return
+			}
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				if ev.Mask&unix.IN_OPEN != 0 {
+					opened++
+				}
+				if ev.Mask&unix.IN_CLOSE != 0 && opened > 0 {
+					opened--
+				}
+				if ev.Mask&unix.IN_IGNORED != 0 { // PTY removed
+					return
+				}
+				offset += unix.SizeofInotifyEvent + int(ev.Len)
+			}
+			if len(options.Verbose) > 1 {
+				fmt.Printf("%s: DTE open count %d\n", m.Id(), opened)
+			}
+			m.SetDTEPresentSync(opened > 0)
+			if dtr {
+				m.SetDTRSync(opened > 0)
+			}
+		}
+	}()
+	return nil
+}
+
+// openCount returns the number of open file descriptors of name held by other processes
+func openCount(name string) int {
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return 0
+	}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	self := fmt.Sprintf("/proc/%d/", os.Getpid())
+	count := 0
+	for _, fd := range fds {
+		if strings.HasPrefix(fd, self) {
+			continue
+		}
+		if link, err := os.Readlink(fd); err == nil && link == target {
+			count++
+		}
+	}
+	return count
+}
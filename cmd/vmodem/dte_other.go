@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+func watchDte(m *vm.Modem, name string, dtr bool) error {
+	return errors.New("DTE detection not supported on this platform")
+}
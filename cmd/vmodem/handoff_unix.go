@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// handoffHeader is sent along with the file descriptors during a warm restart
+type handoffHeader struct {
+	Listener bool     `json:"listener"`
+	Ptys     []string `json:"ptys"`
+}
+
+var handedOff atomic.Bool
+
+// draining is set while the calls are drained before handing off the PTYs: inbound calls are rejected
+var draining atomic.Bool
+
+func listenerFile(l net.Listener) (*os.File, error) {
+	tl, ok := l.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("unsupported listener type")
+	}
+	return tl.File()
+}
+
+// serveHandoff waits for a new vmodem process to connect to the handoff socket and
+// passes it the listening socket (and the PTYs when withPtys is set). Afterwards this
+// process stops accepting calls and exits once its active calls are finished. PTYs
+// can't be shared with active calls, so with withPtys the calls are drained first.
+func serveHandoff(path string, withPtys bool) {
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating handoff socket: %v\n", err)
+		return
+	}
+	defer os.Remove(path)
+	defer l.Close()
+	for ctx.Err() == nil {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		if withPtys {
+			fmt.Println("Handoff requested, draining active calls")
+			draining.Store(true)
+			waitCallsIdle()
+			if ctx.Err() != nil {
+				conn.Close()
+				return
+			}
+		}
+		err = sendHandoff(conn.(*net.UnixConn), withPtys)
+		conn.Close()
+		if err != nil {
+			draining.Store(false)
+			fmt.Fprintf(os.Stderr, "Error sending handoff: %v\n", err)
+			continue
+		}
+		break
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	handedOff.Store(true)
+	if listener != nil {
+		listener.Close()
+	}
+	if withPtys { // PTYs now belong to the new process
+		cancel()
+		return
+	}
+	fmt.Println("Handoff done, draining active calls")
+	waitCallsIdle()
+	cancel()
+}
+
+// waitCallsIdle waits until no modem has a call in progress or ctx is done. A modem can
+// start a new call while waiting for the next one, so all are checked again until they
+// are idle at the same time.
+func waitCallsIdle() {
+	for ctx.Err() == nil {
+		idle := true
+		for _, m := range modemList() {
+			if st := m.StatusSync(); st != vm.StatusIdle && st != vm.StatusClosed {
+				idle = false
+				m.WaitForStatus(ctx, vm.StatusIdle) // closed modems return at once
+			}
+		}
+		if idle {
+			return
+		}
+	}
+}
+
+func sendHandoff(conn *net.UnixConn, withPtys bool) error {
+	header := handoffHeader{}
+	files := []*os.File{}
+	if listener != nil {
+		f, err := listenerFile(listener)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		header.Listener = true
+		files = append(files, f)
+	}
+	if withPtys {
+		for _, p := range ptyList() {
+			header.Ptys = append(header.Ptys, p.Name())
+			files = append(files, p.Master(), p.Slave())
+		}
+	}
+	fds := []int{}
+	for _, f := range files {
+		fds = append(fds, int(f.Fd()))
+	}
+	data, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, _, err = conn.WriteMsgUnix(data, syscall.UnixRights(fds...), nil)
+	return err
+}
+
+// takeover connects to the handoff socket of a running vmodem process and
+// receives its listening socket and PTYs.
+func takeover(path string) (net.Listener, []*UnixPty, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	buf := make([]byte, 64*1024)
+	oob := make([]byte, syscall.CmsgSpace(4*512))
+	n, oobn, _, _, err := conn.(*net.UnixConn).ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := handoffHeader{}
+	if err := json.Unmarshal(buf[:n], &header); err != nil {
+		return nil, nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, nil, err
+	}
+	fds := []int{}
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			return nil, nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	if len(fds) != boolToInt(header.Listener)+2*len(header.Ptys) {
+		return nil, nil, fmt.Errorf("unexpected number of file descriptors")
+	}
+	var l net.Listener
+	if header.Listener {
+		f := os.NewFile(uintptr(fds[0]), "listener")
+		l, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		fds = fds[1:]
+	}
+	ptys := []*UnixPty{}
+	for i, name := range header.Ptys {
+		ptys = append(ptys, &UnixPty{
+			master: os.NewFile(uintptr(fds[2*i]), "ptmx"),
+			slave:  os.NewFile(uintptr(fds[2*i+1]), name),
+		})
+	}
+	return l, ptys, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
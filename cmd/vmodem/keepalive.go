@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// keepAliveConfig returns the TCP keepalive of incoming and dialed connections (zero value = Go defaults)
+func keepAliveConfig() net.KeepAliveConfig {
+	if options.KeepAlive <= 0 && options.KeepAliveCount <= 0 {
+		return net.KeepAliveConfig{}
+	}
+	period := time.Duration(options.KeepAlive) * time.Second
+	return net.KeepAliveConfig{Enable: true, Idle: period, Interval: period, Count: options.KeepAliveCount}
+}
+
+// registerKeepAlive registers the tcp, telnet and ws transports with the keepalive options,
+// so calls to vanished peers drop to NO CARRIER instead of hanging online
+func registerKeepAlive() {
+	ka := keepAliveConfig()
+	vm.RegisterTransport("tcp", &vm.TCPTransport{KeepAlive: ka})
+	vm.RegisterTransport("telnet", &vm.TCPTransport{KeepAlive: ka, Telnet: true})
+	vm.RegisterTransport("ws", &vm.WebSocketTransport{KeepAlive: ka})
+}
+
+// listenTCP creates a tcp listener applying the keepalive options to accepted connections
+func listenTCP(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAliveConfig: keepAliveConfig()}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
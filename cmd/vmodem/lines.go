@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+	"golang.org/x/sys/unix"
+)
+
+// dtrPollPeriod is the interval between reads of the PTY DTR line
+const dtrPollPeriod = 100 * time.Millisecond
+
+func ptyModemBits(tty *UnixPty) (int, error) {
+	var bits int
+	var ioErr error
+	err := tty.Control(func(fd uintptr) {
+		bits, ioErr = unix.IoctlGetInt(int(fd), unix.TIOCMGET)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return bits, ioErr
+}
+
+func setPtyModemBits(tty *UnixPty, bits int, on bool) error {
+	req := uint(unix.TIOCMBIC)
+	if on {
+		req = unix.TIOCMBIS
+	}
+	var ioErr error
+	err := tty.Control(func(fd uintptr) {
+		ioErr = unix.IoctlSetPointerInt(int(fd), req, bits)
+	})
+	if err != nil {
+		return err
+	}
+	return ioErr
+}
+
+// ptyLines implements vm.ControlLines driving the PTY carrier detect line
+type ptyLines struct {
+	id  string
+	tty *UnixPty
+}
+
+func (l *ptyLines) SetDCD(on bool) {
+	if err := setPtyModemBits(l.tty, unix.TIOCM_CD, on); err != nil && len(options.Verbose) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: Error setting DCD: %v\n", l.id, err)
+	}
+}
+
+// newPtyLines checks that the PTY has modem control lines (Linux PTYs have none)
+func newPtyLines(id string, tty *UnixPty) (*ptyLines, error) {
+	if _, err := ptyModemBits(tty); err != nil {
+		return nil, fmt.Errorf("PTY modem control lines not supported: %v", err)
+	}
+	return &ptyLines{id: id, tty: tty}, nil
+}
+
+// watchControlLines reports the PTY DTR line to the modem, which handles its drops as set with AT&D
+func watchControlLines(m *vm.Modem, tty *UnixPty) error {
+	bits, err := ptyModemBits(tty)
+	if err != nil {
+		return err
+	}
+	m.SetDTRSync(bits&unix.TIOCM_DTR != 0)
+	go func() {
+		dtr := bits&unix.TIOCM_DTR != 0
+		for ctx.Err() == nil {
+			time.Sleep(dtrPollPeriod)
+			bits, err := ptyModemBits(tty)
+			if err != nil { // PTY closed
+				return
+			}
+			if v := bits&unix.TIOCM_DTR != 0; v != dtr {
+				dtr = v
+				if len(options.Verbose) > 1 {
+					fmt.Printf("%s: DTR %v\n", m.Id(), dtr)
+				}
+				m.SetDTRSync(dtr)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// logger is the structured logger of the modems (nil with --log-level off)
+var logger *slog.Logger
+
+// newLogger creates the stderr logger for the --log-level and --log-json options
+func newLogger(level string, json bool) *slog.Logger {
+	var lvl slog.Level
+	switch level {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "info":
+		lvl = slog.LevelInfo
+	case "warn":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return nil
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if json {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// logTracer writes the spans of calls and AT commands to the structured logger (--trace-spans)
+type logTracer struct {
+	log *slog.Logger
+}
+
+type logSpan struct {
+	log   *slog.Logger
+	name  string
+	start time.Time
+	attrs []any
+}
+
+func (t logTracer) Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, vm.Span) {
+	s := &logSpan{log: t.log, name: name, start: time.Now()}
+	for _, a := range attrs {
+		s.attrs = append(s.attrs, a)
+	}
+	return ctx, s
+}
+
+func (s *logSpan) Event(name string, attrs ...slog.Attr) {
+	args := append([]any{"span", s.name, "event", name}, s.attrs...)
+	for _, a := range attrs {
+		args = append(args, a)
+	}
+	s.log.Debug("span event", args...)
+}
+
+func (s *logSpan) End(err error) {
+	args := append([]any{"span", s.name, "duration", time.Since(s.start)}, s.attrs...)
+	if err != nil {
+		args = append(args, "err", err)
+	}
+	s.log.Debug("span end", args...)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -16,7 +17,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/jaracil/nagle"
 	vm "github.com/jaracil/vmodem"
 	"github.com/jessevdk/go-flags"
 	t "github.com/nayarsystems/iotrace"
@@ -32,17 +32,87 @@ type Options struct {
 	NumTTYs          int      `short:"n" long:"num" description:"Number of TTYs to create" default:"1"`
 	RingMax          int      `short:"r" long:"ring" description:"Max number of rings before hangup" default:"10"`
 	NoListen         bool     `short:"X" long:"nolisten" description:"Do not listen for incoming calls"`
+	AllowExec        bool     `long:"allow-exec" description:"Allow translations to exec://program args targets, running the program as the call"`
+	Services         bool     `long:"services" description:"Answer the numbers ECHO, CHARGEN and TIME with built-in test services"`
+	WsAddr           string   `long:"ws-addr" description:"Listen for incoming calls over WebSocket (wss with --tls-cert). Format: host:port"`
+	TLSCert          string   `long:"tls-cert" description:"Certificate file (PEM) to accept incoming calls over TLS"`
+	TLSKey           string   `long:"tls-key" description:"Private key file (PEM) of the TLS certificate"`
+	TLSCA            string   `long:"tls-ca" description:"CA certificates file (PEM) verifying tls:// destinations (default system CAs)"`
+	TLSInsecure      bool     `long:"tls-insecure" description:"Do not verify the certificate of tls:// destinations"`
 	AnswerChar       string   `short:"S" long:"answer-char" description:"sends this character when the call is answered"`
+	HandshakeTimeout int      `long:"handshake-timeout" description:"Max seconds a dialed call waits for the answer character or speed negotiation" default:"10"`
+	NegotiateSpeed   bool     `long:"negotiate-speed" description:"Negotiate the line speed with the remote vmodem, calls connect at the lower of both"`
+	AbortChar        string   `short:"b" long:"abort-char" description:"only this character aborts dialing and pending commands (default any key)"`
 	NagleSize        int      `short:"N" long:"nagle-size" description:"size of the nagle buffer 0 = disabled" default:"1024"`
 	NagleTimeout     int      `short:"M" long:"nagle-timeout" description:"nagle timeout in milliseconds" default:"50"`
 	GuardTime        int      `short:"G" long:"guard-time" description:"guard time in 50ms increments" default:"20"`
+	EscapeChar       int      `long:"escape-char" description:"escape character code (S2), > 127 disables the escape sequence" default:"43"`
+	ParseDiag        bool     `long:"parse-diagnostics" description:"Show the offending character and cause before the ERROR of malformed command lines"`
+	SRegPolicy       string   `long:"sreg-policy" description:"Handling of S-registers without a meaning: store them, ignore writes or reject with ERROR" choice:"store" choice:"ignore" choice:"reject" default:"store"`
+	TIES             bool     `long:"ties" description:"escape with +++ followed by an AT command line and no guard times (TIES)"`
 	DisablePreGuard  bool     `short:"D" long:"disable-pre-guard" description:"disable pre-guard time for buggy implementations"`
 	DisablePostGuard bool     `short:"P" long:"disable-post-guard" description:"disable post-guard time for buggy implementations"`
 	Command          []string `short:"C" long:"command" description:"Command hook. Format: regexp->response->result"`
-	Translate        []string `short:"T" long:"translate" description:"Translate phone number to host. Format: regexp->format"`
+	ResultCode       []string `long:"result-code" description:"Register a result code usable by command hooks. Format: TEXT->number (number sent in ATV0 mode)"`
+	Translate        []string `short:"T" long:"translate" description:"Translate phone number to host. Format: regexp->format[->HH:MM-HH:MM]"`
+	DialPlan         []string `short:"d" long:"dialplan" description:"Dial plan rule applied before translations. Format: regexp->rewrite[->route[->HH:MM-HH:MM]]"`
 	Attach           []string `short:"A" long:"attach" description:"Attach two TTY's. Format: tty1:tty2:speed,data_bits,parity,stop_bits"`
-	Metrics          string   `short:"m" long:"metrics" description:"Enable metrics http server. Format: host:port"`
+	Metrics          string   `short:"m" long:"metrics" description:"Enable metrics http server (Prometheus format at /metrics). Format: host:port"`
+	ApiToken         string   `long:"api-token" description:"Enable the management REST API (list modems, hang up, incoming calls, metrics) and the watch, commands and history endpoints on the metrics server, requiring this bearer token"`
 	Watchdog         int      `short:"w" long:"watchdog" description:"Connection timeout in seconds (0 = disabled)" default:"0"`
+	KeepAlive        int      `long:"keepalive" description:"TCP keepalive idle time and probe interval in seconds of incoming and dialed connections (0 = Go defaults, 15 s)" default:"0"`
+	KeepAliveCount   int      `long:"keepalive-count" description:"Unanswered TCP keepalive probes before dropping the connection (0 = Go default, 9)" default:"0"`
+	PingInterval     int      `long:"ping-interval" description:"Ping calls over WebSocket every this many seconds, dropping them to NO CARRIER when unanswered (0 = disabled)" default:"0"`
+	PingTimeout      int      `long:"ping-timeout" description:"Max seconds to wait for the answer of a ping (0 = ping interval)" default:"0"`
+	TestDial         string   `long:"test-dial" description:"Number dialed at startup to check upstream connectivity (exit code 2 on failure)"`
+	TestDialInterval int      `long:"test-dial-interval" description:"Repeat the test dial every this many seconds (0 = only at startup)" default:"0"`
+	CmdRate          float64  `long:"cmd-rate" description:"Max AT command lines processed per second (0 = unlimited)" default:"0"`
+	EchoRate         float64  `long:"echo-rate" description:"Max echoed bytes per second in command mode (0 = unlimited)" default:"0"`
+	LineSpeed        int      `long:"line-speed" description:"Simulated line speed in bps for calls (0 = unlimited)" default:"0"`
+	ErrorCorrection  bool     `long:"error-correction" description:"Report calls as error corrected (CONNECT <speed>/ARQ, see AT\\V)"`
+	Compression      bool     `long:"compression" description:"Report calls as compressed (CONNECT <speed>/V42BIS, see AT\\V)"`
+	Transparent      bool     `long:"transparent" description:"Negotiate an 8-bit clean call without escape sequence with vmodem peers (dialed calls need --answer-char)"`
+	RemoteLoopback   bool     `long:"remote-loopback" description:"Grant the remote digital loopback requested by a vmodem peer (AT&T4, see AT&T6)"`
+	DialTimeout      int      `long:"dial-timeout" description:"Max seconds from ATD to CONNECT, also when S7 is 0 (0 = S7 only)" default:"0"`
+	PreserveCase     bool     `long:"preserve-dial-case" description:"Keep the case of dialed numbers (hostnames, URLs) instead of upper-casing them"`
+	RedialAttempts   int      `long:"redial-attempts" description:"Redial the last number this many times after a failed dial (0 = disabled)" default:"0"`
+	RedialDelay      int      `long:"redial-delay" description:"Seconds before the first redial, doubling on every attempt up to 8 times this value" default:"5"`
+	RedialOnLoss     bool     `long:"redial-on-carrier-loss" description:"Also redial when an outgoing call loses carrier"`
+	HandshakeTime    int      `long:"handshake-time" description:"Carrier negotiation time in milliseconds before CONNECT on answered and dialed calls (0 = instant)" default:"0"`
+	AutoAnswer       int      `long:"auto-answer" description:"Rings before answering incoming calls, factory value of S0 (0 = disabled)" default:"0"`
+	IdleTimeout      int      `long:"idle-timeout" description:"Hang up calls without data for this many seconds, factory value of S30 in 10 s units (0 = disabled)" default:"0"`
+	MaxCallDuration  int      `long:"max-call-duration" description:"Hang up calls lasting longer than this many seconds (0 = unlimited)" default:"0"`
+	Telnet           bool     `long:"telnet" description:"Speak Telnet protocol on calls by default (AT+TELNET)"`
+	Rfc2217          bool     `long:"rfc2217" description:"Speak RFC 2217 (Telnet COM port control) on incoming calls, like a serial device server"`
+	OpLog            bool     `short:"O" long:"oplog" description:"Log result codes, dialed numbers and key events in a compact one-line format"`
+	LogLevel         string   `long:"log-level" description:"Structured log level of modem status changes, commands, calls and errors, written to stderr" choice:"off" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"off"`
+	LogJSON          bool     `long:"log-json" description:"Write structured logs as JSON instead of key=value text"`
+	TraceSpans       bool     `long:"trace-spans" description:"Log spans of calls and AT commands with their duration at debug level"`
+	Expvar           bool     `long:"expvar" description:"Publish modem metrics with expvar, served at /debug/vars by the metrics server"`
+	CdrFile          string   `long:"cdr-file" description:"Append a JSON call detail record (modem, direction, number, duration, bytes, reason) to this file for every completed call"`
+	CdrURL           string   `long:"cdr-url" description:"POST the JSON call detail record of every completed call to this webhook URL"`
+	RecordDir        string   `long:"record-dir" description:"Record the timestamped traffic of every call in this directory (one .vmrec file per call)"`
+	Supervise        int      `long:"supervise" description:"Recreate modems stuck for this many seconds (0 = disabled)" default:"0"`
+	LinkPreset       string   `short:"Q" long:"link-preset" description:"Link quality preset (v21-300, v22bis-2400, v32-9600, v34-33k6)"`
+	Deny             []string `long:"deny" description:"Reject incoming calls from this IP address or network (a.b.c.d/nn)"`
+	BusyNoDte        bool     `long:"busy-no-dte" description:"Refuse incoming calls on TTYs not opened by any DTE"`
+	ControlLines     bool     `long:"control-lines" description:"Drive DCD and read DTR through the PTY modem control lines (TIOCM), where the platform PTYs have them"`
+	DtrFromDte       bool     `long:"dtr-from-dte" description:"Map TTY open/close by the DTE to DTR (closing the TTY hangs up)"`
+	Hunt             string   `long:"hunt" description:"Modem chosen for incoming calls" choice:"first-free" choice:"round-robin" choice:"least-recent" default:"first-free"`
+	QueueLen         int      `long:"queue-len" description:"Max number of inbound calls held while all modems are busy (0 = disabled)" default:"0"`
+	QueueWait        int      `long:"queue-wait" description:"Max seconds an inbound call waits in queue (0 = unlimited)" default:"60"`
+	QueueBanner      string   `long:"queue-banner" description:"Banner sent to queued inbound calls"`
+	BusyBanner       string   `long:"busy-banner" description:"Banner sent to inbound calls rejected because all modems are busy (e.g. BUSY)"`
+	BusyHold         int      `long:"busy-hold" description:"Seconds rejected inbound calls are held open before closing" default:"0"`
+	Overflow         string   `long:"overflow" description:"Forward inbound calls to this host:port when all modems are busy"`
+	ClusterPeers     []string `long:"cluster-peer" description:"Metrics address (host:port) of a peer vmodem sharing the bank. Requires metrics server"`
+	ClusterAdvertise string   `long:"cluster-advertise" description:"Address advertised to cluster peers for incoming calls (default listen address)"`
+	Handoff          string   `long:"handoff" description:"Unix socket path where a new process can take over the listener for a warm restart"`
+	HandoffPtys      bool     `long:"handoff-ptys" description:"Also hand off PTYs on warm restart (waits for active calls to end, rejecting new ones)"`
+	Takeover         string   `long:"takeover" description:"Take over listener (and PTYs) from the vmodem process serving this handoff socket"`
+	HistoryInterval  int      `short:"H" long:"history-interval" description:"Metrics history sampling interval in seconds (0 = disabled)" default:"0"`
+	HistoryLen       int      `short:"L" long:"history-len" description:"Max number of metrics history samples per modem" default:"1440"`
+	Config           string   `short:"c" long:"config" description:"YAML file with per modem settings (name, ring max, answer char, guard time, translations, commands...)"`
 }
 
 type Command struct {
@@ -69,6 +139,8 @@ type MetricsResponse struct {
 	NumInConns int `json:"numInCons"`
 	// NumOutConns is the number of outgoing accumulated connections
 	NumOutConns int `json:"numOutCons"`
+	// NumFailedDials is the number of accumulated dials that did not connect
+	NumFailedDials int `json:"numFailedDials"`
 	// LastTtyRxMs is the time in milliseconds since the last byte received from the tty
 	LastTtyRxMs int64 `json:"lastTtyRxMs"`
 	// LastTtyTxMs is the time in milliseconds since the last byte transmitted to the tty
@@ -77,6 +149,20 @@ type MetricsResponse struct {
 	LastAtCmdMs int64 `json:"lastAtCmdMs"`
 	// LastConnMs is the time in milliseconds since the last connection (online)
 	LastConnMs int64 `json:"lastConnMs"`
+	// LastRemote is the remote address (or number) of the last connection
+	LastRemote string `json:"lastRemote"`
+	// Commands is the number of times each AT command has been processed
+	Commands map[string]int `json:"commands"`
+	// SRegReads is the number of S-register queries
+	SRegReads int `json:"sRegReads"`
+	// SRegWrites is the number of S-register assignments
+	SRegWrites int `json:"sRegWrites"`
+	// HookHandledCmds is the number of commands handled by the command hook
+	HookHandledCmds int `json:"hookHandledCmds"`
+	// CmdErrors is the number of command lines that returned ERROR
+	CmdErrors int `json:"cmdErrors"`
+	// ThrottledEvents is the number of times command processing or echo was throttled
+	ThrottledEvents int `json:"throttledEvents"`
 }
 
 func NewCommand(reStr, format string, result vm.RetCode) (*Command, error) {
@@ -92,79 +178,79 @@ func NewCommand(reStr, format string, result vm.RetCode) (*Command, error) {
 	}, nil
 }
 
-type NumToHost struct {
-	Format string
-	ReStr  string
-	re     *regexp.Regexp
-}
+var (
+	ctx         context.Context
+	cancel      context.CancelFunc
+	options     Options
+	modems      []*vm.Modem
+	pool        *vm.ModemPool
+	ptys        []*UnixPty
+	linkQuality *vm.LinkQuality
+	recorders   = map[string]*vm.MetricsRecorder{}
+	attached1   []serial.Port
+	attached2   []serial.Port
+	listener    net.Listener
+	phonebook   *vm.DialPlan
+	commands    []*Command
+	tini        = time.Now()
+)
 
-func NewNumToHost(reStr, format string) (*NumToHost, error) {
-	re, err := regexp.Compile(reStr)
-	if err != nil {
-		return nil, err
+// resolveNumber runs the dialed number through the dial plan, IPv6 numbers and translations
+// returning the resulting number and host:port ("" if no host found). _service._proto.domain
+// numbers are returned as is, they are resolved with a SRV lookup when dialed.
+func resolveNumber(pb *vm.DialPlan, number string) (string, string) {
+	number, host := applyDialPlan(number)
+	if host == "" {
+		host = decodeIPv6Number(number)
 	}
-	return &NumToHost{
-		Format: format,
-		ReStr:  reStr,
-		re:     re,
-	}, nil
-}
-
-func (n *NumToHost) Match(num string) string {
-	m := n.re.FindStringSubmatch(num)
-	if len(m) == 0 {
-		return ""
+	if host == "" {
+		host = pb.Lookup(number)
+	}
+	if host == "" && srvNameRe.MatchString(number) {
+		host = number
 	}
-	var as []interface{}
-	for _, v := range m[1:] {
-		as = append(as, v)
+	if host != "" {
+		host = defaultPort(host)
 	}
-	return fmt.Sprintf(n.Format, as...)
+	return number, host
 }
 
-var (
-	ctx        context.Context
-	cancel     context.CancelFunc
-	options    Options
-	modems     []*vm.Modem
-	attached1  []serial.Port
-	attached2  []serial.Port
-	listener   net.Listener
-	numToHosts []*NumToHost
-	commands   []*Command
-	tini       = time.Now()
-)
-
-func findHost(num string) string {
-	for _, n := range numToHosts {
-		host := n.Match(num)
-		if host != "" {
-			return host
+// defaultPort adds the default port to host:port targets without port, IPv6 literals
+// included (addr, [addr] or [addr]:)
+func defaultPort(target string) string {
+	if _, _, ok := splitSRV(target); ok {
+		return target
+	}
+	scheme, addr := vm.SplitTarget(target)
+	if scheme != "tcp" && scheme != "tls" && scheme != "telnet" {
+		return target
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		if err != nil {
+			host = strings.Trim(addr, "[]")
 		}
+		addr = net.JoinHostPort(host, options.DefaultPort)
+		if strings.Contains(target, "://") {
+			return scheme + "://" + addr
+		}
+		return addr
 	}
-	return ""
+	return target
 }
 
-func outGoingCall(m *vm.Modem, number string) (io.ReadWriteCloser, error) {
-	host := findHost(number)
+func outGoingCall(ctx context.Context, m *vm.Modem, number string) (io.ReadWriteCloser, error) {
+	number, host := resolveNumber(m.DialPlan(), number)
+	opLog(m, "DIAL %s -> %s", number, ternaryStr(host != "", host, "no host"))
 	if host != "" {
-		if !strings.Contains(host, ":") {
-			host = fmt.Sprintf("%s:%s", host, options.DefaultPort)
-		}
 		if len(options.Verbose) > 0 {
 			fmt.Printf("%s: Dialing %s -> %s\n", m.Id(), number, host)
 		}
-		conn, err := net.Dial("tcp", host)
+		conn, err := dialHost(ctx, host)
 		if err != nil {
 			return nil, err
 		}
-		var connWrapp io.ReadWriteCloser
-		if options.NagleSize > 0 {
-			connWrapp = nagle.NewNagleWrapper(conn, options.NagleSize, time.Millisecond*time.Duration(options.NagleTimeout))
-		} else {
-			connWrapp = conn
-		}
-		return connWrapp, nil
+		return conn, nil
 	}
 	if len(options.Verbose) > 0 {
 		fmt.Printf("%s: Dialing %s -> no host found\n", m.Id(), number)
@@ -186,7 +272,7 @@ func commandHook(m *vm.Modem, cmdChar string, cmdNum string, cmdAssign bool, cmd
 	if cmdAssignVal != "" {
 		cmd += cmdAssignVal
 	}
-	for _, c := range commands {
+	for _, c := range modemCommands(m) {
 		if c.re.MatchString(cmd) {
 			if c.Output != "" {
 				m.TtyWriteStr(fmt.Sprintf("\r\n%s\r\n", c.Output))
@@ -198,20 +284,63 @@ func commandHook(m *vm.Modem, cmdChar string, cmdNum string, cmdAssign bool, cmd
 }
 
 func statusTransition(m *vm.Modem, oldStatus vm.ModemStatus, newStatus vm.ModemStatus) {
+	markStatus(m)
+	comPortStatus(m, newStatus)
+	opLog(m, "STATUS %v -> %v", oldStatus, newStatus)
 	if len(options.Verbose) > 0 {
 		fmt.Printf("%s: Status transition %v -> %v\n", m.Id(), oldStatus, newStatus)
 	}
 }
 
+// sregPolicies maps the --sreg-policy choices to the S-register policies
+var sregPolicies = map[string]vm.SRegPolicy{
+	"store":  vm.SRegStore,
+	"ignore": vm.SRegIgnore,
+	"reject": vm.SRegReject,
+}
+
+// breakHook reports breaks received from the remote side (PTYs can't reproduce them)
+func breakHook(m *vm.Modem) {
+	opLog(m, "BREAK")
+	if len(options.Verbose) > 0 {
+		fmt.Printf("%s: Break received\n", m.Id())
+	}
+}
+
+func errorHook(m *vm.Modem, err error) {
+	fmt.Fprintf(os.Stderr, "%s: %v\n", m.Id(), err)
+}
+
+func resultHook(m *vm.Modem, ret vm.RetCode, text string) {
+	opLog(m, "RESULT %s", text)
+}
+
+// opLog writes a compact one-line operator log entry when enabled
+func opLog(m *vm.Modem, format string, args ...interface{}) {
+	if !options.OpLog {
+		return
+	}
+	fmt.Printf("%s %s %s\n", time.Now().Format("2006-01-02 15:04:05.000"), m.Id(), fmt.Sprintf(format, args...))
+}
+
+func ternaryStr(cond bool, val1, val2 string) string {
+	if cond {
+		return val1
+	}
+	return val2
+}
+
 func cleanTTYs() {
 	for i := 0; i < options.NumTTYs; i++ {
-		os.Remove(fmt.Sprintf("%s/tty%d", options.TtyPath, options.StartNum+i))
+		os.Remove(fmt.Sprintf("%s/%s", options.TtyPath, modemName(i)))
 	}
 }
 
 func cleanModems() {
-	for i := 0; i < options.NumTTYs; i++ {
-		modems[i].CloseSync()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, m := range modemList() {
+		m.Shutdown(shutdownCtx)
 	}
 }
 
@@ -226,40 +355,80 @@ func cleanAttached() {
 
 func listenTask() {
 	// TCP server
-	var err error
-	listener, err = net.Listen("tcp", options.ListenAddr)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating listener: %v\n", err)
-		cancel()
-		return
+	if listener == nil {
+		var err error
+		listener, err = listenTCP(options.ListenAddr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating listener: %v\n", err)
+			cancel()
+			return
+		}
 	}
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			cancel()
+			if !handedOff.Load() {
+				cancel()
+			}
 			break
 		}
-		var connWrapp io.ReadWriteCloser
-		if options.NagleSize > 0 {
-			connWrapp = nagle.NewNagleWrapper(conn, options.NagleSize, time.Millisecond*time.Duration(options.NagleTimeout))
-		} else {
-			connWrapp = conn
+		if tlsServerConfig != nil {
+			conn = tls.Server(conn, tlsServerConfig)
 		}
-		assigned := false
-		// Find a free modem
-		for i := 0; i < options.NumTTYs; i++ {
-			if err := modems[i].IncomingCallSync(connWrapp); err == nil {
-				assigned = true
-				break
-			}
+		incomingConn(conn)
+	}
+}
+
+// incomingConn delivers an inbound connection to a free modem or handles it as busy
+func incomingConn(conn net.Conn) {
+	var connWrapp io.ReadWriteCloser = conn
+	if options.Rfc2217 {
+		connWrapp = newComPort(connWrapp)
+	}
+	if draining.Load() {
+		go rejectCall(connWrapp)
+		return
+	}
+	if inQueue.len() == 0 && assignCall(connWrapp) {
+		return
+	}
+	go busyCall(conn, connWrapp)
+}
+
+// busyCall handles an inbound call when no local modem is free: it is routed to a
+// cluster peer, queued, forwarded to the overflow address or rejected, in that order.
+func busyCall(conn net.Conn, connWrapp io.ReadWriteCloser) {
+	if len(options.ClusterPeers) > 0 {
+		if addr := clusterRoute(); addr != "" {
+			forwardCall(conn, addr)
+			return
 		}
-		if !assigned {
-			connWrapp.Close()
-			fmt.Fprintf(os.Stderr, "No free modems for incomming call\n")
+	}
+	if options.QueueLen > 0 && inQueue.push(connWrapp) {
+		if len(options.Verbose) > 0 {
+			fmt.Printf("No free modems, incoming call queued\n")
 		}
+	} else if options.Overflow != "" {
+		forwardCall(conn, options.Overflow)
+	} else {
+		fmt.Fprintf(os.Stderr, "No free modems for incomming call\n")
+		rejectCall(connWrapp)
 	}
 }
 
+// rejectCall sends the busy banner to a rejected inbound call and closes it after the hold time,
+// so calling modems report BUSY instead of NO CARRIER
+func rejectCall(conn io.ReadWriteCloser) {
+	if options.BusyBanner != "" {
+		conn.Write([]byte(options.BusyBanner + "\r\n"))
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Duration(options.BusyHold) * time.Second):
+	}
+	conn.Close()
+}
+
 func linkPorts(port1, port2 serial.Port) {
 	go func() {
 		io.Copy(port1, port2)
@@ -356,65 +525,69 @@ func attachTTY(cfgStr string) error {
 	return nil
 }
 
-func phoneTranslations() {
-	defaultNumToHost, err := NewNumToHost("\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
-	}
-	numToHosts = append(numToHosts, defaultNumToHost)
-	defaultNumToHost, err = NewNumToHost("\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
-	}
-	numToHosts = append(numToHosts, defaultNumToHost)
-	defaultNumToHost, err = NewNumToHost("(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3}):(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
-	}
-	numToHosts = append(numToHosts, defaultNumToHost)
-	defaultNumToHost, err = NewNumToHost("(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating default NumToHost: %v\n", err)
-		os.Exit(1)
+// newPhonebook creates a dial plan with the translations. Format: regexp->format[->HH:MM-HH:MM]
+func newPhonebook(translations []string) (*vm.DialPlan, error) {
+	pb := vm.DefaultDialPlan(options.DefaultPort)
+	if options.Services {
+		pb.AddServices()
 	}
-	numToHosts = append(numToHosts, defaultNumToHost)
-	for _, t := range options.Translate {
+	for _, t := range translations {
 		parts := strings.Split(t, "->")
+		if len(parts) != 2 && len(parts) != 3 {
+			return nil, fmt.Errorf("invalid translation: %s", t)
+		}
+		var window *vm.TimeWindow
+		if len(parts) == 3 {
+			var err error
+			window, err = vm.ParseTimeWindow(parts[2])
+			if err != nil {
+				return nil, fmt.Errorf("invalid translation: %v", err)
+			}
+		}
+		if err := pb.Add(parts[0], parts[1], window); err != nil {
+			return nil, fmt.Errorf("error creating translation: %v", err)
+		}
+	}
+	return pb, nil
+}
+
+// registerResultCodes registers the --result-code result codes. Format: TEXT->number
+func registerResultCodes(list []string) error {
+	for _, rc := range list {
+		parts := strings.Split(rc, "->")
 		if len(parts) != 2 {
-			fmt.Fprintf(os.Stderr, "Invalid translation: %s\n", t)
-			os.Exit(1)
+			return fmt.Errorf("invalid result code: %s", rc)
 		}
-		numToHost, err := NewNumToHost(parts[0], parts[1])
+		code, err := strconv.Atoi(parts[1])
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating NumToHost: %v\n", err)
-			os.Exit(1)
+			return fmt.Errorf("invalid result code number: %s", parts[1])
+		}
+		if _, err := vm.RegisterRetCode(parts[0], code); err != nil {
+			return fmt.Errorf("error registering result code %s: %v", parts[0], err)
 		}
-		numToHosts = append(numToHosts, numToHost)
 	}
+	return nil
 }
 
-func customCommands() {
-	for _, c := range options.Command {
+// parseCommands creates the command hooks. Format: regexp->response->result
+func parseCommands(list []string) ([]*Command, error) {
+	var cmds []*Command
+	for _, c := range list {
 		parts := strings.Split(c, "->")
 		if len(parts) != 3 {
-			fmt.Fprintf(os.Stderr, "Invalid command: %s\n", c)
-			os.Exit(1)
+			return nil, fmt.Errorf("invalid command: %s", c)
 		}
 		cmdRet := vm.CmdReturnFromString(parts[2])
 		if cmdRet == vm.RetCodeUnknown {
-			fmt.Fprintf(os.Stderr, "Invalid command return: %s\n", parts[2])
-			os.Exit(1)
+			return nil, fmt.Errorf("invalid command return: %s", parts[2])
 		}
 		cmd, err := NewCommand(parts[0], parts[1], cmdRet)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating command: %v\n", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("error creating command: %v", err)
 		}
-		commands = append(commands, cmd)
+		cmds = append(cmds, cmd)
 	}
+	return cmds, nil
 }
 
 type bytesHookFunc func([]byte)
@@ -428,7 +601,7 @@ func newModemTraceHook(prefix string) bytesHookFunc {
 func enableWatchdog(timeout int) {
 	go func() {
 		for ctx.Err() == nil {
-			for _, m := range modems {
+			for _, m := range modemList() {
 				metrics := m.MetricsSync()
 				if metrics.Status != vm.StatusConnected {
 					continue
@@ -452,6 +625,58 @@ func enableWatchdog(timeout int) {
 	}()
 }
 
+type watchChunk struct {
+	marker string
+	data   []byte
+}
+
+// watchModem streams a live read-only view of the modem traffic with direction markers.
+func watchModem(w http.ResponseWriter, r *http.Request, m *vm.Modem) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	rxTap := m.TapSync(vm.TapRx)
+	defer rxTap.Close()
+	txTap := m.TapSync(vm.TapTx)
+	defer txTap.Close()
+
+	chunks := make(chan watchChunk, 64)
+	pump := func(marker string, rc io.Reader) {
+		buff := make([]byte, 1024)
+		for {
+			n, err := rc.Read(buff)
+			if err != nil {
+				return
+			}
+			select {
+			case chunks <- watchChunk{marker: marker, data: append([]byte(nil), buff[:n]...)}:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	go pump("<<", rxTap)
+	go pump(">>", txTap)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Watching %s (<< from remote, >> to remote)\n", m.Id())
+	flusher.Flush()
+	for {
+		select {
+		case c := <-chunks:
+			fmt.Fprintf(w, "%s %q\n", c.marker, c.data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func enableMetrics(addr string) {
 	http.HandleFunc("/proc", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -459,6 +684,102 @@ func enableMetrics(addr string) {
 		json.NewEncoder(w).Encode(map[string]interface{}{"uptime": time.Since(tini).String()})
 	})
 
+	http.HandleFunc("/cluster", clusterHandler)
+
+	http.HandleFunc("/metrics", promMetricsHandler)
+
+	// handleAPI registers the handlers exposing call traffic and typed commands behind the API
+	// token, they are refused without one
+	handleAPI := func(pattern string, handler http.HandlerFunc) {
+		if options.ApiToken == "" {
+			http.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "disabled, requires --api-token", http.StatusForbidden)
+			})
+			return
+		}
+		http.HandleFunc(pattern, apiAuth(options.ApiToken, handler))
+	}
+
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		healthy := options.TestDial == "" || lastTestDial().Ok
+		reports := map[string]*vm.HealthReport{}
+		for _, m := range modemList() {
+			hctx, hcancel := context.WithTimeout(r.Context(), time.Second)
+			report := m.Healthy(hctx)
+			hcancel()
+			reports[m.Id()] = report
+			healthy = healthy && report.Healthy
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		resp := map[string]interface{}{"healthy": healthy, "modems": reports}
+		if options.TestDial != "" {
+			resp["testDial"] = lastTestDial()
+		}
+		json.NewEncoder(w).Encode(resp)
+	})
+
+	handleAPI("/watch/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/watch/")
+		var modem *vm.Modem
+		for _, m := range modemList() {
+			if m.Id() == id {
+				modem = m
+				break
+			}
+		}
+		if modem == nil {
+			http.Error(w, "modem not found", http.StatusNotFound)
+			return
+		}
+		watchModem(w, r, modem)
+	})
+
+	handleAPI("/commands/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/commands/")
+		for _, m := range modemList() {
+			if m.Id() != id {
+				continue
+			}
+			type commandResponse struct {
+				Time   time.Time `json:"time"`
+				Cmd    string    `json:"cmd"`
+				Result string    `json:"result"`
+			}
+			list := []commandResponse{}
+			for _, c := range m.CommandHistorySync() {
+				list = append(list, commandResponse{Time: c.Time, Cmd: c.Cmd, Result: c.Result.String()})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		http.Error(w, "modem not found", http.StatusNotFound)
+	})
+
+	handleAPI("/history/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/history/")
+		recorder, ok := modemRecorder(id)
+		if !ok {
+			http.Error(w, "history not found", http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			w.WriteHeader(http.StatusOK)
+			recorder.WriteCSV(w)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		recorder.WriteJSON(w)
+	})
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		metricsList := make([]MetricsResponse, 0)
 		ternary := func(cond bool, val1, val2 int64) int64 {
@@ -467,21 +788,29 @@ func enableMetrics(addr string) {
 			}
 			return val2
 		}
-		for _, m := range modems {
+		for _, m := range modemList() {
 			metrics := m.MetricsSync()
 			response := MetricsResponse{
-				ModemId:     m.Id(),
-				TtyTxBytes:  metrics.TtyTxBytes,
-				TtyRxBytes:  metrics.TtyRxBytes,
-				ConnTxBytes: metrics.ConnTxBytes,
-				ConnRxBytes: metrics.ConnRxBytes,
-				NumConns:    metrics.NumConns,
-				NumInConns:  metrics.NumInConns,
-				NumOutConns: metrics.NumOutConns,
-				LastTtyRxMs: ternary(metrics.LastTtyRxTime.IsZero(), -1, int64(time.Since(metrics.LastTtyRxTime)/time.Millisecond)),
-				LastTtyTxMs: ternary(metrics.LastTtyTxTime.IsZero(), -1, int64(time.Since(metrics.LastTtyTxTime)/time.Millisecond)),
-				LastAtCmdMs: ternary(metrics.LastAtCmdTime.IsZero(), -1, int64(time.Since(metrics.LastAtCmdTime)/time.Millisecond)),
-				LastConnMs:  ternary(metrics.LastConnTime.IsZero(), -1, int64(time.Since(metrics.LastConnTime)/time.Millisecond)),
+				ModemId:         m.Id(),
+				TtyTxBytes:      metrics.TtyTxBytes,
+				TtyRxBytes:      metrics.TtyRxBytes,
+				ConnTxBytes:     metrics.ConnTxBytes,
+				ConnRxBytes:     metrics.ConnRxBytes,
+				NumConns:        metrics.NumConns,
+				NumInConns:      metrics.NumInConns,
+				NumOutConns:     metrics.NumOutConns,
+				NumFailedDials:  metrics.NumFailedDials,
+				LastTtyRxMs:     ternary(metrics.LastTtyRxTime.IsZero(), -1, int64(time.Since(metrics.LastTtyRxTime)/time.Millisecond)),
+				LastTtyTxMs:     ternary(metrics.LastTtyTxTime.IsZero(), -1, int64(time.Since(metrics.LastTtyTxTime)/time.Millisecond)),
+				LastAtCmdMs:     ternary(metrics.LastAtCmdTime.IsZero(), -1, int64(time.Since(metrics.LastAtCmdTime)/time.Millisecond)),
+				LastConnMs:      ternary(metrics.LastConnTime.IsZero(), -1, int64(time.Since(metrics.LastConnTime)/time.Millisecond)),
+				LastRemote:      metrics.LastRemote,
+				Commands:        metrics.Commands,
+				SRegReads:       metrics.SRegReads,
+				SRegWrites:      metrics.SRegWrites,
+				HookHandledCmds: metrics.HookHandledCmds,
+				CmdErrors:       metrics.CmdErrors,
+				ThrottledEvents: metrics.ThrottledEvents,
 			}
 			metricsList = append(metricsList, response)
 		}
@@ -500,6 +829,137 @@ func enableMetrics(addr string) {
 
 }
 
+// newModem creates the modem number i on top of tty and publishes its TTY symlink
+func newModem(i int, tty *UnixPty) (*vm.Modem, error) {
+	id := modemName(i)
+	pb, err := modemPhonebook(i)
+	if err != nil {
+		return nil, err
+	}
+	var rwc io.ReadWriteCloser
+	if len(options.Verbose) > 2 {
+		rwc = t.NewRWCTracer(tty, 16, time.Millisecond*time.Duration(options.NagleTimeout),
+			newModemTraceHook(fmt.Sprintf("%s-w", id)),
+			newModemTraceHook(fmt.Sprintf("%s-r", id)),
+		)
+	} else {
+		rwc = tty
+	}
+
+	cfg := &vm.ModemConfig{
+		Id:               id,
+		OutgoingCallCtx:  outGoingCall,
+		DialPlan:         pb,
+		CommandHook:      commandHook,
+		StatusTransition: statusTransition,
+		ResultHook:       resultHook,
+		ErrorHook:        errorHook,
+		BreakHook:        breakHook,
+		TTY:              rwc,
+		RingMax:          options.RingMax,
+		AutoAnswerRings:  options.AutoAnswer,
+		AnswerChar:       options.AnswerChar,
+		AbortChar:        options.AbortChar,
+		GuardTime:        options.GuardTime,
+		EscapeChar:       options.EscapeChar,
+		TIES:             options.TIES,
+		SRegPolicy:       sregPolicies[options.SRegPolicy],
+		ParseDiagnostics: options.ParseDiag,
+		DisablePreGuard:  options.DisablePreGuard,
+		DisablePostGuard: options.DisablePostGuard,
+		BulkMode:         options.NagleSize > 0,
+		WriteBufferSize:  options.NagleSize,
+		CoalesceDelay:    time.Duration(options.NagleTimeout) * time.Millisecond,
+		LinkQuality:      linkQuality,
+		BusyOnNoDTE:      options.BusyNoDte,
+		ProfileStore:     &vm.FileProfileStore{Dir: options.TtyPath},
+		Telnet:           options.Telnet,
+		LineSpeed:        options.LineSpeed,
+		ErrorCorrection:  options.ErrorCorrection,
+		Compression:      options.Compression,
+		RemoteLoopback:   options.RemoteLoopback,
+		Transparent:      options.Transparent,
+		HandshakeTime:    time.Duration(options.HandshakeTime) * time.Millisecond,
+		DialTimeout:      time.Duration(options.DialTimeout) * time.Second,
+		PreserveDialCase: options.PreserveCase,
+		IdleTimeout:      time.Duration(options.IdleTimeout) * time.Second,
+		MaxCallDuration:  time.Duration(options.MaxCallDuration) * time.Second,
+		CmdRateLimit:     options.CmdRate,
+		EchoRateLimit:    options.EchoRate,
+		Logger:           logger,
+		RecordSessions:   options.RecordDir,
+		PingInterval:     time.Duration(options.PingInterval) * time.Second,
+		PingTimeout:      time.Duration(options.PingTimeout) * time.Second,
+		Expvar:           options.Expvar,
+	}
+	if cdrQueue != nil {
+		cfg.CallEnded = callEnded
+	}
+	if options.TraceSpans && logger != nil {
+		cfg.Tracer = logTracer{log: logger}
+	}
+	if len(denyNets) > 0 {
+		cfg.IncomingCallFilter = incomingCallFilter
+	}
+	if options.ControlLines {
+		lines, err := newPtyLines(id, tty)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ControlLines = lines
+	}
+	if options.RedialAttempts > 0 {
+		cfg.Redial = &vm.RedialPolicy{
+			Attempts:      options.RedialAttempts,
+			Delay:         time.Duration(options.RedialDelay) * time.Second,
+			MaxDelay:      8 * time.Duration(options.RedialDelay) * time.Second,
+			OnCarrierLoss: options.RedialOnLoss,
+		}
+	}
+	applyFileConfig(i, cfg)
+	if cfg.AnswerChar != "" || options.NegotiateSpeed {
+		cfg.Handshake = &vm.Handshake{
+			Token:     cfg.AnswerChar,
+			Timeout:   time.Duration(options.HandshakeTimeout) * time.Second,
+			Negotiate: options.NegotiateSpeed,
+		}
+		cfg.AnswerChar = ""
+	}
+	m, err := vm.NewModem(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating modem: %v", err)
+	}
+	if options.BusyNoDte || options.DtrFromDte {
+		if err := watchDte(m, tty.Name(), options.DtrFromDte); err != nil {
+			return nil, fmt.Errorf("error watching DTE: %v", err)
+		}
+	}
+	if options.ControlLines {
+		if err := watchControlLines(m, tty); err != nil {
+			return nil, fmt.Errorf("error watching control lines: %v", err)
+		}
+	}
+	if options.HistoryInterval > 0 {
+		recorder := vm.NewMetricsRecorder(m, time.Duration(options.HistoryInterval)*time.Second, options.HistoryLen)
+		modemsMu.Lock()
+		if r, ok := recorders[m.Id()]; ok {
+			r.Stop()
+		}
+		recorders[m.Id()] = recorder
+		modemsMu.Unlock()
+	}
+	link := fmt.Sprintf("%s/%s", options.TtyPath, id)
+	os.Remove(link)
+	err = os.Symlink(tty.Name(), link)
+	if err != nil {
+		return nil, fmt.Errorf("error creating symlink: %v", err)
+	}
+	if len(options.Verbose) > 0 {
+		fmt.Printf("%s: Created and listen on %s\n", m.Id(), link)
+	}
+	return m, nil
+}
+
 func main() {
 	ctx, cancel = context.WithCancel(context.Background())
 
@@ -509,13 +969,54 @@ func main() {
 		os.Exit(1)
 	}
 
-	err := os.MkdirAll(options.TtyPath, 0755)
+	registerKeepAlive()
+
+	if err := loadTLS(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading TLS settings: %v\n", err)
+		os.Exit(1)
+	}
+
+	if options.AllowExec {
+		vm.RegisterTransport("exec", &vm.ExecTransport{})
+	}
+	if options.Services {
+		vm.RegisterTransport("service", &vm.ServiceTransport{})
+	}
+
+	nets, err := parseDeny(options.Deny)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	denyNets = nets
+	logger = newLogger(options.LogLevel, options.LogJSON)
+	if options.RecordDir != "" {
+		if err := os.MkdirAll(options.RecordDir, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating record directory: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := openCDR(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening CDR file: %v\n", err)
+		os.Exit(1)
+	}
+
+	if options.Config != "" {
+		if err := loadConfig(options.Config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading config file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	err = os.MkdirAll(options.TtyPath, 0755)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating TTY path: %v\n", err)
 		os.Exit(1)
 	}
 
-	cleanTTYs()
+	if options.Takeover == "" { // the symlinks of taken over PTYs stay published
+		cleanTTYs()
+	}
 
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -524,53 +1025,54 @@ func main() {
 		cancel()
 	}()
 
-	phoneTranslations()
-	customCommands()
+	if err := registerResultCodes(options.ResultCode); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 
-	for i := 0; i < options.NumTTYs; i++ {
-		tty, err := NewPty()
+	if err := applySettings(&fileConfig); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	if options.LinkPreset != "" {
+		linkQuality, err = vm.LinkPreset(options.LinkPreset)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating tty: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Invalid link preset: %s\n", options.LinkPreset)
 			os.Exit(1)
 		}
+	}
 
-		id := fmt.Sprintf("tty%d", options.StartNum+i)
-		var rwc io.ReadWriteCloser
-		if len(options.Verbose) > 2 {
-			rwc = t.NewRWCTracer(tty, 16, time.Millisecond*time.Duration(options.NagleTimeout),
-				newModemTraceHook(fmt.Sprintf("%s-w", id)),
-				newModemTraceHook(fmt.Sprintf("%s-r", id)),
-			)
-		} else {
-			rwc = tty
-		}
-
-		m, err := vm.NewModem(&vm.ModemConfig{
-			Id:               id,
-			OutgoingCall:     outGoingCall,
-			CommandHook:      commandHook,
-			StatusTransition: statusTransition,
-			TTY:              rwc,
-			RingMax:          options.RingMax,
-			AnswerChar:       options.AnswerChar,
-			GuardTime:        options.GuardTime,
-			DisablePreGuard:  options.DisablePreGuard,
-			DisablePostGuard: options.DisablePostGuard,
-		})
+	var takenPtys []*UnixPty
+	if options.Takeover != "" {
+		listener, takenPtys, err = takeover(options.Takeover)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating modem: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error taking over: %v\n", err)
 			os.Exit(1)
 		}
-		modems = append(modems, m)
-		err = os.Symlink(tty.Name(), fmt.Sprintf("%s/tty%d", options.TtyPath, options.StartNum+i))
+	}
+
+	for i := 0; i < options.NumTTYs; i++ {
+		var tty *UnixPty
+		if i < len(takenPtys) {
+			tty = takenPtys[i]
+		} else {
+			tty, err = NewPty()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating tty: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		ptys = append(ptys, tty)
+
+		m, err := newModem(i, tty)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating symlink: %v\n", err)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
-		if len(options.Verbose) > 0 {
-			fmt.Printf("%s: Created and listen on %s/tty%d\n", m.Id(), options.TtyPath, options.StartNum+i)
-		}
+		modems = append(modems, m)
 	}
+	pool = vm.NewModemPool(huntStrategies[options.Hunt], modems...)
 
 	for _, attachStr := range options.Attach {
 		err := attachTTY(attachStr)
@@ -580,24 +1082,75 @@ func main() {
 		}
 	}
 
+	if len(options.ClusterPeers) > 0 {
+		if options.Metrics == "" {
+			fmt.Fprintf(os.Stderr, "Clustering requires the metrics server\n")
+			os.Exit(1)
+		}
+		if err := checkClusterExtensions(); err != nil {
+			fmt.Fprintf(os.Stderr, "Cluster error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if options.ApiToken != "" && options.Metrics == "" {
+		fmt.Fprintf(os.Stderr, "The management API requires the metrics server\n")
+		os.Exit(1)
+	}
+
+	if options.TestDial != "" {
+		if err := runTestDial(); err != nil {
+			fmt.Fprintf(os.Stderr, "Test dial %s failed: %v\n", options.TestDial, err)
+			os.Exit(2)
+		}
+		fmt.Printf("Test dial %s ok\n", options.TestDial)
+		if options.TestDialInterval > 0 {
+			go testDialTask(options.TestDialInterval)
+		}
+	}
+
+	if options.WsAddr != "" {
+		go wsListenTask(options.WsAddr)
+	}
+
 	if !options.NoListen {
 		go listenTask()
+		if options.QueueLen > 0 {
+			go queueTask()
+		}
+	}
+
+	if options.Handoff != "" {
+		go serveHandoff(options.Handoff, options.HandoffPtys)
 	}
 
 	if options.Watchdog > 0 {
 		enableWatchdog(options.Watchdog)
 	}
 
+	if options.Supervise > 0 {
+		enableSupervisor(options.Supervise)
+	}
+
+	if options.ApiToken != "" {
+		enableAPI(options.ApiToken)
+	}
+
 	if options.Metrics != "" {
 		enableMetrics(options.Metrics)
 	}
 
+	go reloadTask()
+
 	fmt.Println("Vmodem started, press Ctrl+C to exit")
 	<-ctx.Done()
 	if listener != nil {
 		listener.Close()
 	}
-	cleanTTYs()
+	if !handedOff.Load() {
+		cleanTTYs()
+	}
 	cleanAttached()
 	cleanModems()
+	closeCDR()
 }
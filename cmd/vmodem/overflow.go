@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// forwardCall forwards an inbound connection to another vmodem instance
+func forwardCall(conn net.Conn, addr string) {
+	fallback, err := net.Dial("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error forwarding call to %s: %v\n", addr, err)
+		conn.Close()
+		return
+	}
+	if len(options.Verbose) > 0 {
+		fmt.Printf("No free modems, incoming call forwarded to %s\n", addr)
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(fallback, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, fallback)
+		done <- struct{}{}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	conn.Close()
+	fallback.Close()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// promMetric is a metric family in the Prometheus text exposition format
+type promMetric struct {
+	name  string
+	typ   string
+	help  string
+	value func(metrics *vm.Metrics) float64
+}
+
+var promMetrics = []promMetric{
+	{"vmodem_tty_tx_bytes_total", "counter", "Bytes transmitted to the tty",
+		func(mt *vm.Metrics) float64 { return float64(mt.TtyTxBytes) }},
+	{"vmodem_tty_rx_bytes_total", "counter", "Bytes received from the tty",
+		func(mt *vm.Metrics) float64 { return float64(mt.TtyRxBytes) }},
+	{"vmodem_conn_tx_bytes_total", "counter", "Bytes transmitted to the connections",
+		func(mt *vm.Metrics) float64 { return float64(mt.ConnTxBytes) }},
+	{"vmodem_conn_rx_bytes_total", "counter", "Bytes received from the connections",
+		func(mt *vm.Metrics) float64 { return float64(mt.ConnRxBytes) }},
+	{"vmodem_calls_total", "counter", "Connected calls",
+		func(mt *vm.Metrics) float64 { return float64(mt.NumConns) }},
+	{"vmodem_incoming_calls_total", "counter", "Answered incoming calls",
+		func(mt *vm.Metrics) float64 { return float64(mt.NumInConns) }},
+	{"vmodem_outgoing_calls_total", "counter", "Connected outgoing calls",
+		func(mt *vm.Metrics) float64 { return float64(mt.NumOutConns) }},
+	{"vmodem_failed_dials_total", "counter", "Dials that did not connect",
+		func(mt *vm.Metrics) float64 { return float64(mt.NumFailedDials) }},
+	{"vmodem_command_errors_total", "counter", "Command lines that returned ERROR",
+		func(mt *vm.Metrics) float64 { return float64(mt.CmdErrors) }},
+	{"vmodem_throttled_events_total", "counter", "Times command processing or echo was throttled",
+		func(mt *vm.Metrics) float64 { return float64(mt.ThrottledEvents) }},
+	{"vmodem_active_calls", "gauge", "Calls in progress (online or online command mode)",
+		func(mt *vm.Metrics) float64 {
+			return float64(boolToInt(mt.Status == vm.StatusConnected || mt.Status == vm.StatusConnectedCmd))
+		}},
+}
+
+var promStatuses = []vm.ModemStatus{vm.StatusIdle, vm.StatusDialing, vm.StatusRinging, vm.StatusConnected, vm.StatusConnectedCmd, vm.StatusClosed}
+
+// writePromMetrics writes the metrics of every modem in the Prometheus text exposition format
+func writePromMetrics(w io.Writer) {
+	list := modemList()
+	sort.Slice(list, func(i, j int) bool { return list[i].Id() < list[j].Id() })
+	snapshots := make([]*vm.Metrics, len(list))
+	for i, m := range list {
+		snapshots[i] = m.MetricsSync()
+	}
+
+	fmt.Fprintf(w, "# HELP vmodem_status Current status of the modem (1 for the active status)\n")
+	fmt.Fprintf(w, "# TYPE vmodem_status gauge\n")
+	for i, m := range list {
+		for _, st := range promStatuses {
+			fmt.Fprintf(w, "vmodem_status{modem=%q,status=%q} %d\n", m.Id(), st.String(), boolToInt(snapshots[i].Status == st))
+		}
+	}
+	for _, pm := range promMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", pm.name, pm.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", pm.name, pm.typ)
+		for i, m := range list {
+			fmt.Fprintf(w, "%s{modem=%q} %g\n", pm.name, m.Id(), pm.value(snapshots[i]))
+		}
+	}
+}
+
+// promMetricsHandler serves the metrics in the Prometheus text format
+func promMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	writePromMetrics(w)
+}
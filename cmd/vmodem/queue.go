@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+type queuedCall struct {
+	conn     io.ReadWriteCloser
+	deadline time.Time
+}
+
+// callQueue holds inbound calls while all modems are busy
+type callQueue struct {
+	sync.Mutex
+	calls []*queuedCall
+}
+
+var inQueue = &callQueue{}
+
+// huntStrategies maps the --hunt choices to the pool strategies
+var huntStrategies = map[string]vm.PoolStrategy{
+	"first-free":   vm.PoolFirstFree,
+	"round-robin":  vm.PoolRoundRobin,
+	"least-recent": vm.PoolLeastRecent,
+}
+
+// assignCall delivers an inbound connection to a free modem of the pool. Calls rejected by
+// the incoming call filter (--deny) are closed and count as delivered, they must not reach
+// cluster peers, the queue or the overflow.
+func assignCall(conn io.ReadWriteCloser) bool {
+	callConn := conn
+	cp, isComPort := conn.(*comPort)
+	if isComPort {
+		callConn = cp.TelnetConn // modem must see the Telnet layer to not add its own
+	}
+	m, err := pool.IncomingCall(callConn)
+	if errors.Is(err, vm.ErrCallRejected) {
+		conn.Close()
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	if isComPort {
+		cp.attach(m)
+	}
+	return true
+}
+
+func (q *callQueue) len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.calls)
+}
+
+// push queues a call. Returns false when the queue is full.
+func (q *callQueue) push(conn io.ReadWriteCloser) bool {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.calls) >= options.QueueLen {
+		return false
+	}
+	if options.QueueBanner != "" {
+		conn.Write([]byte(options.QueueBanner + "\r\n"))
+	}
+	q.calls = append(q.calls, &queuedCall{
+		conn:     conn,
+		deadline: time.Now().Add(time.Duration(options.QueueWait) * time.Second),
+	})
+	return true
+}
+
+// dispatch delivers queued calls (FIFO) to modems that became free and drops expired ones
+func (q *callQueue) dispatch() {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.calls) > 0 {
+		c := q.calls[0]
+		if options.QueueWait > 0 && time.Now().After(c.deadline) {
+			c.conn.Close()
+			q.calls = q.calls[1:]
+			fmt.Fprintf(os.Stderr, "Queued call timeout\n")
+			continue
+		}
+		if !assignCall(c.conn) {
+			break
+		}
+		q.calls = q.calls[1:]
+	}
+}
+
+func (q *callQueue) closeAll() {
+	q.Lock()
+	defer q.Unlock()
+	for _, c := range q.calls {
+		c.conn.Close()
+	}
+	q.calls = nil
+}
+
+func queueTask() {
+	for ctx.Err() == nil {
+		if !draining.Load() {
+			inQueue.dispatch()
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	inQueue.closeAll()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// ipv6NumberRe matches IPv6 addresses dialed from a keypad as *6*group*group...[#port],
+// an empty group stands for :: (e.g. *6*2001*DB8**1#23 = [2001:db8::1]:23)
+var ipv6NumberRe = regexp.MustCompile(`^\*6((?:\*[0-9A-Fa-f]{0,4}){2,8})(?:#(\d{1,5}))?$`)
+
+// srvNameRe matches the _service._proto.domain names resolved with a DNS SRV lookup
+var srvNameRe = regexp.MustCompile(`^_[A-Za-z0-9-]+\._(?i:tcp|udp)\.`)
+
+// decodeIPv6Number returns the [addr]:port target of an IPv6 address dialed as *6*... ("" = not one)
+func decodeIPv6Number(number string) string {
+	match := ipv6NumberRe.FindStringSubmatch(number)
+	if match == nil {
+		return ""
+	}
+	addr := net.ParseIP(strings.ReplaceAll(match[1][1:], "*", ":"))
+	if addr == nil {
+		return ""
+	}
+	return net.JoinHostPort(addr.String(), match[2])
+}
+
+// splitSRV returns the SRV name of tcp, tls and telnet targets dialed as _service._proto.domain
+func splitSRV(target string) (scheme string, name string, ok bool) {
+	scheme, addr := vm.SplitTarget(target)
+	if scheme != "tcp" && scheme != "tls" && scheme != "telnet" {
+		return "", "", false
+	}
+	return scheme, addr, srvNameRe.MatchString(addr)
+}
+
+// resolveSRV replaces the _service._proto.domain name of the target by the host:port of its
+// SRV record with the highest priority
+func resolveSRV(ctx context.Context, target string) (string, error) {
+	scheme, name, ok := splitSRV(target)
+	if !ok {
+		return target, nil
+	}
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return "", err
+	}
+	if len(srvs) == 0 {
+		return "", fmt.Errorf("no SRV records for %s", name)
+	}
+	addr := net.JoinHostPort(strings.TrimSuffix(srvs[0].Target, "."), fmt.Sprint(srvs[0].Port))
+	if strings.Contains(target, "://") {
+		return scheme + "://" + addr, nil
+	}
+	return addr, nil
+}
+
+// dialHost connects to the target resolved from a dialed number
+func dialHost(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	target, err := resolveSRV(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return vm.DialTarget(ctx, target)
+}
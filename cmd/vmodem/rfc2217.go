@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// RFC 2217 (Telnet COM port control option)
+const (
+	comPortOption = 44
+
+	cpcSignature         = 0
+	cpcSetBaudRate       = 1
+	cpcSetDataSize       = 2
+	cpcSetParity         = 3
+	cpcSetStopSize       = 4
+	cpcSetControl        = 5
+	cpcNotifyLineState   = 6
+	cpcNotifyModemState  = 7
+	cpcFlowSuspend       = 8
+	cpcFlowResume        = 9
+	cpcSetLineStateMask  = 10
+	cpcSetModemStateMask = 11
+	cpcPurgeData         = 12
+	cpcServerOffset      = 100
+
+	msDCD = 0x80
+	msRI  = 0x40
+	msDSR = 0x20
+	msCTS = 0x10
+)
+
+// comPort is a Telnet connection exposing serial port state to an RFC 2217 client during a call
+type comPort struct {
+	*vm.TelnetConn
+	mu            sync.Mutex
+	baudRate      uint32
+	dataSize      byte
+	parity        byte
+	stopSize      byte
+	dtr           bool
+	rts           bool
+	modemState    byte
+	modemStateMsk byte
+	lineStateMsk  byte
+}
+
+// comPorts maps modems to the RFC 2217 port of their current call
+var comPorts sync.Map
+
+// newComPort wraps an inbound connection with Telnet protocol handling the COM port control option
+func newComPort(conn io.ReadWriteCloser) *comPort {
+	cp := &comPort{
+		TelnetConn:    vm.NewTelnetConn(conn),
+		baudRate:      115200,
+		dataSize:      8,
+		parity:        1,
+		stopSize:      1,
+		dtr:           true,
+		rts:           true,
+		modemState:    msDSR | msCTS,
+		modemStateMsk: 0xff,
+	}
+	cp.HandleOption(comPortOption, cp.handle)
+	return cp
+}
+
+// attach links the port to the modem that took the call and notifies the current modem state
+func (cp *comPort) attach(m *vm.Modem) {
+	cp.mu.Lock()
+	if rate := m.PortRateSync(); rate > 0 {
+		cp.baudRate = uint32(rate)
+	}
+	format, parity := m.FramingSync()
+	cp.dataSize, cp.parity, cp.stopSize = icfToComPort(format, parity)
+	cp.mu.Unlock()
+	comPorts.Store(m, cp)
+	cp.statusChanged(m.StatusSync())
+}
+
+// icfToComPort translates +ICF framing to RFC 2217 data size, parity and stop size
+func icfToComPort(format, parity int) (byte, byte, byte) {
+	dataSize, stopSize := byte(8), byte(1)
+	par := byte(1) // none
+	switch format {
+	case 1:
+		stopSize = 2
+	case 4:
+		dataSize, stopSize = 7, 2
+	case 5, 6:
+		dataSize = 7
+	}
+	if format == 2 || format == 5 {
+		par = byte(parity) + 2 // odd, even, mark, space
+	}
+	return dataSize, par, stopSize
+}
+
+// statusChanged sends NOTIFY-MODEMSTATE when carrier or ring indicator change
+func (cp *comPort) statusChanged(status vm.ModemStatus) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	state := byte(msDSR | msCTS)
+	switch status {
+	case vm.StatusRinging:
+		state |= msRI
+	case vm.StatusConnected, vm.StatusConnectedCmd:
+		state |= msDCD
+	}
+	delta := byte(0)
+	if (state^cp.modemState)&msDCD != 0 {
+		delta |= 0x08
+	}
+	if cp.modemState&msRI != 0 && state&msRI == 0 {
+		delta |= 0x04 // trailing edge ring detector
+	}
+	if state == cp.modemState {
+		return
+	}
+	cp.modemState = state
+	cp.SendSubnegotiation(comPortOption, []byte{cpcNotifyModemState + cpcServerOffset, (state | delta) & cp.modemStateMsk})
+}
+
+// handle answers the COM port control subnegotiations sent by the client
+func (cp *comPort) handle(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cmd, val := data[0], data[1:]
+	reply := []byte{cmd + cpcServerOffset}
+	switch cmd {
+	case cpcSignature:
+		reply = append(reply, []byte("vmodem")...)
+	case cpcSetBaudRate:
+		if len(val) != 4 {
+			return
+		}
+		if rate := binary.BigEndian.Uint32(val); rate != 0 {
+			cp.baudRate = rate
+		}
+		reply = binary.BigEndian.AppendUint32(reply, cp.baudRate)
+	case cpcSetDataSize:
+		if len(val) != 1 {
+			return
+		}
+		if val[0] >= 5 && val[0] <= 8 {
+			cp.dataSize = val[0]
+		}
+		reply = append(reply, cp.dataSize)
+	case cpcSetParity:
+		if len(val) != 1 {
+			return
+		}
+		if val[0] >= 1 && val[0] <= 5 {
+			cp.parity = val[0]
+		}
+		reply = append(reply, cp.parity)
+	case cpcSetStopSize:
+		if len(val) != 1 {
+			return
+		}
+		if val[0] >= 1 && val[0] <= 3 {
+			cp.stopSize = val[0]
+		}
+		reply = append(reply, cp.stopSize)
+	case cpcSetControl:
+		if len(val) != 1 {
+			return
+		}
+		reply = append(reply, cp.control(val[0]))
+	case cpcSetModemStateMask:
+		if len(val) != 1 {
+			return
+		}
+		cp.modemStateMsk = val[0]
+		reply = append(reply, val[0])
+	case cpcSetLineStateMask:
+		if len(val) != 1 {
+			return
+		}
+		cp.lineStateMsk = val[0]
+		reply = append(reply, val[0])
+	case cpcPurgeData:
+		if len(val) != 1 {
+			return
+		}
+		reply = append(reply, val[0])
+	case cpcFlowSuspend, cpcFlowResume: // data flow is handled by TCP
+		return
+	default:
+		return
+	}
+	cp.SendSubnegotiation(comPortOption, reply)
+}
+
+// control handles SET-CONTROL requests and returns the value to be reported
+func (cp *comPort) control(val byte) byte {
+	switch val {
+	case 0, 1: // flow control request / no flow control
+		return 1
+	case 4, 5, 6: // break state request / on / off
+		return 6
+	case 7: // DTR state request
+	case 8:
+		cp.dtr = true
+	case 9:
+		cp.dtr = false
+	case 10: // RTS state request
+		return ternaryByte(cp.rts, 11, 12)
+	case 11:
+		cp.rts = true
+		return 11
+	case 12:
+		cp.rts = false
+		return 12
+	default:
+		return val
+	}
+	return ternaryByte(cp.dtr, 8, 9)
+}
+
+func ternaryByte(cond bool, val1, val2 byte) byte {
+	if cond {
+		return val1
+	}
+	return val2
+}
+
+// comPortStatus forwards modem status transitions to the RFC 2217 client of the current call
+func comPortStatus(m *vm.Modem, newStatus vm.ModemStatus) {
+	v, ok := comPorts.Load(m)
+	if !ok {
+		return
+	}
+	cp := v.(*comPort)
+	cp.statusChanged(newStatus)
+	if newStatus == vm.StatusIdle || newStatus == vm.StatusClosed {
+		comPorts.CompareAndDelete(m, cp)
+	}
+}
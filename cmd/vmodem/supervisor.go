@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// statusSince records when each modem entered its current status
+var statusSince sync.Map
+
+func markStatus(m *vm.Modem) {
+	statusSince.Store(m, time.Now())
+}
+
+func timeInStatus(m *vm.Modem) time.Duration {
+	if v, ok := statusSince.Load(m); ok {
+		return time.Since(v.(time.Time))
+	}
+	return 0
+}
+
+// probeModem returns the modem metrics or false if the modem lock can't be acquired in time
+func probeModem(m *vm.Modem) (*vm.Metrics, bool) {
+	res := make(chan *vm.Metrics, 1)
+	go func() {
+		res <- m.MetricsSync()
+	}()
+	select {
+	case metrics := <-res:
+		return metrics, true
+	case <-time.After(5 * time.Second):
+		return nil, false
+	}
+}
+
+// wedgedReason returns why the modem is considered stuck, or "" if it is healthy
+func wedgedReason(m *vm.Modem, timeout time.Duration) string {
+	metrics, ok := probeModem(m)
+	if !ok {
+		return "modem lock held for too long"
+	}
+	switch metrics.Status {
+	case vm.StatusClosed:
+		return "modem closed (PTY gone)"
+	case vm.StatusDialing:
+		if timeInStatus(m) > timeout {
+			return "dialing for too long"
+		}
+	case vm.StatusConnected, vm.StatusConnectedCmd:
+		last := metrics.LastConnTime
+		for _, t := range []time.Time{metrics.LastTtyRxTime, metrics.LastTtyTxTime} {
+			if t.After(last) {
+				last = t
+			}
+		}
+		if time.Since(last) <= timeout {
+			break
+		}
+		// idle calls are legitimate, only those with a dead peer or data pump are stuck
+		hctx, hcancel := context.WithTimeout(context.Background(), 5*time.Second)
+		report := m.Healthy(hctx)
+		hcancel()
+		if !report.Healthy {
+			return "connected without traffic, unhealthy"
+		}
+		pctx, pcancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := m.PingSync(pctx)
+		pcancel()
+		if err != nil && !errors.Is(err, vm.ErrPingNotSupported) && !errors.Is(err, vm.ErrNoCarrier) {
+			return "connected without traffic, peer not answering ping"
+		}
+	}
+	return ""
+}
+
+// modemsMu guards modems, ptys and recorders, replaced by the supervisor while HTTP
+// handlers and other tasks range over them
+var modemsMu sync.RWMutex
+
+// modemList returns a copy of the modems
+func modemList() []*vm.Modem {
+	modemsMu.RLock()
+	defer modemsMu.RUnlock()
+	return append([]*vm.Modem(nil), modems...)
+}
+
+// ptyList returns a copy of the PTYs of the modems
+func ptyList() []*UnixPty {
+	modemsMu.RLock()
+	defer modemsMu.RUnlock()
+	return append([]*UnixPty(nil), ptys...)
+}
+
+// modemRecorder returns the metrics history recorder of the modem id
+func modemRecorder(id string) (*vm.MetricsRecorder, bool) {
+	modemsMu.RLock()
+	defer modemsMu.RUnlock()
+	r, ok := recorders[id]
+	return r, ok
+}
+
+// recreateModem replaces the modem number i and its PTY with fresh ones
+func recreateModem(i int) error {
+	modemsMu.RLock()
+	old, oldPty := modems[i], ptys[i]
+	modemsMu.RUnlock()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	old.Shutdown(shutdownCtx) // a wedged modem is abandoned on timeout
+	cancel()
+	oldPty.Close()
+	statusSince.Delete(old)
+
+	tty, err := NewPty()
+	if err != nil {
+		return err
+	}
+	m, err := newModem(i, tty)
+	if err != nil {
+		tty.Close()
+		return err
+	}
+	modemsMu.Lock()
+	ptys[i] = tty
+	modems[i] = m
+	modemsMu.Unlock()
+	pool.Set(i, m)
+	return nil
+}
+
+func enableSupervisor(timeout int) {
+	go func() {
+		for ctx.Err() == nil {
+			for i, m := range modemList() {
+				reason := wedgedReason(m, time.Duration(timeout)*time.Second)
+				if reason == "" || ctx.Err() != nil {
+					continue
+				}
+				fmt.Fprintf(os.Stderr, "%s: Supervisor recreating wedged modem: %s\n", m.Id(), reason)
+				if err := recreateModem(i); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: Supervisor error recreating modem: %v\n", m.Id(), err)
+				}
+			}
+			time.Sleep(time.Second)
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// TestDialResult is the outcome of the last readiness test dial
+type TestDialResult struct {
+	// Ok is true when the test call connected
+	Ok bool `json:"ok"`
+	// Host is the resolved host:port
+	Host string `json:"host"`
+	// Error is the failure reason
+	Error string `json:"error,omitempty"`
+	// Time is when the test dial was placed
+	Time time.Time `json:"time"`
+}
+
+var (
+	testDialMu     sync.Mutex
+	testDialResult TestDialResult
+)
+
+func lastTestDial() TestDialResult {
+	testDialMu.Lock()
+	defer testDialMu.Unlock()
+	return testDialResult
+}
+
+// runTestDial places a short call to the test number through the dial plan and translations
+func runTestDial() error {
+	res := TestDialResult{Time: time.Now()}
+	_, host := resolveNumber(phonebook, options.TestDial)
+	res.Host = host
+	var err error
+	if host == "" {
+		err = fmt.Errorf("no host found")
+	} else {
+		dctx, dcancel := context.WithTimeout(ctx, 10*time.Second)
+		var conn io.ReadWriteCloser
+		conn, err = dialHost(dctx, host)
+		dcancel()
+		if err == nil {
+			conn.Close()
+		}
+	}
+	res.Ok = err == nil
+	if err != nil {
+		res.Error = err.Error()
+	}
+	testDialMu.Lock()
+	testDialResult = res
+	testDialMu.Unlock()
+	return err
+}
+
+func testDialTask(interval int) {
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(interval) * time.Second):
+		}
+		prevOk := lastTestDial().Ok
+		err := runTestDial()
+		if err != nil && prevOk {
+			fmt.Fprintf(os.Stderr, "Test dial %s failed: %v\n", options.TestDial, err)
+		}
+		if err == nil && !prevOk {
+			fmt.Printf("Test dial %s recovered\n", options.TestDial)
+		}
+	}
+}
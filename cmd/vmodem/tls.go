@@ -0,0 +1,41 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+var (
+	tlsServerConfig *tls.Config
+	tlsClientConfig = &tls.Config{}
+)
+
+// loadTLS prepares the TLS configuration of the listener and of the tls and wss transports
+func loadTLS() error {
+	if options.TLSCert != "" || options.TLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(options.TLSCert, options.TLSKey)
+		if err != nil {
+			return err
+		}
+		tlsServerConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	if options.TLSCA != "" {
+		pem, err := os.ReadFile(options.TLSCA)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", options.TLSCA)
+		}
+		tlsClientConfig.RootCAs = pool
+	}
+	tlsClientConfig.InsecureSkipVerify = options.TLSInsecure
+	vm.RegisterTransport("tls", &vm.TLSTransport{Config: tlsClientConfig, KeepAlive: keepAliveConfig()})
+	vm.RegisterTransport("wss", &vm.WebSocketTransport{TLSConfig: tlsClientConfig, KeepAlive: keepAliveConfig()})
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+
+	vm "github.com/jaracil/vmodem"
+)
+
+// wsHandler upgrades HTTP requests to WebSocket and delivers them as incoming calls
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := vm.AcceptWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	incomingConn(conn)
+}
+
+// wsListenTask accepts incoming calls over WebSocket (wss when the TLS certificate is set)
+func wsListenTask(addr string) {
+	ln, err := listenTCP(addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating websocket listener: %v\n", err)
+		cancel()
+		return
+	}
+	if tlsServerConfig != nil {
+		ln = tls.NewListener(ln, tlsServerConfig)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	if err := http.Serve(ln, http.HandlerFunc(wsHandler)); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Error serving websocket: %v\n", err)
+		cancel()
+	}
+}
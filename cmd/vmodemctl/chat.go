@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	vm "github.com/jaracil/vmodem"
+	"go.bug.st/serial"
+)
+
+type ChatCommand struct {
+	Tty     string `short:"t" long:"tty" description:"TTY of the modem (default stdin/stdout, as chat(8))"`
+	File    string `short:"f" long:"file" description:"Read the chat script from this file"`
+	Timeout int    `short:"T" long:"timeout" description:"Seconds each expect waits unless the script sets TIMEOUT" default:"45"`
+	Verbose bool   `short:"v" long:"verbose" description:"Copy the text sent and received to stderr"`
+	Args    struct {
+		Script []string `positional-arg-name:"script"`
+	} `positional-args:"yes"`
+}
+
+// stdio is the TTY of chat scripts run without --tty
+type stdio struct{}
+
+func (stdio) Read(b []byte) (int, error)  { return os.Stdin.Read(b) }
+func (stdio) Write(b []byte) (int, error) { return os.Stdout.Write(b) }
+
+// Execute runs a chat script on a modem TTY, failing on the first unmet expect.
+func (c *ChatCommand) Execute(args []string) error {
+	words := make([]string, len(c.Args.Script))
+	for i, w := range c.Args.Script { // each argument is a word, as chat(8)
+		words[i] = "'" + strings.ReplaceAll(w, "'", `\'`) + "'"
+	}
+	src := strings.Join(words, " ")
+	if c.File != "" {
+		b, err := os.ReadFile(c.File)
+		if err != nil {
+			return err
+		}
+		src = string(b) + "\n" + src
+	}
+	script, err := vm.ParseChatScript(src)
+	if err != nil {
+		return err
+	}
+	if len(script.Steps) == 0 {
+		return errors.New("empty chat script")
+	}
+	script.Timeout = time.Duration(c.Timeout) * time.Second
+	var tty io.ReadWriter = stdio{}
+	if c.Tty != "" {
+		port, err := serial.Open(c.Tty, &serial.Mode{BaudRate: 115200})
+		if err != nil {
+			return fmt.Errorf("error opening %s: %v", c.Tty, err)
+		}
+		defer port.Close()
+		tty = port
+	}
+	chat := vm.NewChat(tty)
+	if c.Verbose {
+		chat.Trace = os.Stderr
+	}
+	return chat.Run(context.Background(), script)
+}
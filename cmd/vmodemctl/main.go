@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+)
+
+type Options struct {
+	Addr string `short:"a" long:"addr" description:"vmodem metrics/control server address" default:"127.0.0.1:8080"`
+}
+
+type WatchCommand struct {
+	Token string `short:"t" long:"token" description:"Bearer token of the vmodem API (vmodem --api-token)"`
+	Args  struct {
+		Id string `positional-arg-name:"modem-id" required:"yes"`
+	} `positional-args:"yes"`
+}
+
+var options Options
+
+// Execute streams the live traffic of a modem to stdout.
+func (c *WatchCommand) Execute(args []string) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("http://%s/watch/%s", options.Addr, c.Args.Id), nil)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+func main() {
+	gfParser := flags.NewParser(&options, flags.Default)
+	gfParser.AddCommand("watch", "Watch modem traffic", "Streams a live read-only view of an active call's traffic", &WatchCommand{})
+	gfParser.AddCommand("chat", "Run a chat script", "Drives a modem TTY with an expect/send script in chat(8) syntax, failing on the first unmet expect", &ChatCommand{})
+	if _, err := gfParser.Parse(); err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+}
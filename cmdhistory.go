@@ -0,0 +1,36 @@
+package vmodem
+
+import "time"
+
+// CommandRecord is an AT command line processed by the modem and its result
+type CommandRecord struct {
+	// Time is when the command line was processed
+	Time time.Time
+	// Cmd is the command line without the AT prefix
+	Cmd string
+	// Result is the result code returned
+	Result RetCode
+}
+
+func (m *Modem) recordCommand(cmd string, ret RetCode) {
+	if m.cmdHistoryLen < 0 {
+		return
+	}
+	m.cmdHistory = append(m.cmdHistory, CommandRecord{Time: m.clock.Now(), Cmd: cmd, Result: ret})
+	if len(m.cmdHistory) > m.cmdHistoryLen {
+		m.cmdHistory = m.cmdHistory[len(m.cmdHistory)-m.cmdHistoryLen:]
+	}
+}
+
+// CommandHistory returns the most recent command lines, oldest first. Modem lock must be held.
+func (m *Modem) CommandHistory() []CommandRecord {
+	m.checkLock()
+	return append([]CommandRecord(nil), m.cmdHistory...)
+}
+
+// CommandHistorySync returns the most recent command lines, oldest first. Modem lock is acquired and released.
+func (m *Modem) CommandHistorySync() []CommandRecord {
+	m.Lock()
+	defer m.Unlock()
+	return m.CommandHistory()
+}
@@ -0,0 +1,130 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// defaultCoalesceDelay is the max time data waits in the write coalescer
+const defaultCoalesceDelay = 50 * time.Millisecond
+
+// coalescingConn batches small writes in bulk mode (see AT#LAT) until size bytes are
+// pending or delay expires. In interactive mode writes go straight to the connection.
+type coalescingConn struct {
+	conn  io.ReadWriteCloser
+	clock Clock
+	mu    sync.Mutex
+	buf   []byte
+	size  int
+	delay time.Duration
+	bulk  bool
+	timer chan struct{} // Closed to cancel the pending delayed flush (nil = none)
+	err   error
+}
+
+func newCoalescingConn(conn io.ReadWriteCloser, size int, delay time.Duration, clock Clock) *coalescingConn {
+	return &coalescingConn{
+		conn:  conn,
+		clock: clock,
+		buf:   make([]byte, 0, size),
+		size:  size,
+		delay: delay,
+	}
+}
+
+func (c *coalescingConn) flush() error {
+	if c.timer != nil {
+		close(c.timer)
+		c.timer = nil
+	}
+	if len(c.buf) == 0 || c.err != nil {
+		return c.err
+	}
+	_, c.err = c.conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	return c.err
+}
+
+// Read implements io.Reader
+func (c *coalescingConn) Read(b []byte) (int, error) {
+	return c.conn.Read(b)
+}
+
+// Write implements io.Writer
+func (c *coalescingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.bulk || len(c.buf)+len(b) > c.size {
+		if err := c.flush(); err != nil {
+			return 0, err
+		}
+		if !c.bulk || len(b) >= c.size {
+			return c.conn.Write(b)
+		}
+	}
+	c.buf = append(c.buf, b...)
+	if c.timer == nil {
+		c.timer = make(chan struct{})
+		go c.delayedFlush(c.timer)
+	}
+	return len(b), nil
+}
+
+// delayedFlush flushes the pending data after the coalesce delay unless canceled first
+func (c *coalescingConn) delayedFlush(cancel chan struct{}) {
+	select {
+	case <-c.clock.After(c.delay):
+	case <-cancel:
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == cancel {
+		c.flush()
+	}
+}
+
+// Close implements io.Closer flushing pending data
+func (c *coalescingConn) Close() error {
+	c.mu.Lock()
+	c.flush()
+	c.mu.Unlock()
+	return c.conn.Close()
+}
+
+// SendBreak implements BreakSender flushing pending data before the break
+func (c *coalescingConn) SendBreak() error {
+	c.mu.Lock()
+	err := c.flush()
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if bs, ok := c.conn.(BreakSender); ok {
+		return bs.SendBreak()
+	}
+	return ErrBreakNotSupported
+}
+
+// SetBulkMode implements LatencyModeSetter. Pending data is flushed when leaving bulk mode.
+func (c *coalescingConn) SetBulkMode(bulk bool) {
+	c.mu.Lock()
+	c.bulk = bulk
+	if !bulk {
+		c.flush()
+	}
+	c.mu.Unlock()
+	if lms, ok := c.conn.(LatencyModeSetter); ok {
+		lms.SetBulkMode(bulk)
+	}
+}
+
+// Ping implements Pinger forwarding to the wrapped connection
+func (c *coalescingConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return ErrPingNotSupported
+}
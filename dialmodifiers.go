@@ -0,0 +1,64 @@
+package vmodem
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// hookFlashTime is the on-hook time of the ! dial modifier
+const hookFlashTime = 500 * time.Millisecond
+
+// phoneDialRe matches dial strings made of telephone digits and modifiers. W, @ and !
+// are only modifiers in these, so hostnames like www.example.com reach the dialer intact.
+var phoneDialRe = regexp.MustCompile(`^[0-9*#ABCDabcd,Ww@! ()-]+$`)
+
+// dialModifiers removes the dial modifiers from number and returns the time they wait:
+// , pauses S8, W waits S6 for the second dial tone, @ waits S8 for the quiet answer and
+// ! flashes the hook. Commas are pauses in any dial string.
+func dialModifiers(number string, timers dialTimers) (string, time.Duration) {
+	wait := time.Duration(strings.Count(number, ",")) * timers.commaPause
+	number = strings.ReplaceAll(number, ",", "")
+	if !phoneDialRe.MatchString(number) {
+		return number, wait
+	}
+	var b strings.Builder
+	for _, c := range number {
+		switch c {
+		case 'W', 'w':
+			wait += timers.waitTone
+		case '@':
+			wait += timers.commaPause
+		case '!':
+			wait += hookFlashTime
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String(), wait
+}
+
+// splitDialCommand removes the trailing ; of a dial string, that returns to command
+// mode after dialing so the number can be completed with further ATD commands
+func splitDialCommand(number string) (string, bool) {
+	if rest, ok := strings.CutSuffix(number, ";"); ok {
+		return strings.TrimSpace(rest), true
+	}
+	return number, false
+}
+
+// prepareDial resolves the ATD dial string and prefixes it with the number pending from a
+// previous ATD ending with ;. Returns pending when the dial string ends with ; (the number
+// is kept for the next ATD) and false when ATDL or ATDS refer to a missing number.
+func (m *Modem) prepareDial(s string) (number string, pending bool, ok bool) {
+	number, pending = splitDialCommand(m.dialString(s))
+	if number, ok = m.resolveDialString(number); !ok {
+		return "", false, false
+	}
+	number = m.pendingDial + number
+	m.pendingDial = ""
+	if pending {
+		m.pendingDial = number
+	}
+	return number, pending, true
+}
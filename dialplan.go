@@ -0,0 +1,182 @@
+package vmodem
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DialEntry translates dialed numbers matching Pattern into a host. Format is a fmt
+// template receiving the regexp submatches as strings (%[1]s, %[2]s...).
+type DialEntry struct {
+	Pattern string
+	Format  string
+	Window  *TimeWindow // Entry only applies inside this daily window (nil = always)
+	re      *regexp.Regexp
+}
+
+// Match returns the host for num or "" if the entry does not apply.
+func (e *DialEntry) Match(num string) string {
+	return e.MatchAt(num, time.Now())
+}
+
+// MatchAt is like Match evaluating the time window of the entry at now.
+func (e *DialEntry) MatchAt(num string, now time.Time) string {
+	if !e.Window.Contains(now) {
+		return ""
+	}
+	m := e.re.FindStringSubmatch(num)
+	if len(m) == 0 {
+		return ""
+	}
+	var as []interface{}
+	for _, v := range m[1:] {
+		as = append(as, v)
+	}
+	return fmt.Sprintf(e.Format, as...)
+}
+
+// DialPlan is a phonebook translating dialed numbers to host:port. Entries are
+// tried in order and the first match wins, fallback entries are tried after all of
+// them. It is safe for concurrent use, so entries can be added and removed while
+// modems are dialing.
+type DialPlan struct {
+	mu          sync.RWMutex
+	defaultPort string
+	entries     []*DialEntry
+	fallback    []*DialEntry // Last resort entries (host names of DefaultDialPlan)
+}
+
+// NewDialPlan returns an empty dial plan. defaultPort is appended to hosts without port.
+func NewDialPlan(defaultPort string) *DialPlan {
+	return &DialPlan{defaultPort: defaultPort}
+}
+
+// DefaultDialPlan returns a dial plan that understands IP addresses dialed
+// as *a*b*c*d[*port] or a.b.c.d[:port], IPv6 addresses dialed as [addr][:port]
+// or addr and host names dialed as host.domain[:port] (e.g. ATDT bbs.example.com:23).
+// Host names are a fallback, entries added later take precedence (e.g. bbs\.home).
+func DefaultDialPlan(defaultPort string) *DialPlan {
+	d := NewDialPlan(defaultPort)
+	d.Add("\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s", nil)
+	d.Add("\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})\\*(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s", nil)
+	d.Add("(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3}):(\\d{1,5})?", "%[1]s.%[2]s.%[3]s.%[4]s:%[5]s", nil)
+	d.Add("(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})\\.(\\d{1,3})", "%[1]s.%[2]s.%[3]s.%[4]s", nil)
+	d.Add(`^\[([0-9A-Fa-f:.]+)\](?::(\d{1,5}))?$`, "[%[1]s]:%[2]s", nil)
+	d.Add(`^([0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7})$`, "[%[1]s]", nil)
+	d.AddFallback(`^((?:[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?\.)+[A-Za-z](?:[A-Za-z0-9-]*[A-Za-z0-9])?)(?::(\d{1,5}))?$`, "%[1]s:%[2]s", nil)
+	return d
+}
+
+func newDialEntry(pattern, format string, window *TimeWindow) (*DialEntry, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &DialEntry{Pattern: pattern, Format: format, Window: window, re: re}, nil
+}
+
+// Add appends an entry to the dial plan.
+func (d *DialPlan) Add(pattern, format string, window *TimeWindow) error {
+	e, err := newDialEntry(pattern, format, window)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = append(d.entries, e)
+	return nil
+}
+
+// AddFallback appends an entry tried only when no other entry matches.
+func (d *DialPlan) AddFallback(pattern, format string, window *TimeWindow) error {
+	e, err := newDialEntry(pattern, format, window)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fallback = append(d.fallback, e)
+	return nil
+}
+
+// Remove deletes the entries with the given pattern. Returns false if none was found.
+func (d *DialPlan) Remove(pattern string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	found := false
+	keep := func(list []*DialEntry) []*DialEntry {
+		entries := list[:0]
+		for _, e := range list {
+			if e.Pattern == pattern {
+				found = true
+				continue
+			}
+			entries = append(entries, e)
+		}
+		return entries
+	}
+	d.entries = keep(d.entries)
+	d.fallback = keep(d.fallback)
+	return found
+}
+
+// Replace atomically swaps the entries of the dial plan with the entries of src,
+// so a reloaded phonebook can be installed without disturbing modems dialing.
+func (d *DialPlan) Replace(src *DialPlan) {
+	src.mu.RLock()
+	entries := append([]*DialEntry(nil), src.entries...)
+	fallback := append([]*DialEntry(nil), src.fallback...)
+	src.mu.RUnlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = entries
+	d.fallback = fallback
+}
+
+// List returns a copy of the dial plan entries in lookup order, fallback entries last.
+func (d *DialPlan) List() []DialEntry {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	list := make([]DialEntry, 0, len(d.entries)+len(d.fallback))
+	for _, e := range slices.Concat(d.entries, d.fallback) {
+		list = append(list, *e)
+	}
+	return list
+}
+
+// Lookup returns the host:port for the dialed number or "" if no entry matches.
+func (d *DialPlan) Lookup(num string) string {
+	return d.LookupAt(num, time.Now())
+}
+
+// LookupAt is like Lookup evaluating the time windows of the entries at now.
+func (d *DialPlan) LookupAt(num string, now time.Time) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, e := range slices.Concat(d.entries, d.fallback) {
+		if host := e.MatchAt(num, now); host != "" {
+			if d.defaultPort != "" && !strings.Contains(host, "://") {
+				host = withDefaultPort(host, d.defaultPort)
+			}
+			return host
+		}
+	}
+	return ""
+}
+
+// withDefaultPort adds port to addresses without port (host, host:, [v6addr] or [v6addr]:)
+func withDefaultPort(addr, port string) string {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	if p == "" {
+		return net.JoinHostPort(host, port)
+	}
+	return addr
+}
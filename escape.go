@@ -0,0 +1,66 @@
+package vmodem
+
+import "strconv"
+
+// tiesDetector recognizes the Time Independent Escape Sequence: three escape characters
+// followed by an AT command line, with no guard times around them
+type tiesDetector struct {
+	plusCnt int    // Escape characters seen (up to 3)
+	state   int    // 0 = counting escape characters, 1 = 'A' expected, 2 = 'T' expected, 3 = command line
+	line    []byte // Command line after AT
+}
+
+func (t *tiesDetector) reset() {
+	t.plusCnt = 0
+	t.state = 0
+	t.line = t.line[:0]
+}
+
+// feed processes a byte sent to the connection. Returns the command line without the AT
+// prefix and true when the byte completes the escape sequence.
+func (t *tiesDetector) feed(c, escChar, cr byte, limit int) (string, bool) {
+	switch t.state {
+	case 0:
+		if c != escChar {
+			t.plusCnt = 0
+			return "", false
+		}
+		t.plusCnt++
+		if t.plusCnt == 3 {
+			t.state = 1
+		}
+	case 1:
+		if c == escChar { // "++++AT" still escapes with the last three characters
+			return "", false
+		}
+		if c != 'A' && c != 'a' {
+			t.reset()
+			return "", false
+		}
+		t.state = 2
+	case 2:
+		if c != 'T' && c != 't' {
+			t.reset()
+			if c == escChar {
+				t.plusCnt = 1
+			}
+			return "", false
+		}
+		t.state = 3
+	case 3:
+		if c == cr {
+			cmd := string(t.line)
+			t.reset()
+			return cmd, true
+		}
+		if len(t.line) >= limit || !strconv.IsPrint(rune(c)) {
+			t.reset()
+			if c == escChar {
+				t.plusCnt = 1
+			}
+			return "", false
+		}
+		t.line = append(t.line, c)
+	}
+	return "", false
+}
@@ -0,0 +1,163 @@
+package vmodem
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of a ModemEvent
+type EventType int
+
+const (
+	EventStatus     EventType = iota // Status transition (PrevStatus -> Status)
+	EventRing                        // RING sent to the DTE (Rings holds the ring count)
+	EventDial                        // Dial started (Number holds the dialed number)
+	EventConnect                     // Call connected (online mode entered from dialing or ringing)
+	EventDisconnect                  // Call ended
+	EventCommand                     // AT command line processed (Command and Result are set)
+	EventRingback                    // Dialed remote side ringing (Rings holds the remote ring count)
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventStatus:
+		return "Status"
+	case EventRing:
+		return "Ring"
+	case EventDial:
+		return "Dial"
+	case EventConnect:
+		return "Connect"
+	case EventDisconnect:
+		return "Disconnect"
+	case EventCommand:
+		return "Command"
+	case EventRingback:
+		return "Ringback"
+	default:
+		return "Unknown"
+	}
+}
+
+// ModemEvent is an activity notification delivered to subscribers
+type ModemEvent struct {
+	Type       EventType
+	Time       time.Time
+	Status     ModemStatus // Status of the modem when the event was emitted
+	PrevStatus ModemStatus // Previous status (EventStatus)
+	Number     string      // Dialed number (EventDial)
+	Rings      int         // Ring count (EventRing, EventRingback)
+	Command    string      // Command line without the AT prefix (EventCommand)
+	Result     RetCode     // Result code of the command line (EventCommand)
+}
+
+// eventBufferLen is the number of pending events a subscriber holds before dropping events
+const eventBufferLen = 64
+
+type subscriber struct {
+	ch        chan ModemEvent
+	closeOnce sync.Once
+}
+
+func (s *subscriber) shutdown() {
+	s.closeOnce.Do(func() {
+		close(s.ch)
+	})
+}
+
+func (m *Modem) subscribe() (<-chan ModemEvent, func()) {
+	s := &subscriber{ch: make(chan ModemEvent, eventBufferLen)}
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	if m.st == StatusClosed {
+		s.shutdown()
+		return s.ch, func() {}
+	}
+	m.subs = append(m.subs, s)
+	return s.ch, func() { m.unsubscribe(s) }
+}
+
+// Subscribe returns a channel delivering modem events and a function to cancel the subscription.
+// The channel is closed when the subscription is canceled or the modem is closed.
+// Events are dropped when the subscriber falls behind, so the modem never blocks.
+// Modem lock must be held.
+func (m *Modem) Subscribe() (<-chan ModemEvent, func()) {
+	m.checkLock()
+	return m.subscribe()
+}
+
+// SubscribeSync returns a channel delivering modem events and a function to cancel the subscription.
+// Modem lock is acquired and released.
+func (m *Modem) SubscribeSync() (<-chan ModemEvent, func()) {
+	m.Lock()
+	defer m.Unlock()
+	return m.subscribe()
+}
+
+// WaitForStatus blocks until the modem reaches status, returning at once when it is already
+// there. Returns ctx.Err() when ctx expires first and ErrModemClosed when the modem is closed
+// while waiting for another status. Modem lock must not be held, it is acquired and released.
+func (m *Modem) WaitForStatus(ctx context.Context, status ModemStatus) error {
+	m.Lock()
+	if m.status() == status {
+		m.Unlock()
+		return nil
+	}
+	events, cancel := m.subscribe()
+	m.Unlock()
+	defer cancel()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				if status == StatusClosed {
+					return nil
+				}
+				return ErrModemClosed
+			}
+			if ev.Status == status {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (m *Modem) unsubscribe(s *subscriber) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for i, v := range m.subs {
+		if v == s {
+			m.subs = append(m.subs[:i], m.subs[i+1:]...)
+			break
+		}
+	}
+	s.shutdown()
+}
+
+func (m *Modem) closeSubscribers() {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	for _, s := range m.subs {
+		s.shutdown()
+	}
+	m.subs = nil
+}
+
+func (m *Modem) emit(ev ModemEvent) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+	if len(m.subs) == 0 {
+		return
+	}
+	ev.Time = m.clock.Now()
+	ev.Status = m.st
+	for _, s := range m.subs {
+		select {
+		case s.ch <- ev:
+		default: // slow subscriber, drop event
+		}
+	}
+}
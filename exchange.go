@@ -0,0 +1,124 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Exchange is an in-process virtual telephone exchange. Modems registered with a number
+// ring each other over in-memory pipes when they dial it, no network connection needed.
+type Exchange struct {
+	mu    sync.RWMutex
+	lines map[string]*Modem
+}
+
+// NewExchange creates an exchange without lines
+func NewExchange() *Exchange {
+	return &Exchange{lines: map[string]*Modem{}}
+}
+
+// Register assigns a number to a modem (replacing the previous owner). A nil modem removes the number.
+// The modem must use Call as its OutgoingCallCtx hook to dial other lines.
+func (x *Exchange) Register(number string, m *Modem) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if m == nil {
+		delete(x.lines, number)
+		return
+	}
+	x.lines[number] = m
+}
+
+// Unregister removes a number from the exchange
+func (x *Exchange) Unregister(number string) {
+	x.Register(number, nil)
+}
+
+// Lookup returns the modem registered with a number (nil = unassigned)
+func (x *Exchange) Lookup(number string) *Modem {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.lines[number]
+}
+
+// numberOf returns the number assigned to a modem ("" = none)
+func (x *Exchange) numberOf(m *Modem) string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	for number, v := range x.lines {
+		if v == m {
+			return number
+		}
+	}
+	return ""
+}
+
+// NewLine creates a modem registered with number that dials through the exchange. The config
+// is optional, its TTY and OutgoingCall fields are ignored. Returns the modem and the DTE side of its TTY.
+func (x *Exchange) NewLine(number string, config *ModemConfig) (*Modem, io.ReadWriteCloser, error) {
+	cfg := ModemConfig{Id: number}
+	if config != nil {
+		cfg = *config
+	}
+	dte, dce := newPipe()
+	cfg.TTY = dce
+	cfg.OutgoingCall = nil
+	cfg.OutgoingCallCtx = x.Call
+	m, err := NewModem(&cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	x.Register(number, m)
+	return m, dte, nil
+}
+
+// Call is an OutgoingCallCtx hook ringing the modem registered with the dialed number.
+// The caller number is delivered as caller ID and every remote ring is reported to the
+// caller with Ringback. It returns once the call is answered, ErrBusy when the
+// remote modem is not idle or rejects the call, ErrNoAnswer when it stops ringing
+// and ErrNoCarrier for unassigned numbers.
+func (x *Exchange) Call(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error) {
+	callee := x.Lookup(number)
+	if callee == nil {
+		return nil, ErrNoCarrier
+	}
+	if callee == m {
+		return nil, ErrBusy
+	}
+	events, unsubscribe := callee.SubscribeSync()
+	defer unsubscribe()
+	local, remote := newPipe()
+	if err := callee.IncomingCallWithInfoSync(remote, CallInfo{Number: x.numberOf(m)}); err != nil {
+		if err == ErrModemBusy || err == ErrNoDTE || err == ErrCallRejected {
+			return nil, ErrBusy
+		}
+		return nil, err
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				local.Close()
+				return nil, ErrNoCarrier
+			}
+			switch {
+			case ev.Type == EventRing:
+				m.RingbackSync(ev.Rings)
+			case ev.Type == EventStatus && ev.Status == StatusConnected:
+				return local, nil
+			case ev.Type == EventStatus && ev.Status != StatusRinging:
+				local.Close()
+				return nil, ErrNoAnswer
+			}
+		case <-ctx.Done():
+			callee.Lock()
+			if callee.status() == StatusRinging && callee.conn == remote {
+				callee.setStatus(StatusIdle)
+			}
+			callee.Unlock()
+			local.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// ExecTransport runs a local program for exec://program args... targets and bridges its
+// stdin and stdout/stderr as the call. The program is killed when the call hangs up and
+// the call ends when the program exits. It is not registered by default, since a dial
+// plan rewriting dialed digits into exec targets would let the DTE choose the arguments.
+type ExecTransport struct {
+	Dir string   // Working directory ("" = current)
+	Env []string // Extra environment variables (KEY=value) added to the vmodem environment
+}
+
+// Dial implements Transport
+func (t *ExecTransport) Dial(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	_, cmdLine := SplitTarget(target)
+	args := strings.Fields(cmdLine)
+	if len(args) == 0 {
+		return nil, ErrNoCarrier
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = t.Dir
+	cmd.Env = append(os.Environ(), t.Env...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		stdin.Close()
+		return nil, err
+	}
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Start(); err != nil {
+		stdin.Close()
+		r.Close()
+		w.Close()
+		return nil, err
+	}
+	w.Close() // the child holds the write end, reads get EOF when it exits
+	ec := &execConn{cmd: cmd, stdin: stdin, stdout: r, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(ec.done)
+	}()
+	return ec, nil
+}
+
+// execConn is the call side of a program run by ExecTransport
+type execConn struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    *os.File
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (ec *execConn) Read(b []byte) (int, error) {
+	return ec.stdout.Read(b)
+}
+
+func (ec *execConn) Write(b []byte) (int, error) {
+	return ec.stdin.Write(b)
+}
+
+// Close hangs up killing the program if it is still running
+func (ec *execConn) Close() error {
+	ec.closeOnce.Do(func() {
+		ec.stdin.Close()
+		select {
+		case <-ec.done:
+		default:
+			ec.cmd.Process.Kill()
+			<-ec.done
+		}
+		ec.stdout.Close()
+	})
+	return nil
+}
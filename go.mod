@@ -4,13 +4,11 @@ go 1.23.0
 
 require (
 	github.com/creack/pty v1.1.21
-	github.com/jaracil/nagle v0.0.0-20241003074037-c891ec0df2fe
 	github.com/jessevdk/go-flags v1.6.1
 	github.com/nayarsystems/iotrace v0.0.0-20241007120152-cf716b05d886
 	go.bug.st/serial v1.6.2
+	golang.org/x/sys v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/creack/goselect v0.1.2 // indirect
-	golang.org/x/sys v0.21.0 // indirect
-)
+require github.com/creack/goselect v0.1.2 // indirect
@@ -0,0 +1,96 @@
+package vmodem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"time"
+)
+
+// defaultHandshakeTimeout is the max wait for the answer token when Handshake.Timeout is not set
+const defaultHandshakeTimeout = 10 * time.Second
+
+// maxHandshakeSpeedLen bounds the speed field read during the negotiation
+const maxHandshakeSpeedLen = 12
+
+// Handshake is the exchange between the answering and the dialing side of a call before
+// CONNECT. The answering side sends Token when it answers, the dialing side waits for it
+// and hangs up with NO CARRIER if it differs or does not arrive within Timeout.
+// With Negotiate the answering side sends its LineSpeed in decimal followed by CR after
+// the token and the dialing side connects at the lower of both speeds (0 = unlimited).
+type Handshake struct {
+	Token     string
+	Timeout   time.Duration // Max wait for the answer on dialed calls (default 10s)
+	Negotiate bool
+}
+
+// sendHandshake sends the answer of an incoming call. Modem lock must be held.
+func (m *Modem) sendHandshake() {
+	hs := m.handshake
+	if hs == nil {
+		return
+	}
+	msg := hs.Token
+	if hs.Negotiate {
+		msg += strconv.Itoa(m.lineSpeed) + "\r"
+	}
+	if msg != "" {
+		m.conn.Write([]byte(msg))
+	}
+}
+
+// awaitHandshake waits for the answer of a dialed call and returns the negotiated line
+// speed (0 = LineSpeed). Modem lock must not be held.
+func (m *Modem) awaitHandshake(ctx context.Context, conn io.ReadWriteCloser) (int, error) {
+	hs := m.handshake
+	if hs == nil || (hs.Token == "" && !hs.Negotiate) {
+		return 0, nil
+	}
+	timeout := hs.Timeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	hsCtx, cancel := clockTimeout(ctx, m.clock, timeout)
+	defer cancel()
+	stop := context.AfterFunc(hsCtx, func() { conn.Close() })
+	defer stop()
+
+	token := make([]byte, len(hs.Token))
+	if _, err := io.ReadFull(conn, token); err != nil || !bytes.Equal(token, []byte(hs.Token)) {
+		return 0, ErrNoCarrier
+	}
+	if !hs.Negotiate {
+		return 0, nil
+	}
+	var field []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return 0, ErrNoCarrier
+		}
+		if b[0] == '\r' {
+			break
+		}
+		if len(field) == maxHandshakeSpeedLen {
+			return 0, ErrNoCarrier
+		}
+		field = append(field, b[0])
+	}
+	speed, err := strconv.Atoi(string(field))
+	if err != nil || speed < 0 {
+		return 0, ErrNoCarrier
+	}
+	if speed == 0 || (m.lineSpeed > 0 && m.lineSpeed < speed) {
+		return 0, nil
+	}
+	return speed, nil
+}
+
+// callLineSpeed returns the line speed of the current call, negotiated or LineSpeed (0 = unlimited)
+func (m *Modem) callLineSpeed() int {
+	if m.callSpeed > 0 {
+		return m.callSpeed
+	}
+	return m.lineSpeed
+}
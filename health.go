@@ -0,0 +1,76 @@
+package vmodem
+
+import (
+	"context"
+	"time"
+)
+
+// HealthFinding is the result of a single health check
+type HealthFinding struct {
+	// Check is the name of the check
+	Check string
+	// Ok is true when the check passed
+	Ok bool
+	// Detail describes the failure (empty when Ok)
+	Detail string
+}
+
+// HealthReport is the result of Modem.Healthy
+type HealthReport struct {
+	// Healthy is true when all checks passed
+	Healthy bool
+	// Status is the modem status at check time
+	Status ModemStatus
+	// Findings contains the result of every check performed
+	Findings []HealthFinding
+}
+
+func (r *HealthReport) add(check string, ok bool, detail string) {
+	if ok {
+		detail = ""
+	} else {
+		r.Healthy = false
+	}
+	r.Findings = append(r.Findings, HealthFinding{Check: check, Ok: ok, Detail: detail})
+}
+
+// Healthy runs a cheap liveness probe: the modem lock can be acquired before ctx expires,
+// the TTY is writable, internal goroutines are alive and the state machine is consistent.
+// Modem lock is acquired and released.
+func (m *Modem) Healthy(ctx context.Context) *HealthReport {
+	r := &HealthReport{Healthy: true}
+	for !m.TryLock() {
+		select {
+		case <-ctx.Done():
+			r.add("lock", false, "modem lock not acquired: "+ctx.Err().Error())
+			return r
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	defer m.Unlock()
+	r.add("lock", true, "")
+	r.Status = m.status()
+
+	if r.Status == StatusClosed {
+		r.add("state", false, "modem closed")
+		return r
+	}
+
+	_, err := m.tty.Write(nil)
+	r.add("tty", err == nil, "tty not writable")
+
+	r.add("ttyTask", m.ttyTaskAlive.Load(), "tty read task not running")
+
+	switch r.Status {
+	case StatusConnected:
+		r.add("onlineTask", m.onlineTasks.Load() > 0, "online task not running")
+		r.add("state", m.conn != nil, "connected without connection")
+	case StatusConnectedCmd, StatusRinging:
+		r.add("state", m.conn != nil, r.Status.String()+" without connection")
+	case StatusIdle:
+		r.add("state", m.conn == nil, "idle with connection attached")
+	default:
+		r.add("state", true, "")
+	}
+	return r
+}
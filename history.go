@@ -0,0 +1,104 @@
+package vmodem
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MetricsSample is a Metrics snapshot taken at a given time
+type MetricsSample struct {
+	Time time.Time
+	Metrics
+}
+
+// MetricsRecorder samples modem metrics at a fixed interval into a bounded history
+type MetricsRecorder struct {
+	sync.Mutex
+	m          *Modem
+	interval   time.Duration
+	maxSamples int
+	samples    []MetricsSample
+	cancel     context.CancelFunc
+}
+
+// NewMetricsRecorder starts recording metrics snapshots of the modem every interval,
+// keeping at most maxSamples (oldest samples are discarded).
+func NewMetricsRecorder(m *Modem, interval time.Duration, maxSamples int) *MetricsRecorder {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	if maxSamples <= 0 {
+		maxSamples = 1440
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &MetricsRecorder{
+		m:          m,
+		interval:   interval,
+		maxSamples: maxSamples,
+		cancel:     cancel,
+	}
+	go r.sampler(ctx)
+	return r
+}
+
+func (r *MetricsRecorder) sampler(ctx context.Context) {
+	for {
+		r.record()
+		if !clockSleep(ctx, r.m.clock, r.interval) {
+			return
+		}
+	}
+}
+
+func (r *MetricsRecorder) record() {
+	sample := MetricsSample{Time: r.m.clock.Now(), Metrics: *r.m.MetricsSync()}
+	r.Lock()
+	defer r.Unlock()
+	r.samples = append(r.samples, sample)
+	if len(r.samples) > r.maxSamples {
+		r.samples = r.samples[len(r.samples)-r.maxSamples:]
+	}
+}
+
+// Stop stops the sampler. Recorded samples are kept.
+func (r *MetricsRecorder) Stop() {
+	r.cancel()
+}
+
+// Samples returns a copy of the recorded history, oldest first.
+func (r *MetricsRecorder) Samples() []MetricsSample {
+	r.Lock()
+	defer r.Unlock()
+	return append([]MetricsSample(nil), r.samples...)
+}
+
+// WriteJSON writes the recorded history as a JSON array.
+func (r *MetricsRecorder) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r.Samples())
+}
+
+// WriteCSV writes the recorded history as CSV with a header row.
+func (r *MetricsRecorder) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "status", "ttyTxBytes", "ttyRxBytes", "connTxBytes", "connRxBytes", "numConns", "numInConns", "numOutConns"})
+	for _, s := range r.Samples() {
+		cw.Write([]string{
+			s.Time.Format(time.RFC3339),
+			s.Status.String(),
+			strconv.Itoa(s.TtyTxBytes),
+			strconv.Itoa(s.TtyRxBytes),
+			strconv.Itoa(s.ConnTxBytes),
+			strconv.Itoa(s.ConnRxBytes),
+			strconv.Itoa(s.NumConns),
+			strconv.Itoa(s.NumInConns),
+			strconv.Itoa(s.NumOutConns),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
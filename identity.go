@@ -0,0 +1,42 @@
+package vmodem
+
+import (
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+// Identity holds the identification strings reported by ATIn and +GMI/+GMM/+GMR/+GSN
+type Identity struct {
+	Manufacturer string         // +GMI and ATI4 (default "vmodem")
+	Model        string         // +GMM, ATI0 and ATI4 (default "vmodem")
+	Revision     string         // +GMR and ATI3 (default "1.0")
+	SerialNumber string         // +GSN and ATI5 (defaults to the modem Id)
+	Info         map[int]string // ATI0-ATI9 responses overriding the defaults, lines separated by "\n"
+}
+
+// identityInfo returns the ATIn response lines and false if n is not supported
+func (m *Modem) identityInfo(n int) ([]string, bool) {
+	if n < 0 || n > 9 {
+		return nil, false
+	}
+	if s, ok := m.identity.Info[n]; ok {
+		return strings.Split(s, "\n"), true
+	}
+	id := &m.identity
+	switch n {
+	case 0:
+		return []string{id.Model}, true
+	case 1: // ROM checksum
+		sum := crc32.ChecksumIEEE([]byte(id.Manufacturer + id.Model + id.Revision))
+		return []string{fmt.Sprintf("%03d", sum%1000)}, true
+	case 3:
+		return []string{id.Revision}, true
+	case 4:
+		return []string{id.Manufacturer + " " + id.Model}, true
+	case 5:
+		return []string{id.SerialNumber}, true
+	default: // ROM test (I2) and unassigned pages just answer OK
+		return nil, true
+	}
+}
@@ -0,0 +1,269 @@
+package vmodem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrUnknownPreset = errors.New("unknown link quality preset")
+
+// LinkQuality describes the impairments applied to the data path of a call
+type LinkQuality struct {
+	// Name is the preset name (informative)
+	Name string
+	// Throughput is the line speed in bits per second (0 = unlimited). 10 bits per byte are assumed (8N1).
+	Throughput int
+	// Latency is the one-way delay added to data
+	Latency time.Duration
+	// Jitter is the maximum random variation added to the latency
+	Jitter time.Duration
+	// ErrorRate is the bit error rate (probability of a bit being flipped)
+	ErrorRate float64
+}
+
+var linkPresets = map[string]LinkQuality{
+	"v21-300":     {Name: "v21-300", Throughput: 300, Latency: 20 * time.Millisecond, Jitter: 5 * time.Millisecond, ErrorRate: 1e-5},
+	"v22bis-2400": {Name: "v22bis-2400", Throughput: 2400, Latency: 40 * time.Millisecond, Jitter: 10 * time.Millisecond, ErrorRate: 1e-6},
+	"v32-9600":    {Name: "v32-9600", Throughput: 9600, Latency: 50 * time.Millisecond, Jitter: 10 * time.Millisecond, ErrorRate: 1e-6},
+	"v34-33k6":    {Name: "v34-33k6", Throughput: 33600, Latency: 70 * time.Millisecond, Jitter: 15 * time.Millisecond, ErrorRate: 1e-7},
+}
+
+// LinkPreset returns the named link quality preset (v21-300, v22bis-2400, v32-9600, v34-33k6).
+func LinkPreset(name string) (*LinkQuality, error) {
+	lq, ok := linkPresets[name]
+	if !ok {
+		return nil, ErrUnknownPreset
+	}
+	return &lq, nil
+}
+
+// LinkPresetNames returns the names of the available link quality presets.
+func LinkPresetNames() []string {
+	names := []string{}
+	for k := range linkPresets {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// errorExponent returns -log10 of the bit error rate (0 = error free, capped at 12)
+func (lq *LinkQuality) errorExponent() float64 {
+	if lq.ErrorRate <= 0 {
+		return 12
+	}
+	return math.Min(12, -math.Log10(lq.ErrorRate))
+}
+
+// SignalLevel returns a synthesized receive level in -dBm (AT%L), between 9 (best) and 43 (worst).
+func (lq *LinkQuality) SignalLevel() int {
+	level := 43 - int(4*lq.errorExponent())
+	return min(max(level, 9), 43)
+}
+
+// SignalQuality returns a synthesized eye quality monitor value (AT%Q), lower is better.
+func (lq *LinkQuality) SignalQuality() int {
+	eqm := 80 - int(10*lq.errorExponent()) + int(lq.Jitter/time.Millisecond)/5
+	return min(max(eqm, 0), 127)
+}
+
+type impairedChunk struct {
+	data    []byte
+	arrival time.Time
+}
+
+// impairLane applies link quality to one direction of the data path
+type impairLane struct {
+	busy time.Time // line busy until
+}
+
+func (l *impairLane) deliveryTime(lq *LinkQuality, rnd *rand.Rand, arrival time.Time, n int) time.Time {
+	start := arrival
+	if l.busy.After(start) {
+		start = l.busy
+	}
+	if lq.Throughput > 0 {
+		start = start.Add(time.Duration(n*10) * time.Second / time.Duration(lq.Throughput))
+	}
+	l.busy = start
+	delay := lq.Latency
+	if lq.Jitter > 0 {
+		delay += time.Duration(rnd.Int63n(int64(lq.Jitter)))
+	}
+	return start.Add(delay)
+}
+
+func corrupt(lq *LinkQuality, rnd *rand.Rand, data []byte) {
+	if lq.ErrorRate <= 0 {
+		return
+	}
+	for i := range data {
+		for bit := 0; bit < 8; bit++ {
+			if rnd.Float64() < lq.ErrorRate {
+				data[i] ^= 1 << bit
+			}
+		}
+	}
+}
+
+// impairedConn wraps a connection applying throughput, latency, jitter and error injection
+type impairedConn struct {
+	conn    io.ReadWriteCloser
+	clock   Clock
+	mu      sync.Mutex
+	lq      LinkQuality
+	rnd     *rand.Rand
+	rxLane  impairLane
+	txLane  impairLane
+	rxQueue chan impairedChunk
+	txQueue chan impairedChunk
+	rxBuf   []byte
+	rxErr   error
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newImpairedConn(conn io.ReadWriteCloser, lq LinkQuality, clock Clock) *impairedConn {
+	c := &impairedConn{
+		conn:    conn,
+		clock:   clock,
+		lq:      lq,
+		rnd:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		rxQueue: make(chan impairedChunk, 64),
+		txQueue: make(chan impairedChunk, 64),
+		done:    make(chan struct{}),
+	}
+	go c.rxTask()
+	go c.txTask()
+	return c
+}
+
+func (c *impairedConn) setLinkQuality(lq LinkQuality) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lq = lq
+}
+
+func (c *impairedConn) linkQuality() LinkQuality {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lq
+}
+
+func (c *impairedConn) schedule(lane *impairLane, ch impairedChunk) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	corrupt(&c.lq, c.rnd, ch.data)
+	return lane.deliveryTime(&c.lq, c.rnd, ch.arrival, len(ch.data))
+}
+
+func (c *impairedConn) wait(at time.Time) bool {
+	select {
+	case <-c.clock.After(at.Sub(c.clock.Now())):
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+func (c *impairedConn) rxTask() {
+	defer close(c.rxQueue)
+	buff := make([]byte, 512)
+	for {
+		n, err := c.conn.Read(buff)
+		if n > 0 {
+			select {
+			case c.rxQueue <- impairedChunk{data: append([]byte(nil), buff[:n]...), arrival: c.clock.Now()}:
+			case <-c.done:
+				return
+			}
+		}
+		if err != nil {
+			c.rxErr = err
+			return
+		}
+	}
+}
+
+func (c *impairedConn) txTask() {
+	for {
+		select {
+		case ch := <-c.txQueue:
+			if !c.wait(c.schedule(&c.txLane, ch)) {
+				return
+			}
+			if _, err := c.conn.Write(ch.data); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Read implements io.Reader
+func (c *impairedConn) Read(b []byte) (int, error) {
+	if len(c.rxBuf) == 0 {
+		ch, ok := <-c.rxQueue
+		if !ok {
+			if c.rxErr != nil {
+				return 0, c.rxErr
+			}
+			return 0, io.EOF
+		}
+		if !c.wait(c.schedule(&c.rxLane, ch)) {
+			return 0, io.ErrClosedPipe
+		}
+		c.rxBuf = ch.data
+	}
+	n := copy(b, c.rxBuf)
+	c.rxBuf = c.rxBuf[n:]
+	return n, nil
+}
+
+// Write implements io.Writer. Data is queued and delivered at line speed.
+func (c *impairedConn) Write(b []byte) (int, error) {
+	select {
+	case c.txQueue <- impairedChunk{data: append([]byte(nil), b...), arrival: c.clock.Now()}:
+		return len(b), nil
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+// Close implements io.Closer
+func (c *impairedConn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	return c.conn.Close()
+}
+
+// SendBreak implements BreakSender forwarding to the wrapped connection (ahead of queued data)
+func (c *impairedConn) SendBreak() error {
+	if bs, ok := c.conn.(BreakSender); ok {
+		return bs.SendBreak()
+	}
+	return ErrBreakNotSupported
+}
+
+// SetBulkMode implements LatencyModeSetter forwarding to the wrapped connection
+func (c *impairedConn) SetBulkMode(bulk bool) {
+	if lms, ok := c.conn.(LatencyModeSetter); ok {
+		lms.SetBulkMode(bulk)
+	}
+}
+
+// Ping implements Pinger forwarding to the wrapped connection
+func (c *impairedConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return ErrPingNotSupported
+}
@@ -0,0 +1,116 @@
+package vmodem
+
+import (
+	"context"
+	"expvar"
+	"log/slog"
+	"sync"
+)
+
+// Tracer receives spans of calls (dial or ring, connect, disconnect) and AT command lines.
+// It follows the OpenTelemetry tracing API so adapters are thin wrappers: Start maps to
+// trace.Tracer.Start, Span.Event to AddEvent and Span.End to RecordError/SetStatus and End.
+// Spans are started and ended with the modem lock held, implementations must not block.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Span is an operation traced by a Tracer
+type Span interface {
+	Event(name string, attrs ...slog.Attr)
+	End(err error) // err is nil when the operation succeeded
+}
+
+// Span names
+const (
+	SpanCall    = "vmodem.call"
+	SpanCommand = "vmodem.command"
+)
+
+// startCallSpan starts the span of a call entering dialing or ringing status
+func (m *Modem) startCallSpan(dir CallDirection, number, remote string) {
+	if m.tracer == nil {
+		return
+	}
+	attrs := []slog.Attr{slog.String("modem.id", m.id), slog.String("call.direction", dir.String())}
+	if number != "" {
+		attrs = append(attrs, slog.String("call.number", number))
+	}
+	if remote != "" {
+		attrs = append(attrs, slog.String("call.remote", remote))
+	}
+	_, m.callSpan = m.tracer.Start(context.Background(), SpanCall, attrs...)
+}
+
+// traceStatus records the status transitions of the traced call. Called by setStatus before
+// the call state is cleared.
+func (m *Modem) traceStatus(prevStatus, status ModemStatus) {
+	span := m.callSpan
+	if span == nil {
+		return
+	}
+	switch {
+	case status == StatusConnected && prevStatus != StatusConnectedCmd:
+		span.Event("connect", slog.Int("call.speed", m.callLineSpeed()))
+	case status == StatusIdle || status == StatusClosed:
+		m.callSpan = nil
+		switch prevStatus {
+		case StatusDialing:
+			span.End(retCodeError(m.dialFailCode))
+		case StatusRinging:
+			span.End(ErrNoAnswer)
+		default:
+			reason := m.hangupReason
+			if status == StatusClosed {
+				reason = DisconnectClosed
+			}
+			span.Event("disconnect", slog.String("call.reason", reason.String()))
+			span.End(nil)
+		}
+	}
+}
+
+// retCodeError maps the result code of a failed dial to the error of its span
+func retCodeError(ret RetCode) error {
+	switch ret {
+	case RetCodeBusy:
+		return ErrBusy
+	case RetCodeNoAnswer:
+		return ErrNoAnswer
+	case RetCodeNoDialtone:
+		return ErrNoDialtone
+	default:
+		return ErrNoCarrier
+	}
+}
+
+// traceCommand starts the span of an AT command line, ended with the result code
+func (m *Modem) traceCommand(cmd string) func(ret RetCode) {
+	if m.tracer == nil {
+		return func(RetCode) {}
+	}
+	_, span := m.tracer.Start(context.Background(), SpanCommand, slog.String("modem.id", m.id), slog.String("command.line", cmd))
+	return func(ret RetCode) {
+		span.Event("result", slog.String("command.result", ret.String()))
+		if ret == RetCodeError {
+			span.End(ErrCommandFailed)
+		} else {
+			span.End(nil)
+		}
+	}
+}
+
+// expvarModems returns the expvar map "vmodem" holding the metrics snapshot of each modem by id
+var expvarModems = sync.OnceValue(func() *expvar.Map {
+	return expvar.NewMap("vmodem")
+})
+
+func (m *Modem) publishExpvar() {
+	expvarModems().Set(m.id, expvar.Func(func() any {
+		return m.MetricsSnapshot()
+	}))
+}
+
+func (m *Modem) unpublishExpvar() {
+	expvarModems().Delete(m.id)
+}
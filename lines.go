@@ -0,0 +1,40 @@
+package vmodem
+
+// ControlLines is implemented by embedders able to model the modem control lines of the
+// DTE interface (PTY, UART, USB gadget). The modem reports the DCD (Data Carrier Detect)
+// line as set with AT&C, DTR transitions are fed into the modem with SetDTR/SetDTRSync.
+type ControlLines interface {
+	// SetDCD is called (modem lock held) when the DCD line changes and when the modem is created
+	SetDCD(on bool)
+}
+
+// dcdLine returns the DCD line state: always on with &C0, following the carrier with &C1
+func (m *Modem) dcdLine() bool {
+	return m.dcdMode == 0 || m.status() == StatusConnected || m.status() == StatusConnectedCmd
+}
+
+// updateDCD notifies the control lines when the DCD line changes
+func (m *Modem) updateDCD() {
+	dcd := m.dcdLine()
+	if dcd == m.dcd {
+		return
+	}
+	m.dcd = dcd
+	if m.controlLines != nil {
+		m.controlLines.SetDCD(dcd)
+	}
+}
+
+// DCD returns the DCD (Data Carrier Detect) line state reported to the DTE. Modem lock must be held.
+func (m *Modem) DCD() bool {
+	m.checkLock()
+	return m.dcd
+}
+
+// DCDSync returns the DCD (Data Carrier Detect) line state reported to the DTE.
+// Modem lock is acquired and released.
+func (m *Modem) DCDSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.dcd
+}
@@ -0,0 +1,50 @@
+package vmodem
+
+import (
+	"context"
+	"time"
+)
+
+// linePaceInterval is the granularity of line speed pacing
+const linePaceInterval = 50 * time.Millisecond
+
+// newLineLimiter returns a token bucket (bytes) pacing data at bps line speed, 10 bits per byte (8N1)
+func newLineLimiter(bps int, clock Clock) *rateLimiter {
+	if bps <= 0 {
+		return nil
+	}
+	rate := float64(bps) / 10
+	burst := max(rate*linePaceInterval.Seconds(), 1)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: clock.Now(), clock: clock}
+}
+
+// lineChunk returns the max number of bytes moved at once, so data flows at an even pace
+func (m *Modem) lineChunk() int {
+	speed := m.callLineSpeed()
+	if speed <= 0 {
+		return m.readBufferSize
+	}
+	return min(m.readBufferSize, max(1, int(float64(speed)/10*linePaceInterval.Seconds())))
+}
+
+// pace waits (releasing the modem lock) until the line limiter allows n more bytes. Modem lock must be held.
+func (m *Modem) pace(l *rateLimiter, n int) {
+	if l == nil {
+		return
+	}
+	if wait := l.reserve(n); wait > 0 {
+		m.Unlock()
+		<-m.clock.After(wait)
+		m.Lock()
+	}
+}
+
+// paceCtx waits until the line limiter allows n more bytes or ctx is done. Modem lock must not be held.
+func paceCtx(ctx context.Context, l *rateLimiter, n int) {
+	if l == nil {
+		return
+	}
+	if wait := l.reserve(n); wait > 0 {
+		clockSleep(ctx, l.clock, wait)
+	}
+}
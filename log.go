@@ -0,0 +1,28 @@
+package vmodem
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops every log record (library default, the modem is silent)
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// Logger returns the logger of the modem, records carry the modem id. Hooks can use it
+// to log in the same stream.
+func (m *Modem) Logger() *slog.Logger {
+	return m.log
+}
+
+// reportError logs a runtime anomaly and delivers it to the ErrorHook
+func (m *Modem) reportError(err error) {
+	m.log.Warn("error", "error", err)
+	if m.errorHook != nil {
+		m.errorHook(m, err)
+	}
+}
@@ -0,0 +1,164 @@
+package vmodem
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// Self test modes (AT&T)
+const (
+	testNone           = 0 // No test in progress
+	testAnalogLoopback = 1 // AT&T1, data sent by the DTE is echoed back to it
+	testRemoteLoopback = 6 // AT&T6, data sent to the remote modem is looped back by it
+)
+
+// rdlRequest and rdlRelease are sent in-band to start and end the remote digital loopback of
+// a cooperating vmodem peer. The peer loops back the data received while it grants remote
+// loopback requests (AT&T4) and passes the sequences to its DTE otherwise (AT&T5).
+var (
+	rdlRequest = []byte("\x10\x02VMODEM-RDL\x10\x03")
+	rdlRelease = []byte("\x10\x02VMODEM-RDL-END\x10\x03")
+)
+
+// inbandDetector recognizes the in-band control sequences of vmodem peers (remote digital
+// loopback, transparent mode negotiation) in the data received, split across reads or not
+type inbandDetector struct {
+	mu   sync.Mutex
+	tail []byte // Held back bytes, possible start of a sequence
+	gen  int    // Incremented by each scan, invalidates the pending hold timeouts
+}
+
+// inbandHold is how long the possible start of an in-band sequence is held back waiting
+// for the rest of it before it is passed on as data.
+const inbandHold = 100 * time.Millisecond
+
+// scan looks for the first of seqs in the held back bytes followed by data. Returns the
+// data before the sequence, the sequence (nil = none found) and the data after it. The
+// trailing bytes that may start a sequence are held back instead of returned as data.
+func (d *inbandDetector) scan(data []byte, seqs [][]byte) (out, found, rest []byte) {
+	d.gen++
+	buf := append(d.tail[:len(d.tail):len(d.tail)], data...)
+	first := -1
+	for _, seq := range seqs {
+		if i := bytes.Index(buf, seq); i >= 0 && (first < 0 || i < first) {
+			first = i
+			found = seq
+		}
+	}
+	if first >= 0 {
+		d.tail = d.tail[:0]
+		return buf[:first], found, buf[first+len(found):]
+	}
+	keep := 0
+	for _, seq := range seqs {
+		for n := min(len(buf), len(seq)-1); n > keep; n-- {
+			if bytes.HasPrefix(seq, buf[len(buf)-n:]) {
+				keep = n
+				break
+			}
+		}
+	}
+	out = buf[:len(buf)-keep]
+	d.tail = append([]byte(nil), buf[len(buf)-keep:]...)
+	return out, nil, nil
+}
+
+// flush returns and forgets the held back bytes
+func (d *inbandDetector) flush() []byte {
+	d.gen++
+	tail := d.tail
+	d.tail = nil
+	return tail
+}
+
+// loopbackConn is the line of the local analog loopback test: data written is read back
+type loopbackConn struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+}
+
+func newLoopbackConn() *loopbackConn {
+	c := &loopbackConn{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *loopbackConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.buf) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, io.EOF
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *loopbackConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.buf = append(c.buf, p...)
+	c.cond.Broadcast()
+	return len(p), nil
+}
+
+func (c *loopbackConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.cond.Broadcast()
+	return nil
+}
+
+// testCommand processes AT&Tn: 0 ends the test in progress, 1 starts the local analog loopback
+// from idle, 4 and 5 grant and deny the remote digital loopback requested by the remote modem and
+// 6 requests the remote digital loopback in online command mode
+func (m *Modem) testCommand(n int) RetCode {
+	switch n {
+	case 0:
+		switch m.testMode {
+		case testAnalogLoopback:
+			m.setStatus(StatusIdle)
+		case testRemoteLoopback:
+			m.testMode = testNone
+			if m.conn != nil {
+				m.conn.Write(rdlRelease)
+			}
+			m.log.Info("remote digital loopback released")
+		}
+	case 1:
+		if m.status() != StatusIdle {
+			return RetCodeError
+		}
+		m.log.Info("local analog loopback")
+		m.testMode = testAnalogLoopback
+		m.conn = newLoopbackConn()
+		m.setStatus(StatusDialing)
+		m.setStatus(StatusConnected)
+		return RetCodeSilent
+	case 4, 5:
+		m.rdlGrant.Store(n == 4)
+	case 6:
+		if m.status() != StatusConnectedCmd || m.testMode != testNone || m.conn == nil {
+			return RetCodeError
+		}
+		if _, err := m.conn.Write(rdlRequest); err != nil {
+			return RetCodeError
+		}
+		m.testMode = testRemoteLoopback
+		m.log.Info("remote digital loopback requested")
+	default:
+		return RetCodeError
+	}
+	return RetCodeOk
+}
@@ -0,0 +1,236 @@
+package vmodem
+
+import (
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricCounters hold the metrics of a modem. They are atomics updated without the modem
+// lock, so the online data pump never contends with commands and state changes.
+type metricCounters struct {
+	status                                            atomic.Int32 // Mirror of the modem status for lock free snapshots
+	ttyTx, ttyRx, connTx, connRx                      atomic.Int64
+	numConns, numInConns, numOutConns, numFailedDials atomic.Int64
+	sregReads, sregWrites                             atomic.Int64
+	hookHandledCmds, cmdErrors, throttledEvents       atomic.Int64
+	lastTtyTx, lastTtyRx, lastAtCmd, lastConn         atomic.Int64 // Unix nanoseconds (0 = never)
+	connTxRate, connRxRate                            rateMeter
+
+	// Current call, kept by ResetMetrics
+	callStart      atomic.Int64 // Unix nanoseconds (0 = no call)
+	callTx, callRx atomic.Int64
+	lastActivity   atomic.Int64 // Unix nanoseconds of the last data of the call
+
+	mu         sync.Mutex // Guards commands and lastRemote
+	commands   map[string]int
+	lastRemote string
+}
+
+func (m *Modem) storeNow(v *atomic.Int64) {
+	v.Store(m.clock.Now().UnixNano())
+}
+
+// loadTime returns the time stored by storeNow (zero time = never)
+func loadTime(v *atomic.Int64) time.Time {
+	ns := v.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (c *metricCounters) countCommand(cmd string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.commands == nil {
+		c.commands = map[string]int{}
+	}
+	c.commands[cmd]++
+}
+
+func (c *metricCounters) setLastRemote(remote string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastRemote = remote
+}
+
+// rateMeter measures throughput as the bytes counted during the last whole second
+type rateMeter struct {
+	sec  atomic.Int64 // Unix second being counted in cur
+	cur  atomic.Int64
+	last atomic.Int64 // Bytes counted during sec-1
+}
+
+func (r *rateMeter) add(now time.Time, n int) {
+	sec := now.Unix()
+	for {
+		prevSec := r.sec.Load()
+		if prevSec == sec {
+			r.cur.Add(int64(n))
+			return
+		}
+		if r.sec.CompareAndSwap(prevSec, sec) {
+			prev := r.cur.Swap(int64(n))
+			if sec != prevSec+1 {
+				prev = 0 // idle for more than a second
+			}
+			r.last.Store(prev)
+			return
+		}
+	}
+}
+
+// rate returns the bytes per second of the last whole second before now
+func (r *rateMeter) rate(now time.Time) int {
+	switch r.sec.Load() {
+	case now.Unix():
+		return int(r.last.Load())
+	case now.Unix() - 1:
+		return int(r.cur.Load())
+	}
+	return 0
+}
+
+func (r *rateMeter) reset() {
+	r.cur.Store(0)
+	r.last.Store(0)
+}
+
+// MetricsSnapshot is a JSON friendly copy of the metrics of a modem
+type MetricsSnapshot struct {
+	Id              string         `json:"id"`
+	Time            time.Time      `json:"time"`   // When the snapshot was taken
+	Status          string         `json:"status"` // Status name (Idle, Dialing, Ringing...)
+	TtyTxBytes      int            `json:"ttyTxBytes"`
+	TtyRxBytes      int            `json:"ttyRxBytes"`
+	ConnTxBytes     int            `json:"connTxBytes"`
+	ConnRxBytes     int            `json:"connRxBytes"`
+	ConnTxRate      int            `json:"connTxRate"` // Bytes per second sent to the connection during the last second
+	ConnRxRate      int            `json:"connRxRate"` // Bytes per second received from the connection during the last second
+	NumConns        int            `json:"numConns"`
+	NumInConns      int            `json:"numInConns"`
+	NumOutConns     int            `json:"numOutConns"`
+	NumFailedDials  int            `json:"numFailedDials"`
+	CallDurationMs  int64          `json:"callDurationMs"` // Duration of the current call (0 = no call)
+	LastTtyTxMs     int64          `json:"lastTtyTxMs"`    // Milliseconds since the last tty transmit (-1 = never)
+	LastTtyRxMs     int64          `json:"lastTtyRxMs"`    // Milliseconds since the last tty receive (-1 = never)
+	LastAtCmdMs     int64          `json:"lastAtCmdMs"`    // Milliseconds since the last AT command (-1 = never)
+	LastConnMs      int64          `json:"lastConnMs"`     // Milliseconds since the last connection (-1 = never)
+	LastRemote      string         `json:"lastRemote"`
+	Commands        map[string]int `json:"commands"`
+	SRegReads       int            `json:"sRegReads"`
+	SRegWrites      int            `json:"sRegWrites"`
+	HookHandledCmds int            `json:"hookHandledCmds"`
+	CmdErrors       int            `json:"cmdErrors"`
+	ThrottledEvents int            `json:"throttledEvents"`
+}
+
+// metricsCopy reads the counters into plain values
+func (m *Modem) metricsCopy() *Metrics {
+	c := &m.metrics
+	now := m.clock.Now()
+	mt := &Metrics{
+		Status:          ModemStatus(c.status.Load()),
+		TtyTxBytes:      int(c.ttyTx.Load()),
+		TtyRxBytes:      int(c.ttyRx.Load()),
+		ConnTxBytes:     int(c.connTx.Load()),
+		ConnRxBytes:     int(c.connRx.Load()),
+		ConnTxRate:      c.connTxRate.rate(now),
+		ConnRxRate:      c.connRxRate.rate(now),
+		NumConns:        int(c.numConns.Load()),
+		NumInConns:      int(c.numInConns.Load()),
+		NumOutConns:     int(c.numOutConns.Load()),
+		NumFailedDials:  int(c.numFailedDials.Load()),
+		LastTtyTxTime:   loadTime(&c.lastTtyTx),
+		LastTtyRxTime:   loadTime(&c.lastTtyRx),
+		LastAtCmdTime:   loadTime(&c.lastAtCmd),
+		LastConnTime:    loadTime(&c.lastConn),
+		SRegReads:       int(c.sregReads.Load()),
+		SRegWrites:      int(c.sregWrites.Load()),
+		HookHandledCmds: int(c.hookHandledCmds.Load()),
+		CmdErrors:       int(c.cmdErrors.Load()),
+		ThrottledEvents: int(c.throttledEvents.Load()),
+	}
+	if start := loadTime(&c.callStart); !start.IsZero() {
+		mt.CallDuration = now.Sub(start)
+	}
+	c.mu.Lock()
+	mt.LastRemote = c.lastRemote
+	mt.Commands = maps.Clone(c.commands)
+	c.mu.Unlock()
+	if mt.Commands == nil {
+		mt.Commands = map[string]int{}
+	}
+	return mt
+}
+
+// Metrics returns a copy of the modem metrics. Modem lock must be held.
+func (m *Modem) Metrics() *Metrics {
+	m.checkLock()
+	return m.metricsCopy()
+}
+
+// MetricsSync returns a copy of the modem metrics. Modem lock is acquired and released.
+func (m *Modem) MetricsSync() *Metrics {
+	m.Lock()
+	defer m.Unlock()
+	return m.metricsCopy()
+}
+
+// MetricsSnapshot returns a JSON friendly copy of the modem metrics. The metrics are atomics,
+// modem lock not required.
+func (m *Modem) MetricsSnapshot() MetricsSnapshot {
+	mt := m.metricsCopy()
+	now := m.clock.Now()
+	sinceMs := func(t time.Time) int64 {
+		if t.IsZero() {
+			return -1
+		}
+		return now.Sub(t).Milliseconds()
+	}
+	return MetricsSnapshot{
+		Id:              m.id,
+		Time:            now,
+		Status:          mt.Status.String(),
+		TtyTxBytes:      mt.TtyTxBytes,
+		TtyRxBytes:      mt.TtyRxBytes,
+		ConnTxBytes:     mt.ConnTxBytes,
+		ConnRxBytes:     mt.ConnRxBytes,
+		ConnTxRate:      mt.ConnTxRate,
+		ConnRxRate:      mt.ConnRxRate,
+		NumConns:        mt.NumConns,
+		NumInConns:      mt.NumInConns,
+		NumOutConns:     mt.NumOutConns,
+		NumFailedDials:  mt.NumFailedDials,
+		CallDurationMs:  mt.CallDuration.Milliseconds(),
+		LastTtyTxMs:     sinceMs(mt.LastTtyTxTime),
+		LastTtyRxMs:     sinceMs(mt.LastTtyRxTime),
+		LastAtCmdMs:     sinceMs(mt.LastAtCmdTime),
+		LastConnMs:      sinceMs(mt.LastConnTime),
+		LastRemote:      mt.LastRemote,
+		Commands:        mt.Commands,
+		SRegReads:       mt.SRegReads,
+		SRegWrites:      mt.SRegWrites,
+		HookHandledCmds: mt.HookHandledCmds,
+		CmdErrors:       mt.CmdErrors,
+		ThrottledEvents: mt.ThrottledEvents,
+	}
+}
+
+// ResetMetrics zeroes the counters of the modem (bytes, connections, commands...). The
+// timestamps, the last remote and the current call are kept. Modem lock not required.
+func (m *Modem) ResetMetrics() {
+	c := &m.metrics
+	for _, v := range []*atomic.Int64{&c.ttyTx, &c.ttyRx, &c.connTx, &c.connRx, &c.numConns, &c.numInConns,
+		&c.numOutConns, &c.numFailedDials, &c.sregReads, &c.sregWrites, &c.hookHandledCmds, &c.cmdErrors,
+		&c.throttledEvents} {
+		v.Store(0)
+	}
+	c.connTxRate.reset()
+	c.connRxRate.reset()
+	c.mu.Lock()
+	c.commands = nil
+	c.mu.Unlock()
+}
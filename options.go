@@ -0,0 +1,271 @@
+package vmodem
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Option configures a modem created with NewModemWithOptions
+type Option func(*ModemConfig)
+
+// Hooks groups the callbacks of a modem, nil hooks are left unchanged by WithHooks
+type Hooks struct {
+	OutgoingCall       OutgoingCallType
+	OutgoingCallCtx    OutgoingCallCtxType
+	CommandHook        CommandHookType
+	CommandParamsHook  CommandHandler
+	StatusTransition   StatusTransitionType
+	ResultHook         ResultHookType
+	ErrorHook          ErrorHookType
+	CallEnded          CallEndedType
+	IncomingCallFilter IncomingCallFilterType
+	BreakHook          BreakHookType
+}
+
+// NewModemWithOptions creates a modem attached to tty configured by opts, see ModemConfig
+// for the meaning and defaults of every setting. Returns an error wrapping ErrInvalidValue
+// describing the first nonsensical setting.
+func NewModemWithOptions(tty io.ReadWriteCloser, opts ...Option) (*Modem, error) {
+	config := &ModemConfig{TTY: tty}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return NewModem(config)
+}
+
+// WithConfig starts from a copy of config, the TTY passed to NewModemWithOptions is kept
+func WithConfig(config ModemConfig) Option {
+	return func(c *ModemConfig) {
+		tty := c.TTY
+		*c = config
+		c.TTY = tty
+	}
+}
+
+// WithId sets the modem id
+func WithId(id string) Option {
+	return func(c *ModemConfig) { c.Id = id }
+}
+
+// WithHooks sets the non nil hooks of h
+func WithHooks(h Hooks) Option {
+	return func(c *ModemConfig) {
+		if h.OutgoingCall != nil {
+			c.OutgoingCall = h.OutgoingCall
+		}
+		if h.OutgoingCallCtx != nil {
+			c.OutgoingCallCtx = h.OutgoingCallCtx
+		}
+		if h.CommandHook != nil {
+			c.CommandHook = h.CommandHook
+		}
+		if h.CommandParamsHook != nil {
+			c.CommandParamsHook = h.CommandParamsHook
+		}
+		if h.StatusTransition != nil {
+			c.StatusTransition = h.StatusTransition
+		}
+		if h.ResultHook != nil {
+			c.ResultHook = h.ResultHook
+		}
+		if h.ErrorHook != nil {
+			c.ErrorHook = h.ErrorHook
+		}
+		if h.CallEnded != nil {
+			c.CallEnded = h.CallEnded
+		}
+		if h.IncomingCallFilter != nil {
+			c.IncomingCallFilter = h.IncomingCallFilter
+		}
+		if h.BreakHook != nil {
+			c.BreakHook = h.BreakHook
+		}
+	}
+}
+
+// WithOutgoingCallCtx sets the hook placing outgoing calls
+func WithOutgoingCallCtx(f OutgoingCallCtxType) Option {
+	return func(c *ModemConfig) { c.OutgoingCallCtx = f }
+}
+
+// WithDialPlan sets the phonebook used to place calls through the registered transports
+func WithDialPlan(dp *DialPlan) Option {
+	return func(c *ModemConfig) { c.DialPlan = dp }
+}
+
+// WithConnectStr sets the text sent after CONNECT
+func WithConnectStr(s string) Option {
+	return func(c *ModemConfig) { c.ConnectStr = s }
+}
+
+// WithGuardTime sets the escape guard time in 50ms increments (0-255, factory value of S12)
+func WithGuardTime(units int) Option {
+	return func(c *ModemConfig) { c.GuardTime = units }
+}
+
+// WithEscape sets the escape character (factory value of S2, > 127 disables the escape sequence)
+// and whether it is followed by an AT command line instead of guard times (TIES)
+func WithEscape(char int, ties bool) Option {
+	return func(c *ModemConfig) {
+		c.EscapeChar = char
+		c.TIES = ties
+	}
+}
+
+// WithRingMax sets the rings sent before giving up an unanswered incoming call
+func WithRingMax(rings int) Option {
+	return func(c *ModemConfig) { c.RingMax = rings }
+}
+
+// WithAutoAnswer sets the rings before answering (0-255, factory value of S0, 0 = disabled)
+func WithAutoAnswer(rings int) Option {
+	return func(c *ModemConfig) { c.AutoAnswerRings = rings }
+}
+
+// WithHandshake sets the exchange with the remote side before CONNECT
+func WithHandshake(hs *Handshake) Option {
+	return func(c *ModemConfig) { c.Handshake = hs }
+}
+
+// WithIdentity sets the ATIn and +GMI/+GMM/+GMR/+GSN responses
+func WithIdentity(id Identity) Option {
+	return func(c *ModemConfig) { c.Identity = id }
+}
+
+// WithTelnet makes connections speak Telnet
+func WithTelnet(on bool) Option {
+	return func(c *ModemConfig) { c.Telnet = on }
+}
+
+// WithBulkMode makes connection writes coalesce
+func WithBulkMode(on bool) Option {
+	return func(c *ModemConfig) { c.BulkMode = on }
+}
+
+// WithLineSpeed sets the simulated line speed in bps (0 = unlimited)
+func WithLineSpeed(bps int) Option {
+	return func(c *ModemConfig) { c.LineSpeed = bps }
+}
+
+// WithLinkQuality sets the line impairments applied to every call
+func WithLinkQuality(lq *LinkQuality) Option {
+	return func(c *ModemConfig) { c.LinkQuality = lq }
+}
+
+// WithRateLimits sets the max command lines per second and echoed bytes per second (0 = unlimited)
+func WithRateLimits(cmds, echo float64) Option {
+	return func(c *ModemConfig) {
+		c.CmdRateLimit = cmds
+		c.EchoRateLimit = echo
+	}
+}
+
+// WithTimeouts sets the idle, max call duration and dial timeouts (0 = disabled)
+func WithTimeouts(idle, maxCall, dial time.Duration) Option {
+	return func(c *ModemConfig) {
+		c.IdleTimeout = idle
+		c.MaxCallDuration = maxCall
+		c.DialTimeout = dial
+	}
+}
+
+// WithPing pings connections implementing Pinger every interval while online
+func WithPing(interval, timeout time.Duration) Option {
+	return func(c *ModemConfig) {
+		c.PingInterval = interval
+		c.PingTimeout = timeout
+	}
+}
+
+// WithRedial sets the redial policy
+func WithRedial(p *RedialPolicy) Option {
+	return func(c *ModemConfig) { c.Redial = p }
+}
+
+// WithProfileStore sets where the profiles saved with AT&W0/1 are persisted
+func WithProfileStore(ps ProfileStore) Option {
+	return func(c *ModemConfig) { c.ProfileStore = ps }
+}
+
+// WithLogger sets the structured logger
+func WithLogger(l *slog.Logger) Option {
+	return func(c *ModemConfig) { c.Logger = l }
+}
+
+// WithClock sets the time source of the modem timers
+func WithClock(clk Clock) Option {
+	return func(c *ModemConfig) { c.Clock = clk }
+}
+
+// WithTracer sets the tracer of calls and AT command lines
+func WithTracer(t Tracer) Option {
+	return func(c *ModemConfig) { c.Tracer = t }
+}
+
+// Validate checks the settings of the configuration. Returns an error wrapping
+// ErrInvalidValue describing the first nonsensical setting.
+func (c *ModemConfig) Validate() error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrInvalidValue, fmt.Sprintf(format, args...))
+	}
+	switch {
+	case c.RingMax < 0:
+		return invalid("RingMax %d is negative", c.RingMax)
+	case c.AutoAnswerRings < 0 || c.AutoAnswerRings > 255:
+		return invalid("AutoAnswerRings %d out of range 0-255", c.AutoAnswerRings)
+	case c.GuardTime < 0 || c.GuardTime > 255:
+		return invalid("GuardTime %d out of range 0-255", c.GuardTime)
+	case c.EscapeChar < 0 || c.EscapeChar > 255:
+		return invalid("EscapeChar %d out of range 0-255", c.EscapeChar)
+	case len(c.AbortChar) > 1:
+		return invalid("AbortChar %q is longer than one character", c.AbortChar)
+	case c.SRegPolicy < SRegStore || c.SRegPolicy > SRegReject:
+		return invalid("SRegPolicy %d unknown", c.SRegPolicy)
+	case c.CmdHistoryLen < 0:
+		return invalid("CmdHistoryLen %d is negative", c.CmdHistoryLen)
+	case c.CmdBufferLimit < 0:
+		return invalid("CmdBufferLimit %d is negative", c.CmdBufferLimit)
+	case c.CmdRateLimit < 0:
+		return invalid("CmdRateLimit %g is negative", c.CmdRateLimit)
+	case c.EchoRateLimit < 0:
+		return invalid("EchoRateLimit %g is negative", c.EchoRateLimit)
+	case c.ReadBufferSize < 0:
+		return invalid("ReadBufferSize %d is negative", c.ReadBufferSize)
+	case c.WriteBufferSize < 0:
+		return invalid("WriteBufferSize %d is negative", c.WriteBufferSize)
+	case c.LineSpeed < 0:
+		return invalid("LineSpeed %d is negative", c.LineSpeed)
+	}
+	for _, d := range []struct {
+		name string
+		val  time.Duration
+	}{
+		{"CoalesceDelay", c.CoalesceDelay},
+		{"IdleTimeout", c.IdleTimeout},
+		{"MaxCallDuration", c.MaxCallDuration},
+		{"HandshakeTime", c.HandshakeTime},
+		{"DialTimeout", c.DialTimeout},
+		{"PingInterval", c.PingInterval},
+		{"PingTimeout", c.PingTimeout},
+	} {
+		if d.val < 0 {
+			return invalid("%s %v is negative", d.name, d.val)
+		}
+	}
+	if c.Handshake != nil && c.Handshake.Timeout < 0 {
+		return invalid("Handshake.Timeout %v is negative", c.Handshake.Timeout)
+	}
+	if r := c.Redial; r != nil {
+		switch {
+		case r.Attempts < 0:
+			return invalid("Redial.Attempts %d is negative", r.Attempts)
+		case r.Delay < 0:
+			return invalid("Redial.Delay %v is negative", r.Delay)
+		case r.MaxDelay < 0:
+			return invalid("Redial.MaxDelay %v is negative", r.MaxDelay)
+		}
+	}
+	return nil
+}
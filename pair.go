@@ -0,0 +1,122 @@
+package vmodem
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// pipeBuffer is one direction of an in-memory buffered pipe
+type pipeBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newPipeBuffer() *pipeBuffer {
+	pb := &pipeBuffer{}
+	pb.cond = sync.NewCond(&pb.mu)
+	return pb
+}
+
+func (pb *pipeBuffer) read(b []byte) (int, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	for pb.buf.Len() == 0 && !pb.closed {
+		pb.cond.Wait()
+	}
+	if pb.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return pb.buf.Read(b)
+}
+
+func (pb *pipeBuffer) write(b []byte) (int, error) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	if pb.closed {
+		return 0, io.ErrClosedPipe
+	}
+	pb.cond.Broadcast()
+	return pb.buf.Write(b)
+}
+
+func (pb *pipeBuffer) close() {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.closed = true
+	pb.cond.Broadcast()
+}
+
+// pipeEnd is one endpoint of an in-memory buffered pipe. Writes never block.
+type pipeEnd struct {
+	rd *pipeBuffer
+	wr *pipeBuffer
+}
+
+func (p *pipeEnd) Read(b []byte) (int, error) {
+	return p.rd.read(b)
+}
+
+func (p *pipeEnd) Write(b []byte) (int, error) {
+	return p.wr.write(b)
+}
+
+func (p *pipeEnd) Close() error {
+	p.rd.close()
+	p.wr.close()
+	return nil
+}
+
+// newPipe returns both endpoints of an in-memory full duplex pipe
+func newPipe() (io.ReadWriteCloser, io.ReadWriteCloser) {
+	ab := newPipeBuffer()
+	ba := newPipeBuffer()
+	return &pipeEnd{rd: ba, wr: ab}, &pipeEnd{rd: ab, wr: ba}
+}
+
+// NewModemPair creates two modems wired as a virtual null-modem: dialing any number on one
+// modem rings the other one over an in-memory pipe. Configs are optional, their TTY and
+// OutgoingCall fields are ignored. Returns both modems and the DTE side of their TTYs.
+func NewModemPair(config1, config2 *ModemConfig) (m1, m2 *Modem, tty1, tty2 io.ReadWriteCloser, err error) {
+	pairConfig := func(config *ModemConfig, id string, peer **Modem) (*Modem, io.ReadWriteCloser, error) {
+		cfg := ModemConfig{}
+		if config != nil {
+			cfg = *config
+		}
+		if cfg.Id == "" {
+			cfg.Id = id
+		}
+		dte, dce := newPipe()
+		cfg.TTY = dce
+		cfg.OutgoingCallCtx = nil
+		cfg.OutgoingCall = func(m *Modem, number string) (io.ReadWriteCloser, error) {
+			local, remote := newPipe()
+			if err := (*peer).IncomingCallSync(remote); err != nil {
+				if err == ErrModemBusy || err == ErrNoDTE || err == ErrCallRejected {
+					return nil, ErrBusy
+				}
+				return nil, err
+			}
+			return local, nil
+		}
+		m, err := NewModem(&cfg)
+		if err != nil {
+			dte.Close()
+			return nil, nil, err
+		}
+		return m, dte, nil
+	}
+	m1, tty1, err = pairConfig(config1, "modem1", &m2)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	m2, tty2, err = pairConfig(config2, "modem2", &m1)
+	if err != nil {
+		m1.CloseSync()
+		tty1.Close()
+		return nil, nil, nil, nil, err
+	}
+	return m1, m2, tty1, tty2, nil
+}
@@ -0,0 +1,235 @@
+package vmodem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command line grammar (the line after the AT prefix):
+//
+//	line     = { basic | extended [ ";" ] } [ dial ]
+//	basic    = [ "&" | "%" | "\" ] letter digits [ "?" | "=" value [ "?" ] ]
+//	extended = ( "+" | "#" ) letter { namechar } [ "?" | "=" [ params ] [ "?" ] ]
+//	dial     = "D" { any }
+//	params   = param { "," param }
+//	param    = { char | quoted }
+//	quoted   = '"' { char | "\" hexdigit hexdigit } '"'
+//
+// Basic values are digits, except &Z taking a dial string up to "?" or ";". Extended commands
+// end at ";" or the end of the line (AT+CMD1;+CMD2). Dial strings take the rest of the line,
+// their trailing ";" included. Names are upper-cased, values keep their case.
+
+// ParseError is a malformed command line
+type ParseError struct {
+	Pos int    // Offset of the offending character in the command line (after AT)
+	Msg string // Cause of the error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("syntax error at %d: %s", e.Pos, e.Msg)
+}
+
+// cmdParser parses a command line
+type cmdParser struct {
+	line string
+	pos  int
+}
+
+// ParseCommandLine parses an AT command line (without the AT prefix) into its commands.
+// Returns a *ParseError locating the first malformed command.
+func ParseCommandLine(line string) ([]*CommandParams, error) {
+	p := &cmdParser{line: line}
+	cmds := []*CommandParams{}
+	for !p.eof() {
+		cmd, err := p.command()
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+		if !p.accept(';') && isExtendedName(cmd.Name) && !p.eof() { // ";" is also allowed after basic commands
+			return nil, p.errorf(p.pos, "unexpected %q after %s", p.peek(), cmd.Name)
+		}
+	}
+	return cmds, nil
+}
+
+func (p *cmdParser) eof() bool {
+	return p.pos >= len(p.line)
+}
+
+// peek returns the next character (0 at the end of the line)
+func (p *cmdParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.line[p.pos]
+}
+
+// accept consumes the next character when it is c
+func (p *cmdParser) accept(c byte) bool {
+	if p.peek() != c || p.eof() {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+// digits consumes a run of decimal digits
+func (p *cmdParser) digits() string {
+	start := p.pos
+	for !p.eof() && checkValidNumChar(p.line[p.pos]) {
+		p.pos++
+	}
+	return p.line[start:p.pos]
+}
+
+func (p *cmdParser) errorf(pos int, format string, args ...any) *ParseError {
+	return &ParseError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// command parses the command at the current position
+func (p *cmdParser) command() (*CommandParams, error) {
+	start := p.pos
+	b := p.line[p.pos]
+	p.pos++
+	switch {
+	case b == '+' || b == '#':
+		return p.extended(start, b)
+	case b == '&' || b == '%' || b == '\\':
+		if !checkValidCmdChar(p.peek()) {
+			return nil, p.errorf(p.pos, "command letter expected after %q", b)
+		}
+		p.pos++
+		return p.basic(start, strings.ToUpper(p.line[start:p.pos]))
+	case b == 'D' || b == 'd':
+		cmd := &CommandParams{Name: "D", Assign: true, Value: p.line[p.pos:], Pos: start}
+		p.pos = len(p.line)
+		return cmd, nil
+	case checkValidCmdChar(b):
+		return p.basic(start, strings.ToUpper(string(b)))
+	}
+	return nil, p.errorf(start, "unexpected %q", b)
+}
+
+// basic parses the number, query and value of a basic command
+func (p *cmdParser) basic(start int, name string) (*CommandParams, error) {
+	cmd := &CommandParams{Name: name, Num: p.digits(), Pos: start}
+	if p.accept('?') {
+		cmd.Query = true
+		return cmd, nil
+	}
+	if !p.accept('=') {
+		return cmd, nil
+	}
+	cmd.Assign = true
+	if name == "&Z" { // stored dial string
+		end := strings.IndexAny(p.line[p.pos:], "?;")
+		if end < 0 {
+			end = len(p.line) - p.pos
+		}
+		cmd.Value = p.line[p.pos : p.pos+end]
+		p.pos += end
+	} else {
+		cmd.Value = p.digits()
+	}
+	if cmd.Value != "" {
+		cmd.Args = []string{cmd.Value}
+	}
+	cmd.Query = p.accept('?')
+	cmd.Test = cmd.Query && cmd.Value == ""
+	return cmd, nil
+}
+
+// isNameChar reports whether c can follow the first letter of an extended command name (V.250 5.4.1)
+func isNameChar(c byte) bool {
+	return checkValidCmdChar(c) || checkValidNumChar(c) || strings.IndexByte("!%-./:_", c) >= 0
+}
+
+func isExtendedName(name string) bool {
+	return name != "" && (name[0] == '+' || name[0] == '#')
+}
+
+// extended parses an extended command, prefix included
+func (p *cmdParser) extended(start int, prefix byte) (*CommandParams, error) {
+	if !checkValidCmdChar(p.peek()) {
+		return nil, p.errorf(p.pos, "command name expected after %q", prefix)
+	}
+	for !p.eof() && isNameChar(p.line[p.pos]) {
+		p.pos++
+	}
+	cmd := &CommandParams{Name: strings.ToUpper(p.line[start:p.pos]), Pos: start}
+	if p.accept('?') {
+		cmd.Query = true
+		return cmd, nil
+	}
+	if !p.accept('=') {
+		if !p.eof() && p.peek() != ';' {
+			return nil, p.errorf(p.pos, "unexpected %q in command name", p.peek())
+		}
+		return cmd, nil
+	}
+	cmd.Assign = true
+	if err := p.params(cmd); err != nil {
+		return nil, err
+	}
+	cmd.Test = cmd.Query && cmd.Value == ""
+	return cmd, nil
+}
+
+// params parses the parameter list of an extended command up to the end of the line, an
+// unquoted "?" or an unquoted ";" (left for the next command). Quoted strings may contain
+// commas, semicolons and \hh hex escapes.
+func (p *cmdParser) params(cmd *CommandParams) error {
+	start := p.pos
+	end := -1
+	args := []string{}
+	arg := strings.Builder{}
+	quote := -1 // Position of the opening quote of the string being parsed
+	for !p.eof() && end < 0 {
+		c := p.line[p.pos]
+		p.pos++
+		switch {
+		case c == '"':
+			if quote < 0 {
+				quote = p.pos - 1
+			} else {
+				quote = -1
+			}
+		case quote >= 0 && c == '\\':
+			if p.pos+2 > len(p.line) {
+				return p.errorf(p.pos-1, "incomplete escape")
+			}
+			v, err := strconv.ParseUint(p.line[p.pos:p.pos+2], 16, 8)
+			if err != nil {
+				return p.errorf(p.pos-1, "bad escape %q", p.line[p.pos-1:p.pos+2])
+			}
+			arg.WriteByte(byte(v))
+			p.pos += 2
+		case quote >= 0:
+			arg.WriteByte(c)
+		case c == '?':
+			end = p.pos - 1
+			cmd.Query = true
+		case c == ';':
+			p.pos--
+			end = p.pos
+		case c == ',':
+			args = append(args, arg.String())
+			arg.Reset()
+		default:
+			arg.WriteByte(c)
+		}
+	}
+	if quote >= 0 {
+		return p.errorf(quote, "unterminated string")
+	}
+	if end < 0 {
+		end = p.pos
+	}
+	cmd.Value = p.line[start:end]
+	if cmd.Value != "" {
+		cmd.Args = append(args, arg.String())
+	}
+	return nil
+}
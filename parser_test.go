@@ -0,0 +1,135 @@
+package vmodem
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		names []string
+		pos   []int
+	}{
+		{"", []string{}, []int{}},
+		{"E0V1", []string{"E", "V"}, []int{0, 2}},
+		{"&FS0=2", []string{"&F", "S"}, []int{0, 2}},
+		{"E0;%C1", []string{"E", "%C"}, []int{0, 3}},
+		{`\N3&K0`, []string{`\N`, "&K"}, []int{0, 3}},
+		{"+CMD1;+cmd2=1,2", []string{"+CMD1", "+CMD2"}, []int{0, 6}},
+		{`+CMD="a;b",1;E0`, []string{"+CMD", "E"}, []int{0, 13}},
+		{"&Z1=555,123;E1", []string{"&Z", "E"}, []int{0, 12}},
+		{"E0DT555;", []string{"E", "D"}, []int{0, 2}},
+	}
+	for _, tt := range tests {
+		cmds, err := ParseCommandLine(tt.line)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.line, err)
+			continue
+		}
+		names, pos := []string{}, []int{}
+		for _, cmd := range cmds {
+			names = append(names, cmd.Name)
+			pos = append(pos, cmd.Pos)
+		}
+		if !slices.Equal(names, tt.names) || !slices.Equal(pos, tt.pos) {
+			t.Errorf("%q: got %v at %v, want %v at %v", tt.line, names, pos, tt.names, tt.pos)
+		}
+	}
+}
+
+func TestParseCommandLineValues(t *testing.T) {
+	tests := []struct {
+		line  string
+		value string
+		args  []string
+		query bool
+		test  bool
+	}{
+		{"S7=45", "45", []string{"45"}, false, false},
+		{"S7?", "", nil, true, false},
+		{"+IPR=?", "", nil, true, true},
+		{"+IPR=9600", "9600", []string{"9600"}, false, false},
+		{`+CMD="a,b",,3`, `"a,b",,3`, []string{"a,b", "", "3"}, false, false},
+		{`+CMD="\41\62"`, `"\41\62"`, []string{"Ab"}, false, false},
+		{"&Z0=555-1234", "555-1234", []string{"555-1234"}, false, false},
+		{"DT555;", "T555;", nil, false, false},
+	}
+	for _, tt := range tests {
+		cmds, err := ParseCommandLine(tt.line)
+		if err != nil || len(cmds) != 1 {
+			t.Errorf("%q: got %d commands, error %v", tt.line, len(cmds), err)
+			continue
+		}
+		cmd := cmds[0]
+		if cmd.Value != tt.value || !slices.Equal(cmd.Args, tt.args) || cmd.Query != tt.query || cmd.Test != tt.test {
+			t.Errorf("%q: got value %q args %q query %v test %v", tt.line, cmd.Value, cmd.Args, cmd.Query, cmd.Test)
+		}
+	}
+}
+
+func TestParseCommandLineErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		pos  int
+	}{
+		{"&", 1},
+		{"E0%", 3},
+		{"+", 1},
+		{"#1", 1},
+		{"E0*", 2},
+		{"E1 V1", 2},
+		{"+CMD*", 4},
+		{"+CMD?E0", 5},
+		{"+CMD=1?V1", 7},
+		{`+CMD="abc`, 5},
+		{`E0+CMD=1,"x`, 9},
+		{`+CMD="\4`, 6},
+		{`+CMD="\4g"`, 6},
+	}
+	for _, tt := range tests {
+		_, err := ParseCommandLine(tt.line)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%q: got %v, want a *ParseError", tt.line, err)
+			continue
+		}
+		if perr.Pos != tt.pos {
+			t.Errorf("%q: error at %d (%s), want %d", tt.line, perr.Pos, perr.Msg, tt.pos)
+		}
+	}
+}
+
+func FuzzParseCommandLine(f *testing.F) {
+	for _, seed := range []string{
+		"", "Z", "E0V1Q0", "&F&C1&D2", "S0=1S7?", "S7=?", "%C1\\N3", "+IPR=115200;+ICF=3,3",
+		"+IFC=?", "+CMD?", `+CMD="a;b",1;E0`, `+CMD="\41"`, "&Z0=555,1234?", "DT555-1234;",
+		"DS=1", "E0;V1;", "&", "+", "+CMD*", `+CMD="abc`, `+CMD="\4`, "E1 V1",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		cmds, err := ParseCommandLine(line)
+		if err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("%q: error %v is not a *ParseError", line, err)
+			}
+			if perr.Pos < 0 || perr.Pos > len(line) {
+				t.Fatalf("%q: error position %d out of the line", line, perr.Pos)
+			}
+			return
+		}
+		last := -1
+		for _, cmd := range cmds {
+			if cmd.Pos <= last || cmd.Pos >= len(line) {
+				t.Fatalf("%q: command %s at %d after %d", line, cmd.Name, cmd.Pos, last)
+			}
+			if cmd.Name == "" || cmd.Test && !cmd.Query {
+				t.Fatalf("%q: bad command %+v", line, cmd)
+			}
+			last = cmd.Pos
+		}
+	})
+}
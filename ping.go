@@ -0,0 +1,51 @@
+package vmodem
+
+import (
+	"context"
+	"errors"
+)
+
+// pingTask pings the connection every PingInterval while online and closes it when a ping
+// is not answered within PingTimeout, so the call ends with NO CARRIER as on carrier loss
+// instead of hanging on a half-open connection.
+func (m *Modem) pingTask(ctx context.Context, p Pinger) {
+	timeout := m.pingTimeout
+	if timeout <= 0 {
+		timeout = m.pingInterval
+	}
+	for clockSleep(ctx, m.clock, m.pingInterval) {
+		pingCtx, cancel := clockTimeout(ctx, m.clock, timeout)
+		err := p.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil || errors.Is(err, ErrPingNotSupported) {
+			return
+		}
+		if err != nil {
+			m.Lock()
+			if ctx.Err() == nil {
+				m.log.Info("ping failed", "err", err)
+				m.conn.Close()
+			}
+			m.Unlock()
+			return
+		}
+	}
+}
+
+// PingSync checks that the remote side of the current call is alive. Returns ErrNoCarrier
+// without call and ErrPingNotSupported when the connection can't ping.
+// Modem lock is acquired and released, it is not held while waiting for the reply.
+func (m *Modem) PingSync(ctx context.Context) error {
+	m.Lock()
+	conn := m.conn
+	st := m.status()
+	m.Unlock()
+	if conn == nil || (st != StatusConnected && st != StatusConnectedCmd) {
+		return ErrNoCarrier
+	}
+	p, ok := conn.(Pinger)
+	if !ok {
+		return ErrPingNotSupported
+	}
+	return p.Ping(ctx)
+}
@@ -0,0 +1,135 @@
+package vmodem
+
+import (
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// PoolStrategy returns the order in which the modems of a pool are offered an incoming call.
+// lastCall holds the time each modem last got a call (zero = never) and last is the index
+// of the modem that got the last call (-1 = none).
+type PoolStrategy func(lastCall []time.Time, last int) []int
+
+// PoolFirstFree offers calls to the modems in order, the first idle one gets the call
+func PoolFirstFree(lastCall []time.Time, last int) []int {
+	order := make([]int, len(lastCall))
+	for i := range order {
+		order[i] = i
+	}
+	return order
+}
+
+// PoolRoundRobin offers calls starting with the modem after the one that got the last call
+func PoolRoundRobin(lastCall []time.Time, last int) []int {
+	order := make([]int, len(lastCall))
+	for i := range order {
+		order[i] = (last + 1 + i) % len(lastCall)
+	}
+	return order
+}
+
+// PoolLeastRecent offers calls to the modem that got a call least recently first
+func PoolLeastRecent(lastCall []time.Time, last int) []int {
+	order := PoolFirstFree(lastCall, last)
+	slices.SortStableFunc(order, func(a, b int) int {
+		return lastCall[a].Compare(lastCall[b])
+	})
+	return order
+}
+
+// ModemPool is a hunting group delivering incoming calls to the first idle modem
+// chosen by its strategy
+type ModemPool struct {
+	mu       sync.Mutex
+	modems   []*Modem
+	strategy PoolStrategy
+	lastCall []time.Time
+	last     int
+	busyMsg  string
+}
+
+// NewModemPool creates a pool of modems. A nil strategy is PoolFirstFree.
+func NewModemPool(strategy PoolStrategy, modems ...*Modem) *ModemPool {
+	if strategy == nil {
+		strategy = PoolFirstFree
+	}
+	return &ModemPool{
+		modems:   slices.Clone(modems),
+		strategy: strategy,
+		lastCall: make([]time.Time, len(modems)),
+		last:     -1,
+	}
+}
+
+// Add appends a modem to the pool
+func (p *ModemPool) Add(m *Modem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.modems = append(p.modems, m)
+	p.lastCall = append(p.lastCall, time.Time{})
+}
+
+// Set replaces the modem number i of the pool (e.g. with a recreated one)
+func (p *ModemPool) Set(i int, m *Modem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.modems[i] = m
+	p.lastCall[i] = time.Time{}
+}
+
+// Modems returns the modems of the pool
+func (p *ModemPool) Modems() []*Modem {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.modems)
+}
+
+// SetBusyMessage sets the message (e.g. "BUSY\r\n") written to incoming calls rejected because
+// all modems are busy before closing them. With no message ("") rejected calls are left open.
+func (p *ModemPool) SetBusyMessage(msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.busyMsg = msg
+}
+
+// IncomingCall rings the modem chosen by the pool strategy among the idle ones and returns it.
+// Returns ErrModemBusy when all modems are busy (or refuse calls without DTE), writing the busy
+// message to conn and closing it when one is set, and ErrCallRejected when the IncomingCallFilter
+// of a modem rejects the call, leaving conn to the caller.
+func (p *ModemPool) IncomingCall(conn io.ReadWriteCloser) (*Modem, error) {
+	return p.incomingCall(conn, nil)
+}
+
+// IncomingCallWithInfo is like IncomingCall with caller ID information
+func (p *ModemPool) IncomingCallWithInfo(conn io.ReadWriteCloser, info CallInfo) (*Modem, error) {
+	return p.incomingCall(conn, &info)
+}
+
+func (p *ModemPool) incomingCall(conn io.ReadWriteCloser, info *CallInfo) (*Modem, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, i := range p.strategy(slices.Clone(p.lastCall), p.last) {
+		m := p.modems[i]
+		var err error
+		if info != nil {
+			err = m.IncomingCallWithInfoSync(conn, *info)
+		} else {
+			err = m.IncomingCallSync(conn)
+		}
+		if err == nil {
+			p.lastCall[i] = time.Now()
+			p.last = i
+			return m, nil
+		}
+		if err == ErrCallRejected { // the caller is refused, not the modem
+			return nil, err
+		}
+	}
+	if p.busyMsg != "" {
+		conn.Write([]byte(p.busyMsg))
+		conn.Close()
+	}
+	return nil, ErrModemBusy
+}
@@ -0,0 +1,331 @@
+package vmodem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+var ErrProfileNotFound = errors.New("profile not found")
+
+// Profile holds the user settings saved with AT&W
+type Profile struct {
+	Echo          bool              `json:"echo"`
+	Quiet         bool              `json:"quiet"`
+	QuietAnswer   bool              `json:"quietAnswer"`
+	Verbose       bool              `json:"verbose"`
+	XLevel        int               `json:"xLevel"`
+	BulkMode      bool              `json:"bulkMode"`
+	Telnet        bool              `json:"telnet"`
+	CallerId      int               `json:"callerId"`
+	SRegs         map[byte]byte     `json:"sRegs"`
+	PortRate      int               `json:"portRate"`
+	IcfFormat     int               `json:"icfFormat"`
+	IcfParity     int               `json:"icfParity"`
+	IfcDceByDte   int               `json:"ifcDceByDte"`
+	IfcDteByDce   int               `json:"ifcDteByDce"`
+	Settings      map[string]string `json:"settings,omitempty"`       // Custom settings (see SetSetting)
+	PowerOn       int               `json:"powerOnProfile,omitempty"` // Profile loaded on start (AT&Y), kept in profile 0
+	DTRMode       *int              `json:"dtrMode,omitempty"`        // DTR drop handling (AT&D, nil = factory value)
+	DCDMode       *int              `json:"dcdMode,omitempty"`        // DCD line mode (AT&C, nil = factory value)
+	Protocol      *bool             `json:"protocol,omitempty"`       // Protocol suffixes of CONNECT (AT\V, nil = factory value)
+	RDL           *bool             `json:"rdl,omitempty"`            // Remote digital loopback grant (AT&T4/AT&T5, nil = factory value)
+	StoredNumbers []string          `json:"storedNumbers,omitempty"`  // Numbers stored with AT&Z, kept in profile 0
+}
+
+// profileSlots is the number of stored profiles (AT&W0, AT&W1)
+const profileSlots = 2
+
+// profileKey returns the store key of the profile slot n of a modem
+func profileKey(id string, n int) string {
+	if n == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s.%d", id, n)
+}
+
+// ProfileStore persists modem profiles. LoadProfile must return ErrProfileNotFound
+// when there is no stored profile for the modem id.
+type ProfileStore interface {
+	LoadProfile(id string) (*Profile, error)
+	SaveProfile(id string, p *Profile) error
+}
+
+func (m *Modem) profile() *Profile {
+	dtrMode, dcdMode, protocol, rdl := m.dtrMode, m.dcdMode, m.protocolResults, m.rdlGrant.Load()
+	return &Profile{
+		Echo:        m.echo,
+		Quiet:       m.quietMode,
+		QuietAnswer: m.quietAnswer,
+		Verbose:     !m.shortForm,
+		XLevel:      m.xLevel,
+		BulkMode:    m.bulkMode,
+		Telnet:      m.telnet,
+		CallerId:    m.callerIdMode,
+		SRegs:       maps.Clone(m.sregs),
+		PortRate:    m.portRate,
+		IcfFormat:   m.icfFormat,
+		IcfParity:   m.icfParity,
+		IfcDceByDte: m.ifcDceByDte,
+		IfcDteByDce: m.ifcDteByDce,
+		Settings:    maps.Clone(m.settings),
+		DTRMode:     &dtrMode,
+		DCDMode:     &dcdMode,
+		Protocol:    &protocol,
+		RDL:         &rdl,
+	}
+}
+
+func (m *Modem) applyProfile(p *Profile) {
+	m.echo = p.Echo
+	m.quietMode = p.Quiet
+	m.quietAnswer = p.QuietAnswer
+	m.shortForm = !p.Verbose
+	m.xLevel = p.XLevel
+	m.setBulkMode(p.BulkMode)
+	m.telnet = p.Telnet
+	m.callerIdMode = p.CallerId
+	for k, v := range p.SRegs {
+		m.sregs[k] = v
+	}
+	m.portRate = p.PortRate
+	m.icfFormat = p.IcfFormat
+	m.icfParity = p.IcfParity
+	m.ifcDceByDte = p.IfcDceByDte
+	m.ifcDteByDce = p.IfcDteByDce
+	m.settings = maps.Clone(p.Settings)
+	if p.DTRMode != nil {
+		m.dtrMode = *p.DTRMode
+	}
+	if p.DCDMode != nil {
+		m.dcdMode = *p.DCDMode
+		m.updateDCD()
+	}
+	if p.Protocol != nil {
+		m.protocolResults = *p.Protocol
+	}
+	if p.RDL != nil {
+		m.rdlGrant.Store(*p.RDL)
+	}
+}
+
+// factoryReset restores the factory settings (AT&F)
+func (m *Modem) factoryReset() {
+	m.resetSRegs()
+	m.resetDteInterface()
+	m.xLevel = 4
+	m.setBulkMode(m.defaultBulkMode)
+	m.telnet = m.defaultTelnet
+	m.callerIdMode = 0
+	m.dtrMode = 2
+	m.dcdMode = 1
+	m.updateDCD()
+	m.echo = true
+	m.shortForm = false
+	m.quietMode = false
+	m.quietAnswer = false
+	m.protocolResults = true
+	m.rdlGrant.Store(m.defaultRDL)
+	m.settings = nil
+}
+
+// factoryProfile returns the profile of the factory settings without applying them
+func (m *Modem) factoryProfile() *Profile {
+	f := &Modem{
+		sregs:           map[byte]byte{},
+		autoAnswerRings: m.autoAnswerRings,
+		escapeChar:      m.escapeChar,
+		idleTimeout:     m.idleTimeout,
+		defaultBulkMode: m.defaultBulkMode,
+		defaultTelnet:   m.defaultTelnet,
+		defaultRDL:      m.defaultRDL,
+	}
+	f.factoryReset()
+	return f.profile()
+}
+
+// loadProfile restores the stored profile n (ATZn). Returns false if there is no stored profile.
+func (m *Modem) loadProfile(n int) bool {
+	if n < 0 || n >= profileSlots {
+		return false
+	}
+	p, err := m.profileStore.LoadProfile(profileKey(m.id, n))
+	if err != nil {
+		return false
+	}
+	m.applyProfile(p)
+	return true
+}
+
+// loadPowerOnProfile restores the profile selected with AT&Y
+func (m *Modem) loadPowerOnProfile() {
+	p, err := m.profileStore.LoadProfile(profileKey(m.id, 0))
+	if err != nil {
+		return
+	}
+	m.powerOnProfile = p.PowerOn
+	m.storedNumbers = p.StoredNumbers
+	if m.powerOnProfile == 0 {
+		m.applyProfile(p)
+		return
+	}
+	m.loadProfile(m.powerOnProfile)
+}
+
+// saveProfile stores the active profile in slot n (AT&Wn)
+func (m *Modem) saveProfile(n int) error {
+	if n < 0 || n >= profileSlots {
+		return ErrProfileNotFound
+	}
+	p := m.profile()
+	if n == 0 {
+		p.PowerOn = m.powerOnProfile
+		p.StoredNumbers = slices.Clone(m.storedNumbers)
+	}
+	return m.profileStore.SaveProfile(profileKey(m.id, n), p)
+}
+
+// setPowerOnProfile selects the profile loaded on start (AT&Yn), stored along profile 0.
+// Profile 0 holds the factory settings when it was not saved with AT&W0.
+func (m *Modem) setPowerOnProfile(n int) error {
+	if n < 0 || n >= profileSlots {
+		return ErrProfileNotFound
+	}
+	p, err := m.profileStore.LoadProfile(profileKey(m.id, 0))
+	if errors.Is(err, ErrProfileNotFound) {
+		p = m.factoryProfile()
+		p.StoredNumbers = slices.Clone(m.storedNumbers)
+	} else if err != nil {
+		return err
+	}
+	p.PowerOn = n
+	if err := m.profileStore.SaveProfile(profileKey(m.id, 0), p); err != nil {
+		return err
+	}
+	m.powerOnProfile = n
+	return nil
+}
+
+// Profile returns a copy of the active profile. Modem lock must be held.
+func (m *Modem) Profile() *Profile {
+	m.checkLock()
+	return m.profile()
+}
+
+// ProfileSync returns a copy of the active profile. Modem lock is acquired and released.
+func (m *Modem) ProfileSync() *Profile {
+	m.Lock()
+	defer m.Unlock()
+	return m.profile()
+}
+
+// Setting returns a custom setting saved with the profile ("" if not set). Modem lock must be held.
+func (m *Modem) Setting(key string) string {
+	m.checkLock()
+	return m.settings[key]
+}
+
+// SettingSync returns a custom setting saved with the profile. Modem lock is acquired and released.
+func (m *Modem) SettingSync(key string) string {
+	m.Lock()
+	defer m.Unlock()
+	return m.settings[key]
+}
+
+// SetSetting sets a custom setting (e.g. from a registered command). Custom settings are saved
+// with AT&W, restored with ATZ and cleared by AT&F. Modem lock must be held.
+func (m *Modem) SetSetting(key, value string) {
+	m.checkLock()
+	m.setSetting(key, value)
+}
+
+// SetSettingSync sets a custom setting. Modem lock is acquired and released.
+func (m *Modem) SetSettingSync(key, value string) {
+	m.Lock()
+	defer m.Unlock()
+	m.setSetting(key, value)
+}
+
+func (m *Modem) setSetting(key, value string) {
+	if m.settings == nil {
+		m.settings = map[string]string{}
+	}
+	m.settings[key] = value
+}
+
+// MemoryProfileStore keeps profiles in memory (lost when the process exits)
+type MemoryProfileStore struct {
+	mu       sync.Mutex
+	profiles map[string]*Profile
+}
+
+// LoadProfile implements ProfileStore.
+func (s *MemoryProfileStore) LoadProfile(id string) (*Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.profiles[id]
+	if !ok {
+		return nil, ErrProfileNotFound
+	}
+	cp := *p
+	cp.SRegs = maps.Clone(p.SRegs)
+	cp.Settings = maps.Clone(p.Settings)
+	return &cp, nil
+}
+
+// SaveProfile implements ProfileStore.
+func (s *MemoryProfileStore) SaveProfile(id string, p *Profile) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.profiles == nil {
+		s.profiles = map[string]*Profile{}
+	}
+	cp := *p
+	cp.SRegs = maps.Clone(p.SRegs)
+	cp.Settings = maps.Clone(p.Settings)
+	s.profiles[id] = &cp
+	return nil
+}
+
+// FileProfileStore keeps each profile in a JSON file under a directory
+type FileProfileStore struct {
+	Dir string
+}
+
+func (s *FileProfileStore) path(id string) string {
+	return filepath.Join(s.Dir, id+".profile")
+}
+
+// LoadProfile implements ProfileStore.
+func (s *FileProfileStore) LoadProfile(id string) (*Profile, error) {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrProfileNotFound
+		}
+		return nil, err
+	}
+	p := &Profile{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SaveProfile implements ProfileStore.
+func (s *FileProfileStore) SaveProfile(id string, p *Profile) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path(id) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(id))
+}
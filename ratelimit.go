@@ -0,0 +1,74 @@
+package vmodem
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+// rateLimiter is a token bucket limiter
+type rateLimiter struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	clock  Clock
+}
+
+func newRateLimiter(rate float64, clock Clock) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := max(rate, 1)
+	return &rateLimiter{rate: rate, burst: burst, tokens: burst, last: clock.Now(), clock: clock}
+}
+
+// reserve consumes n tokens and returns how long the caller must wait for them
+func (r *rateLimiter) reserve(n int) time.Duration {
+	now := r.clock.Now()
+	r.tokens = min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	r.last = now
+	r.tokens -= float64(n)
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens / r.rate * float64(time.Second))
+}
+
+// throttle waits (releasing the modem lock) until the limiter allows n more units. Returns
+// false if the modem status changed while waiting, the caller must then abandon the data
+// throttled. Modem lock must be held.
+func (m *Modem) throttle(l *rateLimiter, n int) bool {
+	if l == nil {
+		return true
+	}
+	wait := l.reserve(n)
+	if wait <= 0 {
+		m.throttling = false
+		return true
+	}
+	m.metrics.throttledEvents.Add(1)
+	if !m.throttling {
+		m.throttling = true
+		m.reportError(ErrRateLimited)
+	}
+	stCtx := m.stCtx
+	m.Unlock()
+	<-m.clock.After(wait)
+	m.Lock()
+	return m.stCtx == stCtx
+}
+
+// echoWrite echoes data to the TTY when echo is enabled, honoring the echo rate limit.
+// Returns false if the modem status changed while throttled, nothing is echoed then.
+func (m *Modem) echoWrite(b []byte) bool {
+	if !m.echo {
+		return true
+	}
+	if !m.throttle(m.echoLimiter, len(b)) {
+		return false
+	}
+	m.ttyWrite(b)
+	return true
+}
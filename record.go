@@ -0,0 +1,154 @@
+package vmodem
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Session recordings start with recordMagic followed by frames made of a header
+// (Unix time in nanoseconds int64, TapDirection byte, data length uint32, big endian)
+// and the data.
+const recordMagic = "VMREC1\n"
+
+const recordHeaderLen = 8 + 1 + 4
+
+// RecordFrame is a chunk of call traffic read from a session recording
+type RecordFrame struct {
+	Time time.Time
+	Dir  TapDirection // TapRx (remote to DTE) or TapTx (DTE to remote)
+	Data []byte
+}
+
+type sessionRecorder struct {
+	f     *os.File
+	w     *bufio.Writer
+	clock Clock
+}
+
+// startRecording opens the recording of the call that just began. Called with the modem lock held.
+func (m *Modem) startRecording(rec *CallRecord) {
+	if m.recordDir == "" {
+		return
+	}
+	dir := "out"
+	if rec.Direction == CallIncoming {
+		dir = "in"
+	}
+	name := fmt.Sprintf("%s-%s-%s.vmrec", m.id, rec.Start.Format("20060102-150405.000"), dir)
+	f, err := os.Create(filepath.Join(m.recordDir, name))
+	if err != nil {
+		m.reportError(err)
+		return
+	}
+	r := &sessionRecorder{f: f, w: bufio.NewWriter(f), clock: m.clock}
+	r.w.WriteString(recordMagic)
+	m.tapMu.Lock()
+	defer m.tapMu.Unlock()
+	m.recorder = r
+}
+
+// stopRecording flushes and closes the recording of the call that just ended
+func (m *Modem) stopRecording() {
+	m.tapMu.Lock()
+	r := m.recorder
+	m.recorder = nil
+	m.tapMu.Unlock()
+	if r == nil {
+		return
+	}
+	err := r.w.Flush()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		m.reportError(err)
+	}
+}
+
+// record appends a frame to the recording. Called with tapMu held.
+func (r *sessionRecorder) record(dir TapDirection, data []byte) {
+	var hdr [recordHeaderLen]byte
+	binary.BigEndian.PutUint64(hdr[0:], uint64(r.clock.Now().UnixNano()))
+	hdr[8] = byte(dir)
+	binary.BigEndian.PutUint32(hdr[9:], uint32(len(data)))
+	r.w.Write(hdr[:])
+	r.w.Write(data)
+}
+
+// RecordReader reads the frames of a session recording
+type RecordReader struct {
+	r *bufio.Reader
+}
+
+// NewRecordReader checks the recording header and returns a reader of its frames.
+// Returns ErrInvalidRecording when r is not a session recording.
+func NewRecordReader(r io.Reader) (*RecordReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(recordMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != recordMagic {
+		return nil, ErrInvalidRecording
+	}
+	return &RecordReader{r: br}, nil
+}
+
+// Next returns the next frame of the recording, io.EOF at the end of the recording
+// and io.ErrUnexpectedEOF when it is truncated.
+func (rr *RecordReader) Next() (RecordFrame, error) {
+	var hdr [recordHeaderLen]byte
+	if _, err := io.ReadFull(rr.r, hdr[:]); err != nil {
+		return RecordFrame{}, err
+	}
+	frame := RecordFrame{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:]))),
+		Dir:  TapDirection(hdr[8]),
+		Data: make([]byte, binary.BigEndian.Uint32(hdr[9:])),
+	}
+	if _, err := io.ReadFull(rr.r, frame.Data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return RecordFrame{}, err
+	}
+	return frame, nil
+}
+
+// ReplayRecording writes to w the data of the recording frames in the given direction,
+// reproducing the original timing between frames when realtime is set (e.g. to feed
+// a captured session to a modem TTY or connection in tests).
+func ReplayRecording(ctx context.Context, r io.Reader, dir TapDirection, w io.Writer, realtime bool) error {
+	return ReplayRecordingWithClock(ctx, r, dir, w, realtime, systemClock{})
+}
+
+// ReplayRecordingWithClock is like ReplayRecording measuring the realtime delays on clock
+func ReplayRecordingWithClock(ctx context.Context, r io.Reader, dir TapDirection, w io.Writer, realtime bool, clock Clock) error {
+	rr, err := NewRecordReader(r)
+	if err != nil {
+		return err
+	}
+	var last time.Time
+	for {
+		frame, err := rr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if frame.Dir&dir == 0 {
+			continue
+		}
+		if realtime && !last.IsZero() && !clockSleep(ctx, clock, frame.Time.Sub(last)) {
+			return ctx.Err()
+		}
+		last = frame.Time
+		if _, err := w.Write(frame.Data); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package vmodem
+
+import (
+	"context"
+	"time"
+)
+
+// RedialPolicy redials the last number automatically, for unattended links that must
+// stay connected. Any command line from the DTE or status change cancels a pending redial.
+type RedialPolicy struct {
+	Attempts      int           // Redials after a failed dial (0 = none)
+	Delay         time.Duration // Wait before the first redial
+	MaxDelay      time.Duration // The delay doubles on every attempt up to MaxDelay (0 = constant delay)
+	OnCarrierLoss bool          // Also redial when an outgoing call loses carrier
+}
+
+// startDial enters dialing status for number, remembering it for ATDL. Modem lock must be held.
+func (m *Modem) startDial(number string) {
+	m.setStatus(StatusDialing)
+	m.dialNumber = number
+	m.lastNumber = number
+	m.startCallSpan(CallOutgoing, number, "")
+	m.emit(ModemEvent{Type: EventDial, Number: number})
+}
+
+// scheduleRedial redials the last number after the policy delay if attempts are left.
+// Modem lock must be held, called once the modem is idle.
+func (m *Modem) scheduleRedial() {
+	p := m.redial
+	if p == nil || m.redialAttempt >= p.Attempts || m.lastNumber == "" {
+		return
+	}
+	delay := p.Delay
+	for i := 0; i < m.redialAttempt && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+	m.redialAttempt++
+	m.cancelRedial()
+	var ctx context.Context
+	ctx, m.redialCancel = context.WithCancel(m.stCtx)
+	m.goTask(func() {
+		if !clockSleep(ctx, m.clock, delay) {
+			return
+		}
+		m.Lock()
+		defer m.Unlock()
+		if ctx.Err() != nil || m.status() != StatusIdle {
+			return
+		}
+		m.redialCancel = nil
+		number := m.lastNumber
+		m.log.Info("redial", "number", number, "attempt", m.redialAttempt)
+		m.startDial(number)
+		dialCtx, timers := m.stCtx, m.dialTimers()
+		m.goTask(func() { m.processDialing(dialCtx, number, timers) })
+	})
+}
+
+// cancelRedial cancels the pending redial, if any
+func (m *Modem) cancelRedial() {
+	if m.redialCancel != nil {
+		m.redialCancel()
+		m.redialCancel = nil
+	}
+}
+
+func (m *Modem) lastDialed() string {
+	return m.lastNumber
+}
+
+// LastNumber returns the last dialed number, redialed with ATDL ("" = none). Modem lock must be held.
+func (m *Modem) LastNumber() string {
+	m.checkLock()
+	return m.lastDialed()
+}
+
+// LastNumberSync returns the last dialed number, redialed with ATDL ("" = none).
+// Modem lock is acquired and released.
+func (m *Modem) LastNumberSync() string {
+	m.Lock()
+	defer m.Unlock()
+	return m.lastDialed()
+}
@@ -0,0 +1,84 @@
+package vmodem
+
+import "strings"
+
+// CommandParams holds the parsed parameters of an AT command
+type CommandParams struct {
+	// Name is the command name in upper case (e.g. "+WIFI", "#STATS", "&V")
+	Name string
+	// Num is the numeric suffix of short commands (e.g. "1" in ATE1)
+	Num string
+	// Assign is true for assignments (AT+CMD=...)
+	Assign bool
+	// Query is true for queries (AT+CMD? or test commands AT+CMD=?)
+	Query bool
+	// Test is true for test commands querying the supported values (AT+CMD=?)
+	Test bool
+	// Value is the raw assigned value, quotes included
+	Value string
+	// Args is the assigned value split by commas, quoted strings ("a,b") unquoted with their
+	// \hh hex escapes decoded
+	Args []string
+	// Pos is the offset of the command in the command line (after AT)
+	Pos int
+}
+
+// CommandHandler processes a registered command. It can write intermediate responses
+// with Respond (modem lock is held) before returning the final result code.
+// Returning RetCodeSkip falls back to the built-in command processing.
+type CommandHandler func(m *Modem, p *CommandParams) RetCode
+
+func (m *Modem) registerCommand(name string, handler CommandHandler) {
+	name = strings.ToUpper(name)
+	if handler == nil {
+		delete(m.commands, name)
+		return
+	}
+	m.commands[name] = handler
+}
+
+// RegisterCommand registers a handler for the named command (e.g. "+WIFI" or "#STATS").
+// Registered commands take precedence over built-in ones. A nil handler unregisters the command.
+// Modem lock must be held.
+func (m *Modem) RegisterCommand(name string, handler CommandHandler) {
+	m.checkLock()
+	m.registerCommand(name, handler)
+}
+
+// RegisterCommandSync registers a handler for the named command. Modem lock is acquired and released.
+func (m *Modem) RegisterCommandSync(name string, handler CommandHandler) {
+	m.Lock()
+	defer m.Unlock()
+	m.registerCommand(name, handler)
+}
+
+// UnregisterCommand removes a registered command. Modem lock must be held.
+func (m *Modem) UnregisterCommand(name string) {
+	m.checkLock()
+	m.registerCommand(name, nil)
+}
+
+// UnregisterCommandSync removes a registered command. Modem lock is acquired and released.
+func (m *Modem) UnregisterCommandSync(name string) {
+	m.Lock()
+	defer m.Unlock()
+	m.registerCommand(name, nil)
+}
+
+func (m *Modem) respond(s string) {
+	m.ttyWriteStr(m.cr() + s + m.eol())
+}
+
+// Respond writes an information response line to the TTY. Modem lock must be held.
+func (m *Modem) Respond(s string) {
+	m.checkLock()
+	m.respond(s)
+}
+
+func (m *Modem) runRegisteredCommand(p *CommandParams) (RetCode, bool) {
+	handler, ok := m.commands[p.Name]
+	if !ok {
+		return RetCodeSkip, false
+	}
+	return handler(m, p), true
+}
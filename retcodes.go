@@ -0,0 +1,66 @@
+package vmodem
+
+import (
+	"strings"
+	"sync"
+)
+
+// retCodeInfo is the verbose text and numeric short form (ATV0) of a registered result code
+type retCodeInfo struct {
+	text string
+	code int
+}
+
+// retCodeBase is the first RetCode given to registered result codes
+const retCodeBase RetCode = 100
+
+var (
+	retCodesMu sync.RWMutex
+	retCodes   = map[RetCode]retCodeInfo{}
+	retCodeIds = map[string]RetCode{} // By upper-cased text
+)
+
+// RegisterRetCode registers a result code with its verbose text (e.g. "DELAYED") and numeric
+// short form sent in ATV0 mode, returning the RetCode hooks and command handlers return to
+// send it. CmdReturnFromString and RetCode.String know registered codes. Registering the same
+// text again with the same number returns the same RetCode. Returns ErrRetCodeExists when the
+// text names a built-in result code or was registered with another number.
+func RegisterRetCode(text string, code int) (RetCode, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	if text == "" || code < 0 {
+		return RetCodeUnknown, ErrInvalidValue
+	}
+	if builtinRetCode(text) != RetCodeUnknown {
+		return RetCodeUnknown, ErrRetCodeExists
+	}
+	retCodesMu.Lock()
+	defer retCodesMu.Unlock()
+	if ret, ok := retCodeIds[text]; ok {
+		if retCodes[ret].code != code {
+			return RetCodeUnknown, ErrRetCodeExists
+		}
+		return ret, nil
+	}
+	ret := retCodeBase + RetCode(len(retCodes))
+	retCodes[ret] = retCodeInfo{text: text, code: code}
+	retCodeIds[text] = ret
+	return ret, nil
+}
+
+// registeredRetCode returns the registered result code info of ret
+func registeredRetCode(ret RetCode) (retCodeInfo, bool) {
+	retCodesMu.RLock()
+	defer retCodesMu.RUnlock()
+	info, ok := retCodes[ret]
+	return info, ok
+}
+
+// lookupRetCode returns the registered result code of an upper-cased text (RetCodeUnknown if none)
+func lookupRetCode(text string) RetCode {
+	retCodesMu.RLock()
+	defer retCodesMu.RUnlock()
+	if ret, ok := retCodeIds[text]; ok {
+		return ret
+	}
+	return RetCodeUnknown
+}
@@ -0,0 +1,80 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+)
+
+// ServiceTransport answers service://name targets with a test service built into the vmodem
+// process, so terminals and TTY wiring can be checked without a remote host:
+//
+//	echo     sends back the data received
+//	chargen  sends the RFC 864 character generator pattern until the call hangs up
+//	time     sends the date and time (RFC 867 daytime) and hangs up
+//
+// It is not registered by default. DialPlan.AddServices dials the services by name (ATDT ECHO).
+type ServiceTransport struct {
+	Clock Clock // Time source of the time service (nil = system clock)
+}
+
+// serviceNames are the built-in services of ServiceTransport
+var serviceNames = []string{"echo", "chargen", "time"}
+
+// Dial implements Transport
+func (t *ServiceTransport) Dial(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	_, name := SplitTarget(target)
+	var service func(conn net.Conn)
+	switch strings.ToLower(name) {
+	case "echo":
+		service = echoService
+	case "chargen":
+		service = chargenService
+	case "time":
+		clock := t.Clock
+		if clock == nil {
+			clock = systemClock{}
+		}
+		service = func(conn net.Conn) { timeService(conn, clock) }
+	default:
+		return nil, ErrNoCarrier
+	}
+	call, conn := net.Pipe()
+	go func() {
+		defer conn.Close()
+		service(conn)
+	}()
+	return call, nil
+}
+
+// AddServices appends an entry dialing the ServiceTransport services by name (e.g. ATDT CHARGEN)
+func (d *DialPlan) AddServices() error {
+	return d.Add("^(?i)("+strings.Join(serviceNames, "|")+")$", "service://%[1]s", nil)
+}
+
+func echoService(conn net.Conn) {
+	io.Copy(conn, conn)
+}
+
+// chargenService sends lines of 72 printable characters, each line starting one character
+// after the previous one. Data received is discarded.
+func chargenService(conn net.Conn) {
+	go io.Copy(io.Discard, conn)
+	const first, count, width = ' ', 95, 72
+	line := make([]byte, width+2)
+	for start := 0; ; start = (start + 1) % count {
+		for i := range width {
+			line[i] = byte(first + (start+i)%count)
+		}
+		line[width], line[width+1] = '\r', '\n'
+		if _, err := conn.Write(line); err != nil {
+			return
+		}
+	}
+}
+
+func timeService(conn net.Conn, clock Clock) {
+	go io.Copy(io.Discard, conn)
+	conn.Write([]byte(clock.Now().Format("Monday, January 2, 2006 15:04:05-MST") + "\r\n"))
+}
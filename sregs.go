@@ -0,0 +1,163 @@
+package vmodem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SRegPolicy is the handling of the S-registers without a meaning for the modem
+type SRegPolicy int
+
+const (
+	SRegStore  SRegPolicy = iota // Undefined registers hold values like scratch registers
+	SRegIgnore                   // Writes to undefined registers are accepted and discarded, reads return 0
+	SRegReject                   // Reads and writes of undefined registers fail with ERROR
+)
+
+// sregRange is the range of values accepted by a defined S-register
+type sregRange struct {
+	min, max byte
+	readOnly bool
+}
+
+// sregRanges are the S-registers with a meaning for the modem
+var sregRanges = map[byte]sregRange{
+	0:  {0, 255, false}, // rings before answering
+	1:  {0, 255, true},  // ring count of the incoming call
+	2:  {0, 255, false}, // escape character (> 127 disables the escape sequence)
+	3:  {0, 127, false}, // command line terminator and response CR
+	4:  {0, 127, false}, // response LF
+	5:  {0, 127, false}, // backspace
+	6:  {2, 255, false}, // seconds waiting for dial tone
+	7:  {0, 255, false}, // seconds waiting for carrier
+	8:  {0, 255, false}, // seconds of pause for each comma
+	10: {1, 255, false}, // carrier loss delay in 1/10 s
+	12: {0, 255, false}, // escape guard time in 50ms increments
+	30: {0, 255, false}, // inactivity timeout in 10 s units
+}
+
+// getSReg returns the value of S-register r
+func (m *Modem) getSReg(r int) (int, error) {
+	if r < 0 || r > 255 {
+		return 0, ErrInvalidValue
+	}
+	if r == 1 {
+		return min(m.ringCount, 255), nil
+	}
+	if _, ok := sregRanges[byte(r)]; !ok {
+		switch m.sregPolicy {
+		case SRegReject:
+			return 0, ErrUndefinedSReg
+		case SRegIgnore:
+			return 0, nil
+		}
+	}
+	return int(m.sregs[byte(r)]), nil
+}
+
+// setSReg sets S-register r to v, checking the range of the defined registers
+func (m *Modem) setSReg(r, v int) error {
+	if r < 0 || r > 255 || v < 0 || v > 255 {
+		return ErrInvalidValue
+	}
+	rng, ok := sregRanges[byte(r)]
+	if !ok {
+		switch m.sregPolicy {
+		case SRegReject:
+			return ErrUndefinedSReg
+		case SRegIgnore:
+			return nil
+		}
+	} else if rng.readOnly || byte(v) < rng.min || byte(v) > rng.max {
+		return ErrInvalidValue
+	}
+	m.sregs[byte(r)] = byte(v)
+	return nil
+}
+
+// GetSReg returns the value of S-register r. Modem lock must be held.
+func (m *Modem) GetSReg(r int) (int, error) {
+	m.checkLock()
+	return m.getSReg(r)
+}
+
+// GetSRegSync returns the value of S-register r. Modem lock is acquired and released.
+func (m *Modem) GetSRegSync(r int) (int, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.getSReg(r)
+}
+
+// SetSReg sets S-register r to v like ATSr=v. Returns ErrInvalidValue when v is out of the
+// range of the register and ErrUndefinedSReg when the SRegReject policy refuses it.
+// Modem lock must be held.
+func (m *Modem) SetSReg(r, v int) error {
+	m.checkLock()
+	return m.setSReg(r, v)
+}
+
+// SetSRegSync sets S-register r to v like ATSr=v. Modem lock is acquired and released.
+func (m *Modem) SetSRegSync(r, v int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.setSReg(r, v)
+}
+
+// sregDump returns the non-zero S-registers in ascending order, six per line (ATS?)
+func (m *Modem) sregDump() string {
+	regs := []int{}
+	for r := range 256 {
+		if v, err := m.getSReg(r); err == nil && v != 0 {
+			regs = append(regs, r)
+		}
+	}
+	lines := []string{}
+	for len(regs) > 0 {
+		n := min(len(regs), 6)
+		fields := make([]string, n)
+		for i, r := range regs[:n] {
+			v, _ := m.getSReg(r)
+			fields[i] = fmt.Sprintf("S%02d:%03d", r, v)
+		}
+		lines = append(lines, strings.Join(fields, " "))
+		regs = regs[n:]
+	}
+	return strings.Join(lines, m.eol())
+}
+
+// sregCommand processes ATSr?, ATSr=v and ATS?
+func (m *Modem) sregCommand(cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode {
+	if cmdNum == "" && cmdQuery {
+		m.metrics.sregReads.Add(1)
+		if dump := m.sregDump(); dump != "" {
+			m.respond(dump)
+		}
+		return RetCodeOk
+	}
+	r, _ := strconv.Atoi(cmdNum)
+	if r < 0 || r > 255 {
+		return RetCodeError
+	}
+	if cmdAssign {
+		m.metrics.sregWrites.Add(1)
+		v, err := strconv.Atoi(cmdAssignVal)
+		if err != nil && cmdAssignVal != "" {
+			return RetCodeError
+		}
+		if m.setSReg(r, v) != nil {
+			return RetCodeError
+		}
+		return RetCodeOk
+	}
+	if cmdQuery {
+		m.metrics.sregReads.Add(1)
+		v, err := m.getSReg(r)
+		if err != nil {
+			return RetCodeError
+		}
+		m.respond(fmt.Sprintf("%03d", v))
+		return RetCodeOk
+	}
+	return RetCodeOk
+}
@@ -0,0 +1,89 @@
+package vmodem
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// storedNumberSlots is the number of numbers stored with AT&Z0..3
+const storedNumberSlots = 4
+
+// storedDialRe matches the ATDS dial strings: S, Sn or S=n
+var storedDialRe = regexp.MustCompile(`^[Ss]=?([0-9]?)$`)
+
+// resolveDialString resolves ATDL to the last dialed number and ATDS=n to the stored
+// number n. Returns false when there is no such number.
+func (m *Modem) resolveDialString(number string) (string, bool) {
+	if number == "L" || number == "l" {
+		return m.lastNumber, m.lastNumber != ""
+	}
+	if match := storedDialRe.FindStringSubmatch(number); match != nil {
+		n, _ := strconv.Atoi(match[1])
+		number = m.storedNumber(n)
+		return m.dialString(number), number != ""
+	}
+	return number, true
+}
+
+func (m *Modem) storedNumber(n int) string {
+	if n < 0 || n >= len(m.storedNumbers) {
+		return ""
+	}
+	return m.storedNumbers[n]
+}
+
+// storeNumber stores number in slot n (AT&Zn=number, "" clears it), persisted along profile 0.
+// Profile 0 holds the factory settings when it was not saved with AT&W0.
+func (m *Modem) storeNumber(n int, number string) error {
+	if n < 0 || n >= storedNumberSlots {
+		return ErrInvalidValue
+	}
+	numbers := slices.Clone(m.storedNumbers)
+	if len(numbers) <= n {
+		numbers = append(numbers, make([]string, n+1-len(numbers))...)
+	}
+	numbers[n] = strings.TrimSpace(number)
+	p, err := m.profileStore.LoadProfile(profileKey(m.id, 0))
+	if errors.Is(err, ErrProfileNotFound) {
+		p = m.factoryProfile() // profile 0 not saved with AT&W0
+		p.PowerOn = m.powerOnProfile
+	} else if err != nil {
+		return err
+	}
+	p.StoredNumbers = numbers
+	if err := m.profileStore.SaveProfile(profileKey(m.id, 0), p); err != nil {
+		return err
+	}
+	m.storedNumbers = numbers
+	return nil
+}
+
+// StoredNumbers returns the numbers stored with AT&Z, dialed with ATDS=n. Modem lock must be held.
+func (m *Modem) StoredNumbers() []string {
+	m.checkLock()
+	return slices.Clone(m.storedNumbers)
+}
+
+// StoredNumbersSync returns the numbers stored with AT&Z, dialed with ATDS=n.
+// Modem lock is acquired and released.
+func (m *Modem) StoredNumbersSync() []string {
+	m.Lock()
+	defer m.Unlock()
+	return slices.Clone(m.storedNumbers)
+}
+
+// SetStoredNumber stores number in slot n like AT&Zn=number. Modem lock must be held.
+func (m *Modem) SetStoredNumber(n int, number string) error {
+	m.checkLock()
+	return m.storeNumber(n, number)
+}
+
+// SetStoredNumberSync stores number in slot n like AT&Zn=number. Modem lock is acquired and released.
+func (m *Modem) SetStoredNumberSync(n int, number string) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.storeNumber(n, number)
+}
@@ -0,0 +1,124 @@
+package vmodem
+
+import (
+	"io"
+	"sync"
+)
+
+// TapDirection selects which side of the online traffic a tap observes
+type TapDirection int
+
+const (
+	TapRx   TapDirection = 1 << iota // Data received from the connection (conn -> tty)
+	TapTx                            // Data transmitted to the connection (tty -> conn)
+	TapBoth = TapRx | TapTx
+)
+
+// tapBufferLen is the number of pending chunks a tap holds before dropping data
+const tapBufferLen = 256
+
+type tap struct {
+	m         *Modem
+	dir       TapDirection
+	ch        chan []byte
+	buf       []byte
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Read implements io.Reader. Returns io.EOF once the tap or the modem is closed.
+func (t *tap) Read(b []byte) (int, error) {
+	if len(t.buf) == 0 {
+		select {
+		case t.buf = <-t.ch:
+		case <-t.done:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, t.buf)
+	t.buf = t.buf[n:]
+	return n, nil
+}
+
+// Close implements io.Closer. Detaches the tap from the modem.
+func (t *tap) Close() error {
+	t.m.removeTap(t)
+	return nil
+}
+
+func (t *tap) shutdown() {
+	t.closeOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+func (m *Modem) tap(dir TapDirection) io.ReadCloser {
+	t := &tap{
+		m:    m,
+		dir:  dir,
+		ch:   make(chan []byte, tapBufferLen),
+		done: make(chan struct{}),
+	}
+	m.tapMu.Lock()
+	defer m.tapMu.Unlock()
+	if m.st == StatusClosed {
+		t.shutdown()
+		return t
+	}
+	m.taps = append(m.taps, t)
+	return t
+}
+
+// Tap returns a read-only stream with a copy of the online traffic in the given direction.
+// Observers never block the call: data is dropped when the reader falls behind.
+// Modem lock must be held.
+func (m *Modem) Tap(dir TapDirection) io.ReadCloser {
+	m.checkLock()
+	return m.tap(dir)
+}
+
+// TapSync returns a read-only stream with a copy of the online traffic in the given direction.
+// Modem lock is acquired and released.
+func (m *Modem) TapSync(dir TapDirection) io.ReadCloser {
+	m.Lock()
+	defer m.Unlock()
+	return m.tap(dir)
+}
+
+func (m *Modem) removeTap(t *tap) {
+	m.tapMu.Lock()
+	defer m.tapMu.Unlock()
+	for i, v := range m.taps {
+		if v == t {
+			m.taps = append(m.taps[:i], m.taps[i+1:]...)
+			break
+		}
+	}
+	t.shutdown()
+}
+
+func (m *Modem) closeTaps() {
+	m.tapMu.Lock()
+	defer m.tapMu.Unlock()
+	for _, t := range m.taps {
+		t.shutdown()
+	}
+	m.taps = nil
+}
+
+func (m *Modem) feedTaps(dir TapDirection, data []byte) {
+	m.tapMu.Lock()
+	defer m.tapMu.Unlock()
+	if m.recorder != nil {
+		m.recorder.record(dir, data)
+	}
+	for _, t := range m.taps {
+		if t.dir&dir == 0 {
+			continue
+		}
+		select {
+		case t.ch <- append([]byte(nil), data...):
+		default: // slow observer, drop data
+		}
+	}
+}
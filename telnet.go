@@ -0,0 +1,252 @@
+package vmodem
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+)
+
+// Telnet protocol bytes (RFC 854)
+const (
+	telnetIAC  = 255
+	telnetDONT = 254
+	telnetDO   = 253
+	telnetWONT = 252
+	telnetWILL = 251
+	telnetSB   = 250
+	telnetBRK  = 243
+	telnetSE   = 240
+
+	telnetOptBinary = 0
+	telnetOptEcho   = 1
+	telnetOptSGA    = 3
+)
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOpt
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// TelnetConn wraps a connection speaking the Telnet protocol: IAC bytes are escaped
+// and BINARY/SGA/ECHO options are negotiated, so the data stream is 8-bit clean.
+// The modem does not add its own Telnet layer to calls whose connection is already a TelnetConn.
+type TelnetConn struct {
+	conn     io.ReadWriteCloser
+	wmu      sync.Mutex
+	state    int
+	cmd      byte
+	local    map[byte]bool // options enabled on our side (WILL)
+	remote   map[byte]bool // options enabled on the remote side (DO)
+	handlers map[byte]func(data []byte)
+	onBreak  func()
+	sbBuf    []byte
+	readBuf  []byte
+}
+
+// NewTelnetConn wraps conn with Telnet protocol and starts BINARY/SGA negotiation.
+func NewTelnetConn(conn io.ReadWriteCloser) *TelnetConn {
+	t := &TelnetConn{
+		conn:     conn,
+		local:    map[byte]bool{},
+		remote:   map[byte]bool{},
+		handlers: map[byte]func(data []byte){},
+	}
+	t.local[telnetOptBinary] = true
+	t.local[telnetOptSGA] = true
+	t.remote[telnetOptBinary] = true
+	t.remote[telnetOptSGA] = true
+	t.sendRaw([]byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+	})
+	return t
+}
+
+func (t *TelnetConn) sendRaw(b []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	_, err := t.conn.Write(b)
+	return err
+}
+
+// negotiate answers an option request only when it changes the option state (avoids loops)
+func (t *TelnetConn) negotiate(cmd byte, opt byte) {
+	accept := opt == telnetOptBinary || opt == telnetOptSGA || t.handlers[opt] != nil
+	switch cmd {
+	case telnetWILL: // remote wants to enable the option on its side
+		if accept || opt == telnetOptEcho { // let the remote host echo
+			if !t.remote[opt] {
+				t.remote[opt] = true
+				t.sendRaw([]byte{telnetIAC, telnetDO, opt})
+			}
+		} else {
+			t.sendRaw([]byte{telnetIAC, telnetDONT, opt})
+		}
+	case telnetWONT:
+		if t.remote[opt] {
+			t.remote[opt] = false
+			t.sendRaw([]byte{telnetIAC, telnetDONT, opt})
+		}
+	case telnetDO: // remote asks us to enable the option (we never echo)
+		if accept {
+			if !t.local[opt] {
+				t.local[opt] = true
+				t.sendRaw([]byte{telnetIAC, telnetWILL, opt})
+			}
+		} else {
+			t.sendRaw([]byte{telnetIAC, telnetWONT, opt})
+		}
+	case telnetDONT:
+		if t.local[opt] {
+			t.local[opt] = false
+			t.sendRaw([]byte{telnetIAC, telnetWONT, opt})
+		}
+	}
+}
+
+// HandleOption accepts the Telnet option opt on both sides and calls handler with the
+// payload of every subnegotiation (IAC SB opt ... IAC SE) received for it.
+// Handlers run on the reading goroutine. Must be called before the connection is used.
+func (t *TelnetConn) HandleOption(opt byte, handler func(data []byte)) {
+	t.handlers[opt] = handler
+}
+
+// HandleBreak sets the handler of BREAK commands received from the remote side.
+// It is called from Read.
+func (t *TelnetConn) HandleBreak(handler func()) {
+	t.onBreak = handler
+}
+
+// SendBreak implements BreakSender sending IAC BREAK
+func (t *TelnetConn) SendBreak() error {
+	return t.sendRaw([]byte{telnetIAC, telnetBRK})
+}
+
+// SendSubnegotiation sends IAC SB opt data IAC SE, escaping IAC bytes in data.
+func (t *TelnetConn) SendSubnegotiation(opt byte, data []byte) error {
+	msg := []byte{telnetIAC, telnetSB, opt}
+	for _, c := range data {
+		if c == telnetIAC {
+			msg = append(msg, telnetIAC)
+		}
+		msg = append(msg, c)
+	}
+	msg = append(msg, telnetIAC, telnetSE)
+	return t.sendRaw(msg)
+}
+
+// Read implements io.Reader removing Telnet commands from the data stream
+func (t *TelnetConn) Read(b []byte) (int, error) {
+	if len(t.readBuf) < len(b) {
+		t.readBuf = make([]byte, len(b))
+	}
+	for {
+		n, err := t.conn.Read(t.readBuf[:len(b)])
+		out := 0
+		for _, c := range t.readBuf[:n] {
+			switch t.state {
+			case telnetStateData:
+				if c == telnetIAC {
+					t.state = telnetStateIAC
+				} else {
+					b[out] = c
+					out++
+				}
+			case telnetStateIAC:
+				switch c {
+				case telnetIAC: // escaped 0xFF
+					b[out] = c
+					out++
+					t.state = telnetStateData
+				case telnetDO, telnetDONT, telnetWILL, telnetWONT:
+					t.cmd = c
+					t.state = telnetStateOpt
+				case telnetSB:
+					t.sbBuf = t.sbBuf[:0]
+					t.state = telnetStateSB
+				case telnetBRK:
+					if t.onBreak != nil {
+						t.onBreak()
+					}
+					t.state = telnetStateData
+				default: // NOP, GA... ignored
+					t.state = telnetStateData
+				}
+			case telnetStateOpt:
+				t.negotiate(t.cmd, c)
+				t.state = telnetStateData
+			case telnetStateSB:
+				if c == telnetIAC {
+					t.state = telnetStateSBIAC
+				} else {
+					t.sbBuf = append(t.sbBuf, c)
+				}
+			case telnetStateSBIAC:
+				switch c {
+				case telnetSE:
+					if len(t.sbBuf) > 0 && t.handlers[t.sbBuf[0]] != nil {
+						t.handlers[t.sbBuf[0]](t.sbBuf[1:])
+					}
+					t.state = telnetStateData
+				case telnetIAC: // escaped 0xFF inside subnegotiation
+					t.sbBuf = append(t.sbBuf, c)
+					t.state = telnetStateSB
+				default:
+					t.state = telnetStateSB
+				}
+			}
+		}
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+// Write implements io.Writer escaping IAC bytes
+func (t *TelnetConn) Write(b []byte) (int, error) {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+		escaped = append(escaped, c)
+	}
+	if err := t.sendRaw(escaped); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close implements io.Closer
+func (t *TelnetConn) Close() error {
+	return t.conn.Close()
+}
+
+// RemoteAddr returns the remote address of the wrapped connection (nil = not a network connection)
+func (t *TelnetConn) RemoteAddr() net.Addr {
+	if ra, ok := t.conn.(interface{ RemoteAddr() net.Addr }); ok {
+		return ra.RemoteAddr()
+	}
+	return nil
+}
+
+// SetBulkMode implements LatencyModeSetter forwarding to the wrapped connection
+func (t *TelnetConn) SetBulkMode(bulk bool) {
+	if lms, ok := t.conn.(LatencyModeSetter); ok {
+		lms.SetBulkMode(bulk)
+	}
+}
+
+// Ping implements Pinger forwarding to the wrapped connection
+func (t *TelnetConn) Ping(ctx context.Context) error {
+	if p, ok := t.conn.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return ErrPingNotSupported
+}
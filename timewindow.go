@@ -0,0 +1,53 @@
+package vmodem
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a daily time range (local time). Ranges where From > To wrap past midnight.
+type TimeWindow struct {
+	From time.Duration
+	To   time.Duration
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseTimeWindow parses a "HH:MM-HH:MM" time window. An empty string returns nil (always).
+func ParseTimeWindow(s string) (*TimeWindow, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid time window %q", s)
+	}
+	from, err := parseClock(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseClock(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &TimeWindow{From: from, To: to}, nil
+}
+
+// Contains reports whether t is inside the window. A nil window contains any time.
+func (w *TimeWindow) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.From <= w.To {
+		return tod >= w.From && tod < w.To
+	}
+	return tod >= w.From || tod < w.To
+}
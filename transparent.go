@@ -0,0 +1,61 @@
+package vmodem
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// binRequest and binAck negotiate transparent binary mode between vmodem peers. The dialing
+// side sends the request at connect when the Handshake token proved the peer is a vmodem, the
+// answering side replies with the ack and both go transparent for the rest of the call. Peers
+// without Transparent set pass the request to their DTE and the call stays escape-capable.
+var (
+	binRequest = []byte("\x10\x02VMODEM-BIN\x10\x03")
+	binAck     = []byte("\x10\x02VMODEM-BIN-ACK\x10\x03")
+)
+
+// requestTransparent starts the negotiation of transparent mode on a dialed call. Modem lock must be held.
+func (m *Modem) requestTransparent() {
+	if !m.binaryMode || m.handshake == nil || m.handshake.Token == "" {
+		return
+	}
+	m.log.Debug("transparent mode requested")
+	m.conn.Write(binRequest)
+}
+
+// inbandSeqs returns the in-band control sequences the online task looks for
+func (m *Modem) inbandSeqs() [][]byte {
+	seqs := [][]byte{}
+	if m.binaryMode {
+		seqs = append(seqs, binRequest, binAck)
+	}
+	if m.rdlGrant.Load() {
+		seqs = append(seqs, rdlRequest, rdlRelease)
+	}
+	return seqs
+}
+
+// inbandControl acts on an in-band control sequence received from the peer by the online task
+// of ctx. Returns true once transparent mode is negotiated. Modem lock must not be held.
+func (m *Modem) inbandControl(ctx context.Context, conn io.Writer, seq []byte) bool {
+	switch {
+	case bytes.Equal(seq, rdlRequest), bytes.Equal(seq, rdlRelease):
+		request := bytes.Equal(seq, rdlRequest)
+		m.rdlActive.Store(request)
+		m.log.Info("remote digital loopback", "active", request)
+	case bytes.Equal(seq, binRequest), bytes.Equal(seq, binAck):
+		if bytes.Equal(seq, binRequest) {
+			conn.Write(binAck)
+		}
+		m.Lock()
+		defer m.Unlock()
+		if ctx.Err() == nil {
+			m.transparent = true
+			m.xon()
+			m.log.Info("transparent mode negotiated")
+		}
+		return true
+	}
+	return false
+}
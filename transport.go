@@ -0,0 +1,121 @@
+package vmodem
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Transport places calls to the targets of a scheme. target is the full dial target,
+// scheme included (e.g. "tls://host:port").
+type Transport interface {
+	Dial(ctx context.Context, target string) (io.ReadWriteCloser, error)
+}
+
+// TransportFunc adapts a function to the Transport interface
+type TransportFunc func(ctx context.Context, target string) (io.ReadWriteCloser, error)
+
+// Dial implements Transport
+func (f TransportFunc) Dial(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	return f(ctx, target)
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]Transport{
+		"tcp":    &TCPTransport{},
+		"tls":    &TLSTransport{},
+		"telnet": &TCPTransport{Telnet: true},
+		"ws":     &WebSocketTransport{},
+		"wss":    &WebSocketTransport{},
+	}
+)
+
+// RegisterTransport registers (or replaces) the transport of a scheme. A nil transport removes it.
+func RegisterTransport(scheme string, t Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	scheme = strings.ToLower(scheme)
+	if t == nil {
+		delete(transports, scheme)
+		return
+	}
+	transports[scheme] = t
+}
+
+// LookupTransport returns the transport registered for a scheme (nil = none).
+func LookupTransport(scheme string) Transport {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	return transports[strings.ToLower(scheme)]
+}
+
+// SplitTarget splits a dial target into its scheme and address. Targets without
+// scheme ("host:port") are tcp.
+func SplitTarget(target string) (scheme string, addr string) {
+	if i := strings.Index(target, "://"); i > 0 {
+		return strings.ToLower(target[:i]), target[i+3:]
+	}
+	return "tcp", target
+}
+
+// DialTarget connects to target through the transport registered for its scheme.
+// Returns ErrNoCarrier when the scheme has no transport.
+func DialTarget(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	scheme, _ := SplitTarget(target)
+	t := LookupTransport(scheme)
+	if t == nil {
+		return nil, ErrNoCarrier
+	}
+	return t.Dial(ctx, target)
+}
+
+// TransportCall is an OutgoingCallCtx hook dialing through the registered transports.
+// The number is translated by the modem dial plan when it has one, otherwise it is
+// used as the dial target. It is the default hook of modems with a DialPlan.
+func TransportCall(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error) {
+	target := number
+	if m.dialPlan != nil {
+		target = m.dialPlan.LookupAt(number, m.clock.Now())
+	}
+	if target == "" {
+		return nil, ErrNoCarrier
+	}
+	return DialTarget(ctx, target)
+}
+
+// TCPTransport connects to tcp://host:port and host:port targets
+type TCPTransport struct {
+	KeepAlive net.KeepAliveConfig // TCP keepalive probes (zero value = Go defaults)
+	Telnet    bool                // Speak Telnet (telnet:// targets), the modem does not add its own Telnet layer
+}
+
+// Dial implements Transport
+func (t *TCPTransport) Dial(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	_, addr := SplitTarget(target)
+	d := net.Dialer{KeepAliveConfig: t.KeepAlive}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.Telnet {
+		return NewTelnetConn(conn), nil
+	}
+	return conn, nil
+}
+
+// TLSTransport connects to tls://host:port targets
+type TLSTransport struct {
+	Config    *tls.Config         // Client configuration (nil = defaults, server name taken from the target)
+	KeepAlive net.KeepAliveConfig // TCP keepalive probes (zero value = Go defaults)
+}
+
+// Dial implements Transport
+func (t *TLSTransport) Dial(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	_, addr := SplitTarget(target)
+	d := tls.Dialer{NetDialer: &net.Dialer{KeepAliveConfig: t.KeepAlive}, Config: t.Config}
+	return d.DialContext(ctx, "tcp", addr)
+}
@@ -6,17 +6,36 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ErrConfigRequired         = errors.New("config required")
 	ErrModemBusy              = errors.New("modem busy")
+	ErrModemClosed            = errors.New("modem closed")
 	ErrInvalidStateTransition = errors.New("invalid state transition")
 	ErrNoCarrier              = errors.New("no carrier")
+	ErrNoDTE                  = errors.New("no DTE attached")
+	ErrBusy                   = errors.New("busy")
+	ErrNoAnswer               = errors.New("no answer")
+	ErrNoDialtone             = errors.New("no dialtone")
+	ErrInvalidValue           = errors.New("invalid value")
+	ErrWebSocketHandshake     = errors.New("websocket handshake failed")
+	ErrCallRejected           = errors.New("call rejected")
+	ErrBreakNotSupported      = errors.New("break not supported by the connection")
+	ErrPingNotSupported       = errors.New("ping not supported by the connection")
+	ErrInvalidRecording       = errors.New("invalid session recording")
+	ErrCommandFailed          = errors.New("command failed")
+	ErrUndefinedSReg          = errors.New("undefined S-register")
+	ErrRetCodeExists          = errors.New("result code already registered")
+	ErrChatTimeout            = errors.New("chat timeout")
+	ErrChatAborted            = errors.New("chat aborted")
 )
 
 // ModemStatus represents the status of the modem
@@ -50,6 +69,32 @@ func (ms ModemStatus) String() string {
 	}
 }
 
+// defaultReadBufferSize is the default size of the chunks read from the TTY and the connection
+const defaultReadBufferSize = 4096
+
+// defaultCmdBufferLimit is the default max length of a command line after AT
+const defaultCmdBufferLimit = 100
+
+// factorySRegs are the factory values of the S-registers with a meaning for the modem
+var factorySRegs = map[byte]byte{
+	2:  '+',  // escape character (> 127 disables the escape sequence)
+	3:  '\r', // command line terminator and response CR
+	4:  '\n', // response LF
+	5:  '\b', // backspace
+	6:  2,    // seconds waiting for dial tone (blind dialing, ATX0/X1/X3)
+	7:  50,   // seconds waiting for carrier
+	8:  2,    // seconds of pause for each comma in the dial string
+	10: 14,   // carrier loss delay in 1/10 s
+}
+
+// dialTimers are the dialing delays taken from the S-registers
+type dialTimers struct {
+	toneWait    time.Duration // S6 (only when blind dialing)
+	commaPause  time.Duration // S8
+	waitTone    time.Duration // S6 (W modifier)
+	carrierWait time.Duration // S7 (0 = no timeout)
+}
+
 type RetCode int
 
 const (
@@ -64,10 +109,54 @@ const (
 	RetCodeRing
 	RetCodeSkip
 	RetCodeUnknown
+	RetCodeRinging
 )
 
+func (r RetCode) String() string {
+	switch r {
+	case RetCodeOk:
+		return "OK"
+	case RetCodeError:
+		return "ERROR"
+	case RetCodeSilent:
+		return "SILENT"
+	case RetCodeConnect:
+		return "CONNECT"
+	case RetCodeNoCarrier:
+		return "NO CARRIER"
+	case RetCodeNoDialtone:
+		return "NO DIALTONE"
+	case RetCodeBusy:
+		return "BUSY"
+	case RetCodeNoAnswer:
+		return "NO ANSWER"
+	case RetCodeRing:
+		return "RING"
+	case RetCodeRinging:
+		return "RINGING"
+	case RetCodeSkip:
+		return "SKIP"
+	default:
+		if info, ok := registeredRetCode(r); ok {
+			return info.text
+		}
+		return "UNKNOWN"
+	}
+}
+
+// CmdReturnFromString returns the result code named s, built-in or registered with
+// RegisterRetCode (RetCodeUnknown if none)
 func CmdReturnFromString(s string) RetCode {
-	switch strings.ToUpper(s) {
+	s = strings.ToUpper(s)
+	if ret := builtinRetCode(s); ret != RetCodeUnknown {
+		return ret
+	}
+	return lookupRetCode(s)
+}
+
+// builtinRetCode returns the built-in result code named s (upper case)
+func builtinRetCode(s string) RetCode {
+	switch s {
 	case "OK":
 		return RetCodeOk
 	case "ERROR":
@@ -84,6 +173,8 @@ func CmdReturnFromString(s string) RetCode {
 		return RetCodeNoAnswer
 	case "RING":
 		return RetCodeRing
+	case "RINGING":
+		return RetCodeRinging
 	case "SILENT":
 		return RetCodeSilent
 	case "SKIP":
@@ -103,38 +194,224 @@ type Modem struct {
 	conn             io.ReadWriteCloser
 	statusTransition StatusTransitionType
 	outgoingCall     OutgoingCallType
+	outgoingCallCtx  OutgoingCallCtxType
+	dialFailCode     RetCode
+	dialPlan         *DialPlan
 	commandHook      CommandHookType
+	paramsHook       CommandHandler
+	resultHook       ResultHookType
+	errorHook        ErrorHookType
+	cmdLimiter       *rateLimiter
+	echoLimiter      *rateLimiter
+	throttling       bool
 	connectStr       string
-	answerChar       string
+	errorCorrection  bool
+	compression      bool
+	protocolResults  bool
+	testMode         int         // Self test in progress (AT&T)
+	rdlGrant         atomic.Bool // Remote digital loopback requests are granted (AT&T4)
+	defaultRDL       bool
+	rdlActive        atomic.Bool // Received data is looped back to the remote modem
+	handshake        *Handshake
+	callSpeed        int
+	abortChar        string
+	identity         Identity
+	cmdPending       bool
+	cmdCtx           context.Context
+	cmdCtxCancel     context.CancelFunc
 	sregs            map[byte]byte
+	portRate         int
+	icfFormat        int
+	icfParity        int
+	ifcDceByDte      int
+	ifcDteByDce      int
+	xoff             atomic.Pointer[chan struct{}] // Closed by XON (nil = not held by XOFF)
 	echo             bool
 	shortForm        bool
 	quietMode        bool
+	quietAnswer      bool
+	callerIdMode     int
+	callInfo         *CallInfo
+	callTime         time.Time
+	dialNumber       string
+	call             *CallRecord
+	callEnded        CallEndedType
+	incomingFilter   IncomingCallFilterType
+	hangupReason     DisconnectReason
+	idleTimeout      time.Duration
+	maxCallDuration  time.Duration
+	answering        bool
+	handshaking      bool
+	handshakeTime    time.Duration
+	dialTimeout      time.Duration
+	lastNumber       string
+	pendingDial      string
+	preserveDialCase bool
+	storedNumbers    []string
+	redial           *RedialPolicy
+	redialAttempt    int
+	pingInterval     time.Duration
+	pingTimeout      time.Duration
+	tracer           Tracer
+	callSpan         Span
+	expvar           bool
+	redialCancel     context.CancelFunc
+	xLevel           int
 	ringCount        int
 	ringMax          int
 	disablePreGuard  bool
 	disablePostGuard bool
-	metrics          *Metrics
+	escapeChar       byte
+	sregPolicy       SRegPolicy
+	parseDiagnostics bool
+	ties             bool
+	transparent      bool
+	binaryMode       bool // Negotiate transparent mode with vmodem peers
+	bulkMode         bool
+	defaultBulkMode  bool
+	linkQuality      *LinkQuality
+	callLinkQuality  *LinkQuality
+	impaired         *impairedConn // Impairments of the call, m.conn may wrap it
+	telnet           bool
+	defaultTelnet    bool
+	callTelnet       *bool
+	dtr              bool
+	dteAbsent        bool
+	busyOnNoDTE      bool
+	dtrMode          int
+	dcdMode          int
+	dcd              bool
+	controlLines     ControlLines
+	breakHook        BreakHookType
+	log              *slog.Logger
+	ttyTap           io.Writer
+	connTap          io.Writer
+	recordDir        string
+	clock            Clock
+	profileStore     ProfileStore
+	powerOnProfile   int
+	autoAnswerRings  int
+	settings         map[string]string
+	commands         map[string]CommandHandler
+	cmdHistory       []CommandRecord
+	cmdHistoryLen    int
+	cmdBufferLimit   int
+	ttyTaskAlive     atomic.Bool
+	onlineTasks      atomic.Int32
+	tasks            sync.WaitGroup
+	closed           chan struct{} // Closed with the modem
+	metrics          metricCounters
+	tapMu            sync.Mutex
+	taps             []*tap
+	recorder         *sessionRecorder // Guarded by tapMu
+	readBufferSize   int
+	writeBufferSize  int
+	coalesceDelay    time.Duration
+	lineSpeed        int
+	lineTx           *rateLimiter
+	lineRx           *rateLimiter
+	subMu            sync.Mutex
+	subs             []*subscriber
+}
+
+// LatencyModeSetter is an optional interface for connections that can switch between
+// interactive mode (every write flushed immediately) and bulk mode (coalesced writes).
+type LatencyModeSetter interface {
+	SetBulkMode(bulk bool)
+}
+
+// BreakSender is an optional interface for connections able to transmit a break signal
+// (e.g. TelnetConn sends Telnet IAC BREAK).
+type BreakSender interface {
+	SendBreak() error
+}
+
+// Pinger is an optional interface for connections able to check that the remote side is
+// alive (e.g. WebSocketConn sends a ping frame and waits for the pong).
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
 
 type StatusTransitionType func(m *Modem, prevStatus ModemStatus, newStatus ModemStatus)
+
+// OutgoingCallType places a call. Returning ErrBusy, ErrNoAnswer or ErrNoDialtone reports
+// BUSY, NO ANSWER or NO DIALTONE to the DTE, any other error reports NO CARRIER.
+// Hooks able to track the call progress report remote rings with RingbackSync.
 type OutgoingCallType func(m *Modem, number string) (io.ReadWriteCloser, error)
+type OutgoingCallCtxType func(ctx context.Context, m *Modem, number string) (io.ReadWriteCloser, error)
+type ResultHookType func(m *Modem, ret RetCode, text string)
+type ErrorHookType func(m *Modem, err error)
+type BreakHookType func(m *Modem)
+
+// IncomingCallFilterType decides whether an incoming call rings the modem. info holds the
+// caller ID of the call, if any, and its remote address. Returning false rejects the call.
+type IncomingCallFilterType func(m *Modem, info CallInfo) bool
 type CommandHookType func(m *Modem, cmdChar string, cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode
 
 type ModemConfig struct {
-	Id               string
-	OutgoingCall     OutgoingCallType
-	CommandHook      CommandHookType
-	StatusTransition StatusTransitionType
-	TTY              io.ReadWriteCloser
-	ConnectStr       string
-	RingMax          int
-	AnswerChar       string
-	GuardTime        int // 50ms increments
-	DisablePreGuard  bool
-	DisablePostGuard bool
+	Id                 string
+	OutgoingCall       OutgoingCallType
+	OutgoingCallCtx    OutgoingCallCtxType // Like OutgoingCall, ctx is canceled on abort, S7 or DialTimeout timeout (takes precedence)
+	DialPlan           *DialPlan           // Phonebook used to place calls through the registered transports when no OutgoingCall hook is set
+	CommandHook        CommandHookType
+	CommandParamsHook  CommandHandler // Like CommandHook with the parsed parameters, quoted strings and lists (see CommandParams)
+	StatusTransition   StatusTransitionType
+	ResultHook         ResultHookType // Called with every result code sent to the TTY
+	ErrorHook          ErrorHookType  // Called on runtime anomalies (e.g. ErrRateLimited)
+	TTY                io.ReadWriteCloser
+	ConnectStr         string
+	ErrorCorrection    bool // Calls are error corrected, reported as CONNECT <speed>/ARQ (see AT\V)
+	Compression        bool // Calls are compressed, reported as CONNECT <speed>/V42BIS (see AT\V)
+	Transparent        bool // Negotiate transparent binary mode for the whole call with vmodem peers (needs a Handshake Token on dialed calls)
+	RemoteLoopback     bool // Factory grant of the remote digital loopback requested by a vmodem peer (AT&T4, false = AT&T5)
+	RingMax            int
+	AutoAnswerRings    int        // Factory value of S0, rings before answering (0 = disabled)
+	AnswerChar         string     // Deprecated: use Handshake{Token: AnswerChar}
+	Handshake          *Handshake // Exchange with the remote side before CONNECT (nil = none)
+	AbortChar          string     // Only key aborting pending commands and dialing, other keys are discarded (empty = any key)
+	GuardTime          int        // 50ms increments
+	EscapeChar         int        // Factory value of S2, the escape character code (0 = '+', > 127 disables the escape sequence)
+	SRegPolicy         SRegPolicy // Handling of the S-registers without a meaning for the modem (default SRegStore)
+	TIES               bool       // Escape with +++ followed by an AT command line and no guard times (Time Independent Escape Sequence)
+	Identity           Identity   // ATIn and +GMI/+GMM/+GMR/+GSN responses
+	DisablePreGuard    bool
+	DisablePostGuard   bool
+	BulkMode           bool                   // Coalesce connection writes (see LatencyModeSetter and AT#LAT)
+	LinkQuality        *LinkQuality           // Line impairments applied to every call (nil = none)
+	BusyOnNoDTE        bool                   // Refuse incoming calls with ErrNoDTE while DTR is not asserted or no DTE is present (see SetDTEPresent)
+	ProfileStore       ProfileStore           // Persists the profiles saved with AT&W0/1 (restored on start and ATZ0/1, see AT&Y, nil = in memory)
+	CmdHistoryLen      int                    // Number of recent command lines kept (default 32)
+	CmdBufferLimit     int                    // Max length of a command line after AT, longer lines fail with ERROR (default 100)
+	ParseDiagnostics   bool                   // Precede the ERROR of malformed command lines with a marker of the offending character and its cause
+	Telnet             bool                   // Speak Telnet (RFC 854) on connections (see AT+TELNET)
+	CmdRateLimit       float64                // Max command lines processed per second (0 = unlimited)
+	EchoRateLimit      float64                // Max echoed bytes per second in command mode (0 = unlimited)
+	ReadBufferSize     int                    // Size of the chunks read from the TTY and the connection (default 4096)
+	WriteBufferSize    int                    // Coalesce TTY to connection writes in bulk mode up to this size (0 = disabled)
+	CoalesceDelay      time.Duration          // Max time written data waits in the coalescer (default 50ms)
+	LineSpeed          int                    // Simulated line speed in bps pacing both directions of calls and reported in CONNECT (0 = unlimited)
+	CallEnded          CallEndedType          // Called with the statistics of every connected call when it ends (modem lock held)
+	IdleTimeout        time.Duration          // Hang up after this time without data in online mode, factory value of S30 in 10 s units (0 = disabled)
+	MaxCallDuration    time.Duration          // Hang up calls lasting longer than this (0 = unlimited)
+	IncomingCallFilter IncomingCallFilterType // Accepts or rejects incoming calls with ErrCallRejected before ringing (modem lock held)
+	ControlLines       ControlLines           // Notified of DCD line changes (nil = no control lines)
+	BreakHook          BreakHookType          // Called when the remote side sends a break during a call, e.g. to reproduce it on the TTY (modem lock held)
+	Logger             *slog.Logger           // Structured logs of status changes, commands, calls and errors (nil = silent)
+	TtyTap             io.Writer              // Gets a copy of every byte read from the TTY, commands included (modem lock held, must not block)
+	ConnTap            io.Writer              // Gets a copy of every byte read from the connection (called from the data path without the modem lock, must not block)
+	RecordSessions     string                 // Directory where the traffic of every call is recorded, see RecordReader (empty = disabled)
+	Clock              Clock                  // Time source of the modem timers, rate limits and timestamps (nil = system clock)
+	HandshakeTime      time.Duration          // Carrier negotiation time between answering or a successful dial and CONNECT (0 = instant)
+	DialTimeout        time.Duration          // Max time from ATD to CONNECT including S6, S7 and S8 waits, also when S7 is 0 (0 = S7 only)
+	Redial             *RedialPolicy          // Redials the last number after failed dials or carrier loss (nil = disabled)
+	PreserveDialCase   bool                   // Keep the case of dial strings for case sensitive targets like URLs (default upper-cased)
+	PingInterval       time.Duration          // Ping connections implementing Pinger this often while online, unanswered pings drop the call (0 = disabled)
+	PingTimeout        time.Duration          // Max wait for the answer of a ping (0 = PingInterval)
+	Tracer             Tracer                 // Spans of calls and AT command lines, e.g. bridged to OpenTelemetry (nil = disabled)
+	Expvar             bool                   // Publish MetricsSnapshot in the expvar map "vmodem" under the modem id
 }
 
+// Metrics is a copy of the metrics of a modem, see MetricsSnapshot for a JSON friendly version
 type Metrics struct {
 	// ModemStatus is the current status of the modem
 	Status ModemStatus
@@ -146,12 +423,18 @@ type Metrics struct {
 	ConnTxBytes int
 	// ConnRxBytes is the total number of bytes received from the connections (online mode)
 	ConnRxBytes int
+	// ConnTxRate is the number of bytes per second transmitted to the connection during the last second
+	ConnTxRate int
+	// ConnRxRate is the number of bytes per second received from the connection during the last second
+	ConnRxRate int
 	// NumConns is the total number of connections
 	NumConns int
 	// NumInConns is the total number of incoming connections
 	NumInConns int
 	// NumOutConns is the total number of outgoing connections
 	NumOutConns int
+	// NumFailedDials is the total number of dials that did not connect
+	NumFailedDials int
 	// LastTtyTxTime is the time of the last tty transmit
 	LastTtyTxTime time.Time
 	// LastTtyRxTime is the time of the last tty receive
@@ -160,8 +443,26 @@ type Metrics struct {
 	LastAtCmdTime time.Time
 	// LastConnTime is the time of the last connection (online mode)
 	LastConnTime time.Time
+	// CallDuration is the duration of the current call (0 = no call)
+	CallDuration time.Duration
+	// LastRemote is the remote address of the last connection, or its number when it was not a network connection
+	LastRemote string
+	// Commands is the number of times each AT command has been processed
+	Commands map[string]int
+	// SRegReads is the number of S-register queries
+	SRegReads int
+	// SRegWrites is the number of S-register assignments
+	SRegWrites int
+	// HookHandledCmds is the number of commands handled by the command hook
+	HookHandledCmds int
+	// CmdErrors is the number of command lines that returned ERROR
+	CmdErrors int
+	// ThrottledEvents is the number of times command processing or echo was throttled
+	ThrottledEvents int
 }
 
+var validPortRates = []int{0, 300, 600, 1200, 2400, 4800, 9600, 19200, 38400, 57600, 115200, 230400, 460800, 921600}
+
 func checkValidCmdChar(b byte) bool {
 	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z')
 }
@@ -176,9 +477,10 @@ func (m *Modem) checkLock() {
 	}
 }
 
+// ttyWrite writes to the TTY, modem lock not required (the online data path writes without it)
 func (m *Modem) ttyWrite(b []byte) {
-	m.metrics.LastTtyTxTime = time.Now()
-	m.metrics.TtyTxBytes += len(b)
+	m.storeNow(&m.metrics.lastTtyTx)
+	m.metrics.ttyTx.Add(int64(len(b)))
 	m.tty.Write(b)
 }
 
@@ -201,14 +503,24 @@ func (m *Modem) Id() string {
 	return m.id
 }
 
+// DialPlan returns the dial plan of the modem (nil if none). The dial plan is safe for concurrent use.
+func (m *Modem) DialPlan() *DialPlan {
+	return m.dialPlan
+}
+
 func (m *Modem) cr() string {
 	if m.shortForm {
-		return "\r"
+		return string(m.sregs[3])
 	} else {
-		return "\r\n"
+		return m.eol()
 	}
 }
 
+// eol returns the line terminator of responses, S3 (CR) followed by S4 (LF)
+func (m *Modem) eol() string {
+	return string([]byte{m.sregs[3], m.sregs[4]})
+}
+
 func (m *Modem) Cr() string {
 	m.checkLock()
 	return m.cr()
@@ -220,6 +532,60 @@ func (m *Modem) CrSync() string {
 	return m.cr()
 }
 
+// connectSpeedCodes maps connect speeds to their extended numeric result codes (ATV0)
+var connectSpeedCodes = map[int]int{
+	1200:   5,
+	2400:   10,
+	4800:   11,
+	9600:   12,
+	7200:   13,
+	12000:  14,
+	14400:  15,
+	19200:  16,
+	38400:  17,
+	57600:  18,
+	115200: 19,
+	230400: 20,
+}
+
+// connectSpeed returns the speed reported on connect, taken from the connect string
+// or from the +IPR port rate. Returns 0 when no speed is reported.
+func (m *Modem) connectSpeed() int {
+	fields := strings.Fields(m.connectStr)
+	for _, f := range fields {
+		speed, _, _ := strings.Cut(f, "/") // e.g. 9600/ARQ
+		if v, err := strconv.Atoi(speed); err == nil {
+			return v
+		}
+	}
+	if speed := m.callLineSpeed(); speed > 0 {
+		return speed
+	}
+	return m.portRate
+}
+
+// connectText returns the verbose CONNECT result: the connect string followed by the speed
+// (ATX1-4) and the error correction and compression of the call (AT\V1), e.g.
+// "CONNECT 33600/ARQ/V42BIS"
+func (m *Modem) connectText() string {
+	s := m.connectStr
+	if m.xLevel == 0 {
+		return s
+	}
+	if speed := m.connectSpeed(); speed > 0 && !strings.ContainsAny(s, "0123456789") {
+		s += " " + strconv.Itoa(speed)
+	}
+	if m.protocolResults {
+		if m.errorCorrection {
+			s += "/ARQ"
+		}
+		if m.compression {
+			s += "/V42BIS"
+		}
+	}
+	return s
+}
+
 func (m *Modem) printRetCode(ret RetCode) {
 	retStr := ""
 	if m.shortForm {
@@ -232,6 +598,11 @@ func (m *Modem) printRetCode(ret RetCode) {
 			retStr = "4"
 		case RetCodeConnect:
 			retStr = "1"
+			if m.xLevel > 0 {
+				if code, ok := connectSpeedCodes[m.connectSpeed()]; ok {
+					retStr = strconv.Itoa(code)
+				}
+			}
 		case RetCodeNoCarrier:
 			retStr = "3"
 		case RetCodeNoDialtone:
@@ -242,6 +613,12 @@ func (m *Modem) printRetCode(ret RetCode) {
 			retStr = "8"
 		case RetCodeRing:
 			retStr = "2"
+		case RetCodeRinging:
+			retStr = "21" // after the CONNECT <speed> codes
+		default:
+			if info, ok := registeredRetCode(ret); ok {
+				retStr = strconv.Itoa(info.code)
+			}
 		}
 	} else {
 		switch ret {
@@ -252,7 +629,7 @@ func (m *Modem) printRetCode(ret RetCode) {
 		case RetCodeError:
 			retStr = "ERROR"
 		case RetCodeConnect:
-			retStr = m.connectStr
+			retStr = m.connectText()
 		case RetCodeNoCarrier:
 			retStr = "NO CARRIER"
 		case RetCodeNoDialtone:
@@ -263,40 +640,92 @@ func (m *Modem) printRetCode(ret RetCode) {
 			retStr = "NO ANSWER"
 		case RetCodeRing:
 			retStr = "RING"
+		case RetCodeRinging:
+			retStr = "RINGING"
+		default:
+			if info, ok := registeredRetCode(ret); ok {
+				retStr = info.text
+			}
 		}
 	}
-	if !m.quietMode {
+	if !m.quietMode && !(m.quietAnswer && m.answering) {
 		m.ttyWriteStr(m.cr() + retStr + m.cr())
+		if m.resultHook != nil {
+			m.resultHook(m, ret, retStr)
+		}
 	}
 }
 
-func (m *Modem) SetStatus(status ModemStatus) {
+// SetStatus forces a status transition. Returns ErrInvalidStateTransition if the
+// transition is not allowed from the current status. Modem lock must be held.
+func (m *Modem) SetStatus(status ModemStatus) error {
 	m.checkLock()
-	m.setStatus(status)
+	return m.setStatus(status)
 }
 
-func (m *Modem) SetStatusSync(status ModemStatus) {
+// SetStatusSync forces a status transition. Returns ErrInvalidStateTransition if the
+// transition is not allowed from the current status. Modem lock is acquired and released.
+func (m *Modem) SetStatusSync(status ModemStatus) error {
 	m.Lock()
 	defer m.Unlock()
-	m.setStatus(status)
+	return m.setStatus(status)
+}
+
+// validTransition reports whether the modem can go from prevStatus to status
+func validTransition(prevStatus, status ModemStatus) bool {
+	switch {
+	case prevStatus == StatusClosed:
+		return false
+	case status == StatusConnected:
+		return prevStatus == StatusDialing || prevStatus == StatusRinging || prevStatus == StatusConnectedCmd
+	case status == StatusConnectedCmd:
+		return prevStatus == StatusConnected
+	case status == StatusDialing, status == StatusRinging:
+		return prevStatus == StatusIdle
+	default:
+		return true
+	}
 }
 
-func (m *Modem) setStatus(status ModemStatus) {
+func (m *Modem) setStatus(status ModemStatus) error {
 	prevStatus := m.st
 	if prevStatus == status {
-		return
+		return nil
 	}
-	if prevStatus == StatusClosed {
-		panic(ErrInvalidStateTransition)
+	if !validTransition(prevStatus, status) {
+		return ErrInvalidStateTransition
 	}
 	m.stCtxCancel()
 	m.stCtx, m.stCtxCancel = context.WithCancel(context.Background())
+	m.abortCommand()
+	m.xon()
+	m.handshaking = false
+	m.pendingDial = ""
 	m.st = status
+	m.metrics.status.Store(int32(status))
+	m.traceStatus(prevStatus, status)
+	m.log.Info("status", "from", prevStatus.String(), "to", status.String())
 	switch m.st {
 	case StatusIdle:
-		if prevStatus == StatusConnected || prevStatus == StatusConnectedCmd || prevStatus == StatusDialing {
+		if prevStatus == StatusDialing && m.dialFailCode != RetCodeOk {
+			m.printRetCode(m.xLevelResult(m.dialFailCode))
+		} else if prevStatus == StatusConnected || prevStatus == StatusConnectedCmd || prevStatus == StatusDialing {
 			m.printRetCode(RetCodeNoCarrier)
 		}
+		if prevStatus == StatusDialing {
+			m.metrics.numFailedDials.Add(1)
+		}
+		m.dialFailCode = RetCodeOk
+		m.transparent = false
+		m.callLinkQuality = nil
+		m.impaired = nil
+		m.callTelnet = nil
+		m.callInfo = nil
+		m.answering = false
+		m.callSpeed = 0
+		m.dialNumber = ""
+		m.testMode = testNone
+		m.rdlActive.Store(false)
 
 		if m.conn != nil {
 			m.conn.Close()
@@ -304,46 +733,86 @@ func (m *Modem) setStatus(status ModemStatus) {
 		}
 
 	case StatusConnected:
-		if prevStatus != StatusDialing && prevStatus != StatusRinging && prevStatus != StatusConnectedCmd {
-			panic(ErrInvalidStateTransition)
-		}
 		if prevStatus == StatusRinging {
-			if m.answerChar != "" {
-				m.conn.Write([]byte(m.answerChar[0:1]))
-			}
-			m.metrics.NumInConns++
+			m.sendHandshake()
+			m.metrics.numInConns.Add(1)
 		}
-		if prevStatus == StatusDialing {
-			m.metrics.NumOutConns++
+		if prevStatus == StatusDialing && m.testMode != testAnalogLoopback {
+			m.metrics.numOutConns.Add(1)
+		}
+		if prevStatus != StatusConnectedCmd {
+			if m.testMode != testAnalogLoopback { // the local loopback is neither a call nor a line
+				m.beginCall(prevStatus)
+				if _, ok := m.conn.(*TelnetConn); !ok && m.effectiveTelnet() {
+					m.conn = NewTelnetConn(m.conn)
+				}
+				if tc, ok := m.conn.(*TelnetConn); ok {
+					tc.HandleBreak(m.remoteBreak(m.call))
+				}
+				if lq := m.effectiveLinkQuality(); lq != nil {
+					m.impaired = newImpairedConn(m.conn, *lq, m.clock)
+					m.conn = m.impaired
+				}
+				if m.writeBufferSize > 0 {
+					m.conn = newCoalescingConn(m.conn, m.writeBufferSize, m.coalesceDelay, m.clock)
+				}
+				if prevStatus == StatusDialing {
+					m.requestTransparent()
+				}
+			}
+			m.lineTx = newLineLimiter(m.callLineSpeed(), m.clock)
+			m.lineRx = newLineLimiter(m.callLineSpeed(), m.clock)
 		}
-		m.metrics.NumConns++
-		m.metrics.LastConnTime = time.Now()
+		m.storeNow(&m.metrics.lastActivity)
+		m.metrics.numConns.Add(1)
+		m.storeNow(&m.metrics.lastConn)
+		m.applyLatencyMode()
 		m.printRetCode(RetCodeConnect)
-		go m.onlineTask(m.stCtx)
-	case StatusConnectedCmd:
-		if prevStatus != StatusConnected {
-			panic(ErrInvalidStateTransition)
+		ctx := m.stCtx
+		m.goTask(func() { m.onlineTask(ctx) })
+		if p, ok := m.conn.(Pinger); ok && m.pingInterval > 0 {
+			m.goTask(func() { m.pingTask(ctx, p) })
 		}
+	case StatusConnectedCmd:
 		m.printRetCode(RetCodeOk)
 	case StatusDialing:
-		if prevStatus != StatusIdle {
-			panic(ErrInvalidStateTransition)
-		}
 	case StatusRinging:
-		if prevStatus != StatusIdle {
-			panic(ErrInvalidStateTransition)
-		}
-		go m.ringer(m.stCtx)
+		m.answering = true
+		ctx := m.stCtx
+		m.goTask(func() { m.ringer(ctx) })
 	case StatusClosed:
+		close(m.closed)
+		if m.expvar {
+			m.unpublishExpvar()
+		}
 		m.tty.Close()
+		m.closeTaps()
 		if prevStatus == StatusConnected || prevStatus == StatusConnectedCmd || prevStatus == StatusRinging {
 			m.conn.Close()
 			m.conn = nil
 		}
 	}
+	m.updateDCD()
+	m.emit(ModemEvent{Type: EventStatus, PrevStatus: prevStatus})
+	if status == StatusConnected && prevStatus != StatusConnectedCmd {
+		m.emit(ModemEvent{Type: EventConnect, PrevStatus: prevStatus})
+	}
+	if (status == StatusIdle || status == StatusClosed) && (prevStatus == StatusConnected || prevStatus == StatusConnectedCmd) {
+		m.emit(ModemEvent{Type: EventDisconnect, PrevStatus: prevStatus})
+		if status == StatusClosed {
+			m.hangupReason = DisconnectClosed
+		}
+		m.endCall(m.hangupReason)
+		m.stopRecording()
+		m.hangupReason = DisconnectLocal
+	}
+	if status == StatusClosed {
+		m.closeSubscribers()
+	}
 	if m.statusTransition != nil {
 		m.statusTransition(m, prevStatus, status)
 	}
+	return nil
 }
 
 func (m *Modem) status() ModemStatus {
@@ -380,26 +849,59 @@ func (m *Modem) CloseSync() {
 	m.close()
 }
 
+// Shutdown closes the modem and waits until its goroutines (TTY and connection readers,
+// ringer, dialing and call timers) have exited, so the TTY and connections can be torn
+// down safely. Returns ctx.Err() if ctx expires first, also while waiting for the lock of a
+// wedged modem (it is closed once the lock is acquired). Outgoing call hooks ignoring their
+// ctx are not waited for. Modem lock must not be held, it is acquired and released.
+func (m *Modem) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		m.CloseSync()
+		m.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// goTask runs f in a modem goroutine, waited for by Shutdown
+func (m *Modem) goTask(f func()) {
+	m.tasks.Add(1)
+	go func() {
+		defer m.tasks.Done()
+		f()
+	}()
+}
+
 func (m *Modem) ringer(ctx context.Context) {
 	m.Lock()
-	for m.status() == StatusRinging {
+	for m.status() == StatusRinging && !m.handshaking {
 		if ctx.Err() != nil {
 			break
 		}
 		m.ringCount++
 		m.printRetCode(RetCodeRing)
+		m.emit(ModemEvent{Type: EventRing, Rings: m.ringCount})
+		if m.ringCount == 1 {
+			m.printCallerId()
+		}
 		if m.ringCount > m.ringMax {
 			m.setStatus(StatusIdle)
 			break
 		}
 		if m.sregs[0] > 0 && m.ringCount >= int(m.sregs[0]) {
-			m.setStatus(StatusConnected)
+			m.answer()
 			break
 		}
 		m.Unlock()
 		select {
 		case <-ctx.Done():
-		case <-time.After(2 * time.Second):
+		case <-m.clock.After(2 * time.Second):
 		}
 		m.Lock()
 	}
@@ -408,343 +910,1492 @@ func (m *Modem) ringer(ctx context.Context) {
 }
 
 func (m *Modem) onlineTask(ctx context.Context) {
-	buff := make([]byte, 128)
+	m.onlineTasks.Add(1)
+	defer m.onlineTasks.Add(-1)
+	buff := make([]byte, m.readBufferSize)
 	m.Lock()
+	chunk := m.lineChunk()
+	lineRx := m.lineRx
+	conn := m.conn
+	transparent := m.transparent // no in-band control sequences in transparent mode
+	m.Unlock()
+	inband := &inbandDetector{}
+	emit := func(data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		if m.rdlActive.Load() {
+			conn.Write(data)
+		} else {
+			m.feedTaps(TapRx, data)
+			m.ttyWrite(data)
+		}
+	}
+	// The data pump runs without the modem lock, taken only when the carrier is lost.
+	// ctx is canceled before the connection is replaced or closed by setStatus.
 	for ctx.Err() == nil {
-		m.Unlock()
-		n, err := m.conn.Read(buff)
-		m.Lock()
+		n, err := conn.Read(buff[:chunk])
 		if ctx.Err() != nil {
 			break
 		}
 		if err != nil || n == 0 {
-			m.setStatus(StatusIdle)
+			m.lostCarrier(ctx)
 			break
 		}
-		m.metrics.ConnRxBytes += n
-		m.Unlock()
-		m.ttyWrite(buff[:n])
-		m.Lock()
+		if m.connTap != nil {
+			m.connTap.Write(buff[:n])
+		}
+		m.countCallRx(n)
+		if xoff := m.xoff.Load(); xoff != nil { // held by XOFF until XON
+			select {
+			case <-*xoff:
+			case <-ctx.Done():
+			}
+		}
+		data := buff[:n]
+		inband.mu.Lock()
+		for {
+			seqs := m.inbandSeqs()
+			if len(seqs) == 0 || transparent {
+				emit(inband.flush())
+				emit(data)
+				break
+			}
+			out, seq, rest := inband.scan(data, seqs)
+			emit(out)
+			if seq == nil {
+				break
+			}
+			transparent = m.inbandControl(ctx, conn, seq)
+			data = rest
+		}
+		if len(inband.tail) > 0 { // pass on the held back bytes if the sequence never completes
+			gen := inband.gen
+			m.goTask(func() {
+				select {
+				case <-m.clock.After(inbandHold):
+				case <-ctx.Done():
+					return
+				}
+				inband.mu.Lock()
+				defer inband.mu.Unlock()
+				if inband.gen == gen && ctx.Err() == nil {
+					emit(inband.flush())
+				}
+			})
+		}
+		inband.mu.Unlock()
+		paceCtx(ctx, lineRx, n)
 	}
+}
+
+// lostCarrier hangs up the call after the S10 carrier loss time when the connection of the
+// online task of ctx fails. Modem lock must not be held.
+func (m *Modem) lostCarrier(ctx context.Context) {
+	m.Lock()
+	carrierLoss := time.Duration(m.sregs[10]) * 100 * time.Millisecond
 	m.Unlock()
+	if !clockSleep(ctx, m.clock, carrierLoss) {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	outgoing := m.call != nil && m.call.Direction == CallOutgoing
+	m.hangupReason = DisconnectCarrierLost
+	m.setStatus(StatusIdle)
+	if outgoing && m.redial != nil && m.redial.OnCarrierLoss {
+		m.scheduleRedial()
+	}
 }
 
-func (m *Modem) incomingCall(conn io.ReadWriteCloser) error {
+func (m *Modem) incomingCall(conn io.ReadWriteCloser, info *CallInfo) error {
 	if m.status() != StatusIdle {
 		return ErrModemBusy
 	}
+	if m.busyOnNoDTE && (!m.dtr || m.dteAbsent) {
+		return ErrNoDTE
+	}
+	if m.incomingFilter != nil {
+		filterInfo := CallInfo{}
+		if info != nil {
+			filterInfo = *info
+		}
+		if filterInfo.Remote == "" {
+			filterInfo.Remote = connRemoteAddr(conn)
+		}
+		if !m.incomingFilter(m, filterInfo) {
+			m.log.Info("incoming call rejected", "number", filterInfo.Number, "remote", filterInfo.Remote)
+			return ErrCallRejected
+		}
+	}
 	m.conn = conn
+	m.log.Info("incoming call", "remote", connRemoteAddr(conn))
 	m.setStatus(StatusRinging)
+	remote := connRemoteAddr(conn)
+	number := ""
+	if info != nil {
+		m.callInfo = info
+		m.callTime = m.clock.Now()
+		number = info.Number
+		if info.Remote != "" {
+			remote = info.Remote
+		}
+	}
+	m.startCallSpan(CallIncoming, number, remote)
 	return nil
 }
 
 // IncomingCall simulates an incoming call. Modem lock must be held.
 func (m *Modem) IncomingCall(conn io.ReadWriteCloser) error {
 	m.checkLock()
-	return m.incomingCall(conn)
+	return m.incomingCall(conn, nil)
 }
 
 // IncomingCallSync simulates an incoming call. Modem lock is acquired and released.
 func (m *Modem) IncomingCallSync(conn io.ReadWriteCloser) error {
 	m.Lock()
 	defer m.Unlock()
-	return m.incomingCall(conn)
+	return m.incomingCall(conn, nil)
 }
 
-func (m *Modem) processDialing(ctx context.Context, number string) {
-	if ctx.Err() != nil {
-		return
+// dial places the call with the OutgoingCallCtx hook or, failing that, with the OutgoingCall hook.
+// The dial is abandoned when ctx is done, also when the hook ignores ctx, closing the connection
+// if the hook returns one later.
+func (m *Modem) dial(ctx context.Context, number string) (io.ReadWriteCloser, error) {
+	type dialResult struct {
+		conn io.ReadWriteCloser
+		err  error
 	}
-	fail := false
-	transport := false
-	conn, err := m.outgoingCall(m, number)
-	if err != nil {
-		fail = true
-	} else {
-		transport = true
-	}
-	if m.answerChar != "" && transport {
-		buff := make([]byte, 1)
-		n, err := conn.Read(buff)
-		if err != nil || n != 1 || buff[0] != m.answerChar[0] {
-			fail = true
+	resCh := make(chan dialResult, 1)
+	go func() {
+		var conn io.ReadWriteCloser
+		var err error
+		if m.outgoingCallCtx != nil {
+			conn, err = m.outgoingCallCtx(ctx, m, number)
+		} else {
+			conn, err = m.outgoingCall(m, number)
 		}
-	}
-	m.Lock()
-	defer m.Unlock()
-	if ctx.Err() != nil {
-		if transport {
-			conn.Close()
+		select {
+		case resCh <- dialResult{conn, err}:
+		case <-ctx.Done():
 		}
-		return
-	}
-	if fail {
-		if transport {
+		if ctx.Err() != nil && conn != nil {
 			conn.Close()
 		}
-		m.setStatus(StatusIdle)
-		return
+	}()
+	select {
+	case res := <-resCh:
+		return res.conn, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	m.conn = conn
-	m.setStatus(StatusConnected)
 }
 
-func (m *Modem) processCommand(cmdChar string, cmdNum string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode {
-	if m.commandHook != nil {
-		r := m.commandHook(m, cmdChar, cmdNum, cmdAssign, cmdQuery, cmdAssignVal)
-		if r != RetCodeSkip {
-			return r
-		}
-	}
-	switch cmdChar {
-	case "S":
-		r, _ := strconv.Atoi(cmdNum)
-		if r < 0 || r > 255 {
-			return RetCodeError
-		}
-		if cmdAssign {
-			v, _ := strconv.Atoi(cmdAssignVal)
-			if v < 0 || v > 255 {
-				return RetCodeError
-			}
-			m.sregs[byte(r)] = byte(v)
-			return RetCodeOk
-		}
-		if cmdQuery {
-			v := m.sregs[byte(r)]
-			m.ttyWriteStr(fmt.Sprintf(m.cr()+"%03d\r\n", v))
-			return RetCodeOk
-		}
-	case "E":
-		n, _ := strconv.Atoi(cmdNum)
-		switch n {
-		case 0:
-			m.echo = false
-		case 1:
-			m.echo = true
-		default:
-			return RetCodeError
-		}
-	case "V":
-		n, _ := strconv.Atoi(cmdNum)
-		switch n {
-		case 0:
-			m.shortForm = true
-		case 1:
-			m.shortForm = false
-		default:
-			return RetCodeError
-		}
-	case "D":
-		if m.status() != StatusIdle {
-			return RetCodeError
-		}
-		if m.outgoingCall != nil {
-			m.setStatus(StatusDialing)
-			number := strings.ToUpper(strings.TrimSpace(cmdAssignVal))
-			if len(number) > 0 && (number[0] == 'T' || number[0] == 'P') {
-				number = number[1:]
-				number = strings.TrimSpace(number)
-			}
-			go m.processDialing(m.stCtx, number)
-			return RetCodeSilent
-		}
+// dialErrorCode maps the error returned by the outgoing call hooks to the result code reported to the DTE
+func dialErrorCode(err error) RetCode {
+	switch {
+	case errors.Is(err, ErrBusy):
+		return RetCodeBusy
+	case errors.Is(err, ErrNoAnswer):
+		return RetCodeNoAnswer
+	case errors.Is(err, ErrNoDialtone):
+		return RetCodeNoDialtone
+	default:
 		return RetCodeNoCarrier
-	case "A":
-		if m.status() == StatusIdle {
-			return RetCodeNoCarrier
-		}
-		if m.status() != StatusRinging {
-			return RetCodeError
-		}
-		m.setStatus(StatusConnected)
-		return RetCodeSilent
-	case "H":
-		if m.status() == StatusConnected || m.status() == StatusConnectedCmd {
-			m.setStatus(StatusIdle)
-			return RetCodeSilent
-		}
-	case "O":
-		if m.status() != StatusConnectedCmd {
-			return RetCodeError
-		}
-		m.setStatus(StatusConnected)
-		return RetCodeSilent
-	case "Q":
-		n, _ := strconv.Atoi(cmdNum)
-		switch n {
-		case 0:
-			m.quietMode = false
-		case 1:
-			m.quietMode = true
-		default:
-			return RetCodeError
-		}
-	case "&F", "Z":
-		m.sregs[0] = 0
-		m.echo = true
-		m.shortForm = false
-		m.quietMode = false
-		if m.status() == StatusConnected || m.status() == StatusConnectedCmd {
-			m.setStatus(StatusIdle)
-			return RetCodeSilent
-		}
 	}
-	return RetCodeOk
 }
 
-func (m *Modem) processAtCommand(cmd string) RetCode {
-	if m.status() != StatusIdle && m.status() != StatusConnectedCmd && m.status() != StatusRinging {
-		return RetCodeError
+// xLevelResult hides the call progress results not enabled by the ATX level:
+// NO DIALTONE needs X2 or X4, BUSY and RINGING need X3 or X4. Hidden results report
+// NO CARRIER, except RINGING that is not reported at all.
+func (m *Modem) xLevelResult(ret RetCode) RetCode {
+	switch {
+	case ret == RetCodeRinging && m.xLevel < 3:
+		return RetCodeSilent
+	case ret == RetCodeNoDialtone && m.xLevel != 2 && m.xLevel != 4:
+		return RetCodeNoCarrier
+	case ret == RetCodeBusy && m.xLevel < 3:
+		return RetCodeNoCarrier
+	default:
+		return ret
 	}
-	m.metrics.LastAtCmdTime = time.Now()
-	cmdBuf := bytes.NewBufferString(cmd)
-	cmdRet := RetCodeOk
-	e := false
-	for cmdBuf.Len() > 0 && !e {
-		cmdChar := ""
-		cmdNum := ""
-		cmdLong := false
-		cmdAssign := false
-		cmdQuery := false
-		cmdAssignVal := ""
-
-		for cmdBuf.Len() > 0 && !e {
-			b, err := cmdBuf.ReadByte()
-			if err != nil {
-				e = true
-				break
-			}
+}
 
-			if b == '?' {
-				if cmdChar != "" {
-					cmdQuery = true
-					break
-				} else {
-					e = true
-					break
-				}
-			}
+func (m *Modem) resetSRegs() {
+	for k, v := range factorySRegs {
+		m.sregs[k] = v
+	}
+	m.sregs[0] = byte(m.autoAnswerRings)
+	if m.escapeChar != 0 {
+		m.sregs[2] = m.escapeChar
+	}
+	m.sregs[30] = byte(min((m.idleTimeout+10*time.Second-1)/(10*time.Second), 255))
+}
 
-			if cmdAssign {
-				if !cmdLong && !checkValidNumChar(b) { // short command only accepts numbers
-					cmdBuf.UnreadByte()
-					break
-				}
-				cmdAssignVal += string(b)
-				continue
-			}
+// SetAutoAnswer sets the number of rings before answering incoming calls (S0, 0 = disabled).
+// Modem lock must be held.
+func (m *Modem) SetAutoAnswer(rings int) error {
+	m.checkLock()
+	return m.setAutoAnswer(rings)
+}
 
-			if b == '+' || b == '#' {
-				if cmdChar == "" {
-					cmdLong = true
-					cmdChar += string(b)
-					continue
-				} else {
-					e = true
-					break
-				}
-			}
+// SetAutoAnswerSync sets the number of rings before answering incoming calls (S0, 0 = disabled).
+// Modem lock is acquired and released.
+func (m *Modem) SetAutoAnswerSync(rings int) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.setAutoAnswer(rings)
+}
 
-			if b == '=' {
-				if cmdChar != "" {
-					cmdAssign = true
-					continue
-				} else {
-					e = true
-					break
-				}
-			}
+func (m *Modem) setAutoAnswer(rings int) error {
+	if rings < 0 || rings > 255 {
+		return ErrInvalidValue
+	}
+	m.sregs[0] = byte(rings)
+	return nil
+}
 
-			if cmdLong {
-				if checkValidCmdChar(b) {
-					cmdChar += string(b)
-					continue
-				} else {
-					e = true
-					break
+// AutoAnswer returns the number of rings before answering incoming calls (S0, 0 = disabled).
+// Modem lock must be held.
+func (m *Modem) AutoAnswer() int {
+	m.checkLock()
+	return int(m.sregs[0])
+}
+
+// AutoAnswerSync returns the number of rings before answering incoming calls (S0, 0 = disabled).
+// Modem lock is acquired and released.
+func (m *Modem) AutoAnswerSync() int {
+	m.Lock()
+	defer m.Unlock()
+	return int(m.sregs[0])
+}
+
+func (m *Modem) dialTimers() dialTimers {
+	t := dialTimers{
+		commaPause:  time.Duration(m.sregs[8]) * time.Second,
+		waitTone:    time.Duration(m.sregs[6]) * time.Second,
+		carrierWait: time.Duration(m.sregs[7]) * time.Second,
+	}
+	if m.xLevel != 2 && m.xLevel != 4 {
+		t.toneWait = time.Duration(m.sregs[6]) * time.Second
+	}
+	return t
+}
+
+// placeCall waits the dial tone and the dial modifiers, dials waiting for carrier up to S7 and
+// completes the handshake. Returns the connection and its negotiated line speed.
+func (m *Modem) placeCall(ctx context.Context, number string, timers dialTimers) (io.ReadWriteCloser, int, error) {
+	number, wait := dialModifiers(number, timers)
+	if !clockSleep(ctx, m.clock, timers.toneWait+wait) {
+		return nil, 0, ErrNoCarrier
+	}
+	if timers.carrierWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = clockTimeout(ctx, m.clock, timers.carrierWait)
+		defer cancel()
+	}
+	conn, err := m.dial(ctx, number)
+	if err != nil {
+		return nil, 0, err
+	}
+	speed, err := m.awaitHandshake(ctx, conn)
+	if err == nil && m.handshakeTime > 0 && !clockSleep(ctx, m.clock, m.handshakeTime) {
+		err = ErrNoCarrier
+	}
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+	return conn, speed, nil
+}
+
+// processDialing places the call, failing with NO CARRIER when it takes longer than the DialTimeout.
+// Returns nil once connected, ctx error if the dial was aborted or the reason of the failure.
+func (m *Modem) processDialing(ctx context.Context, number string, timers dialTimers) error {
+	m.log.Info("dial", "number", number)
+	dialCtx := ctx
+	if m.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = clockTimeout(ctx, m.clock, m.dialTimeout)
+		defer cancel()
+	}
+	conn, speed, err := m.placeCall(dialCtx, number, timers)
+	m.Lock()
+	defer m.Unlock()
+	if ctx.Err() != nil {
+		if err == nil {
+			conn.Close()
+		}
+		return ctx.Err()
+	}
+	if err != nil {
+		m.log.Info("dial failed", "number", number, "error", err)
+		m.dialFailCode = dialErrorCode(err)
+		m.setStatus(StatusIdle)
+		m.scheduleRedial()
+		return err
+	}
+	m.conn = conn
+	m.callSpeed = speed
+	m.redialAttempt = 0
+	if err := m.setStatus(StatusConnected); err != nil {
+		m.conn = nil
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// dialString normalizes the dial string of ATD removing the tone/pulse modifier.
+// It is upper-cased unless PreserveDialCase is set.
+func (m *Modem) dialString(s string) string {
+	number := strings.TrimSpace(s)
+	if !m.preserveDialCase {
+		number = strings.ToUpper(number)
+	}
+	if len(number) > 0 && strings.ContainsRune("TtPp", rune(number[0])) {
+		number = number[1:]
+		number = strings.TrimSpace(number)
+	}
+	return number
+}
+
+// Dial places a call like ATD and blocks until it is connected or fails ("L" redials the
+// last number, a trailing ; keeps the number to be completed by the next dial and returns).
+// Canceling ctx aborts the dial. Result codes are reported to the TTY as usual.
+// Modem lock must not be held, it is acquired and released.
+func (m *Modem) Dial(ctx context.Context, number string) error {
+	m.Lock()
+	if m.status() != StatusIdle {
+		m.Unlock()
+		return ErrModemBusy
+	}
+	if m.outgoingCallCtx == nil && m.outgoingCall == nil {
+		m.Unlock()
+		return ErrNoCarrier
+	}
+	number, pending, ok := m.prepareDial(number)
+	if !ok {
+		m.Unlock()
+		return ErrInvalidValue
+	}
+	if pending {
+		m.Unlock()
+		return nil
+	}
+	m.redialAttempt = 0
+	m.startDial(number)
+	stCtx := m.stCtx
+	timers := m.dialTimers()
+	m.Unlock()
+
+	dialCtx, cancel := context.WithCancel(stCtx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	err := m.processDialing(dialCtx, number, timers)
+	if err != nil && ctx.Err() != nil {
+		m.Lock()
+		if m.stCtx == stCtx {
+			m.setStatus(StatusIdle)
+		}
+		m.Unlock()
+		return ctx.Err()
+	}
+	return err
+}
+
+func (m *Modem) ringback(rings int) {
+	if m.status() != StatusDialing {
+		return
+	}
+	m.printRetCode(m.xLevelResult(RetCodeRinging))
+	m.emit(ModemEvent{Type: EventRingback, Rings: rings})
+}
+
+// Ringback reports that the dialed remote side is ringing (rings is the remote ring count).
+// Outgoing call hooks call it while placing the call, it sends RINGING to the DTE when
+// enabled by the ATX level. Ignored when the modem is not dialing. Modem lock must be held.
+func (m *Modem) Ringback(rings int) {
+	m.checkLock()
+	m.ringback(rings)
+}
+
+// RingbackSync reports that the dialed remote side is ringing. Modem lock is acquired and released.
+func (m *Modem) RingbackSync(rings int) {
+	m.Lock()
+	defer m.Unlock()
+	m.ringback(rings)
+}
+
+func (m *Modem) answer() error {
+	if m.status() != StatusRinging {
+		return ErrNoCarrier
+	}
+	if m.handshaking {
+		return nil
+	}
+	if m.handshakeTime <= 0 {
+		m.setStatus(StatusConnected)
+		return nil
+	}
+	m.handshaking = true
+	ctx := m.deferCommand()
+	m.goTask(func() { m.answerHandshake(ctx) })
+	return nil
+}
+
+// answerHandshake connects the answered call after the handshake time. Any key aborts
+// the handshake hanging up the call, like on dialing.
+func (m *Modem) answerHandshake(ctx context.Context) {
+	done := clockSleep(ctx, m.clock, m.handshakeTime)
+	m.Lock()
+	defer m.Unlock()
+	if !m.handshaking { // hung up or closed meanwhile
+		return
+	}
+	if !done {
+		m.setStatus(StatusIdle)
+		m.printRetCode(RetCodeNoCarrier)
+		return
+	}
+	m.setStatus(StatusConnected)
+}
+
+// Answer answers a ringing incoming call like ATA, it connects after the HandshakeTime.
+// Modem lock must be held.
+func (m *Modem) Answer() error {
+	m.checkLock()
+	return m.answer()
+}
+
+// AnswerSync answers a ringing incoming call like ATA, it connects after the HandshakeTime.
+// Modem lock is acquired and released.
+func (m *Modem) AnswerSync() error {
+	m.Lock()
+	defer m.Unlock()
+	return m.answer()
+}
+
+func (m *Modem) deferCommand() context.Context {
+	if m.cmdCtxCancel != nil {
+		m.cmdCtxCancel()
+	}
+	m.cmdPending = true
+	m.cmdCtx, m.cmdCtxCancel = context.WithCancel(m.stCtx)
+	return m.cmdCtx
+}
+
+// DeferCommand marks the command being processed as long-running and returns a context
+// that is canceled when the command is aborted from the TTY or the modem status changes.
+// The command hook must return RetCodeSilent and call CompleteCommand when done.
+// Modem lock must be held.
+func (m *Modem) DeferCommand() context.Context {
+	m.checkLock()
+	return m.deferCommand()
+}
+
+func (m *Modem) completeCommand(ret RetCode) {
+	if m.cmdCtxCancel != nil {
+		m.cmdCtxCancel()
+		m.cmdCtxCancel = nil
+	}
+	m.cmdPending = false
+	m.printRetCode(ret)
+}
+
+// CompleteCommand finishes a deferred command printing its result code. Modem lock must be held.
+func (m *Modem) CompleteCommand(ret RetCode) {
+	m.checkLock()
+	m.completeCommand(ret)
+}
+
+// CompleteCommandSync finishes a deferred command printing its result code. Modem lock is acquired and released.
+func (m *Modem) CompleteCommandSync(ret RetCode) {
+	m.Lock()
+	defer m.Unlock()
+	m.completeCommand(ret)
+}
+
+func (m *Modem) abortCommand() {
+	if m.cmdCtxCancel != nil {
+		m.cmdCtxCancel()
+		m.cmdCtxCancel = nil
+	}
+	m.cmdPending = false
+}
+
+func (m *Modem) isAbortChar(b byte) bool {
+	return m.abortChar == "" || b == m.abortChar[0]
+}
+
+func parseIntList(s string) ([]int, error) {
+	ret := []int{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			ret = append(ret, -1) // omitted parameter, keep current value
+			continue
+		}
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, v)
+	}
+	return ret, nil
+}
+
+func (m *Modem) resetDteInterface() {
+	m.portRate = 0
+	m.icfFormat = 3
+	m.icfParity = 3
+	m.ifcDceByDte = 2
+	m.ifcDteByDce = 2
+}
+
+func (m *Modem) processDteCommand(cmdChar string, cmdAssign bool, cmdQuery bool, cmdAssignVal string) RetCode {
+	if cmdAssign && cmdQuery { // test command
+		switch cmdChar {
+		case "+IPR":
+			rates := []string{}
+			for _, r := range validPortRates {
+				rates = append(rates, strconv.Itoa(r))
+			}
+			m.respond("+IPR: (),(" + strings.Join(rates, ",") + ")")
+		case "+ICF":
+			m.respond("+ICF: (1-6),(0-3)")
+		case "+IFC":
+			m.respond("+IFC: (0-3),(0-2)")
+		}
+		return RetCodeOk
+	}
+	if cmdQuery || !cmdAssign {
+		switch cmdChar {
+		case "+IPR":
+			m.respond(fmt.Sprintf("+IPR: %d", m.portRate))
+		case "+ICF":
+			m.respond(fmt.Sprintf("+ICF: %d,%d", m.icfFormat, m.icfParity))
+		case "+IFC":
+			m.respond(fmt.Sprintf("+IFC: %d,%d", m.ifcDceByDte, m.ifcDteByDce))
+		}
+		return RetCodeOk
+	}
+	vals, err := parseIntList(cmdAssignVal)
+	if err != nil || len(vals) > 2 {
+		return RetCodeError
+	}
+	switch cmdChar {
+	case "+IPR":
+		if len(vals) != 1 || !slices.Contains(validPortRates, vals[0]) {
+			return RetCodeError
+		}
+		m.portRate = vals[0]
+	case "+ICF":
+		format, parity := m.icfFormat, m.icfParity
+		if vals[0] >= 0 {
+			format = vals[0]
+		}
+		if len(vals) > 1 && vals[1] >= 0 {
+			parity = vals[1]
+		}
+		if format < 1 || format > 6 || parity < 0 || parity > 3 {
+			return RetCodeError
+		}
+		m.icfFormat, m.icfParity = format, parity
+	case "+IFC":
+		dceByDte, dteByDce := m.ifcDceByDte, m.ifcDteByDce
+		if vals[0] >= 0 {
+			dceByDte = vals[0]
+		}
+		if len(vals) > 1 && vals[1] >= 0 {
+			dteByDce = vals[1]
+		}
+		if dceByDte < 0 || dceByDte > 3 || dteByDce < 0 || dteByDce > 2 {
+			return RetCodeError
+		}
+		m.ifcDceByDte, m.ifcDteByDce = dceByDte, dteByDce
+	}
+	return RetCodeOk
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (m *Modem) quietLevel() int {
+	if m.quietAnswer {
+		return 2
+	}
+	return boolToInt(m.quietMode)
+}
+
+func (m *Modem) printProfile() {
+	out := m.cr() + "ACTIVE PROFILE:" + m.eol()
+	out += fmt.Sprintf("E%d Q%d V%d X%d &C%d &D%d &T%d \\V%d", boolToInt(m.echo), m.quietLevel(), boolToInt(!m.shortForm), m.xLevel, m.dcdMode, m.dtrMode, 5-boolToInt(m.rdlGrant.Load()), boolToInt(m.protocolResults)) + m.eol()
+	out += fmt.Sprintf("S00:%03d S02:%03d S03:%03d S04:%03d S05:%03d S06:%03d", m.sregs[0], m.sregs[2], m.sregs[3], m.sregs[4], m.sregs[5], m.sregs[6]) + m.eol()
+	out += fmt.Sprintf("S07:%03d S08:%03d S10:%03d S12:%03d S30:%03d", m.sregs[7], m.sregs[8], m.sregs[10], m.sregs[12], m.sregs[30]) + m.eol()
+	out += fmt.Sprintf("+IPR:%d +ICF:%d,%d +IFC:%d,%d", m.portRate, m.icfFormat, m.icfParity, m.ifcDceByDte, m.ifcDteByDce) + m.eol()
+	if slices.ContainsFunc(m.storedNumbers, func(s string) bool { return s != "" }) {
+		out += m.eol() + "STORED PHONE NUMBERS:" + m.eol()
+		for i, number := range m.storedNumbers {
+			out += fmt.Sprintf("&Z%d=%s", i, number) + m.eol()
+		}
+	}
+	m.ttyWriteStr(out)
+}
+
+func (m *Modem) processCommand(p *CommandParams) RetCode {
+	cmdChar, cmdNum, cmdAssign, cmdQuery, cmdAssignVal := p.Name, p.Num, p.Assign, p.Query, p.Value
+	m.metrics.countCommand(cmdChar)
+	if m.commandHook != nil {
+		r := m.commandHook(m, cmdChar, cmdNum, cmdAssign, cmdQuery, cmdAssignVal)
+		if r != RetCodeSkip {
+			m.metrics.hookHandledCmds.Add(1)
+			return r
+		}
+	}
+	if m.paramsHook != nil {
+		if r := m.paramsHook(m, p); r != RetCodeSkip {
+			m.metrics.hookHandledCmds.Add(1)
+			return r
+		}
+	}
+	if r, ok := m.runRegisteredCommand(p); ok && r != RetCodeSkip {
+		return r
+	}
+	switch cmdChar {
+	case "S":
+		return m.sregCommand(cmdNum, cmdAssign, cmdQuery, cmdAssignVal)
+	case "E":
+		n, _ := strconv.Atoi(cmdNum)
+		switch n {
+		case 0:
+			m.echo = false
+		case 1:
+			m.echo = true
+		default:
+			return RetCodeError
+		}
+	case "V":
+		n, _ := strconv.Atoi(cmdNum)
+		switch n {
+		case 0:
+			m.shortForm = true
+		case 1:
+			m.shortForm = false
+		default:
+			return RetCodeError
+		}
+	case "D":
+		if m.status() != StatusIdle {
+			return RetCodeError
+		}
+		if m.outgoingCallCtx != nil || m.outgoingCall != nil {
+			number, pending, ok := m.prepareDial(cmdAssignVal)
+			if !ok {
+				return RetCodeError
+			}
+			if pending {
+				return RetCodeOk
+			}
+			m.redialAttempt = 0
+			m.startDial(number)
+			ctx, timers := m.stCtx, m.dialTimers()
+			m.goTask(func() { m.processDialing(ctx, number, timers) })
+			return RetCodeSilent
+		}
+		return RetCodeNoCarrier
+	case "A":
+		if m.status() == StatusIdle {
+			return RetCodeNoCarrier
+		}
+		if m.status() != StatusRinging {
+			return RetCodeError
+		}
+		m.answer()
+		return RetCodeSilent
+	case "H":
+		m.pendingDial = ""
+		if m.status() == StatusConnected || m.status() == StatusConnectedCmd {
+			m.setStatus(StatusIdle)
+			return RetCodeSilent
+		}
+	case "O":
+		if m.status() != StatusConnectedCmd {
+			return RetCodeError
+		}
+		m.setStatus(StatusConnected)
+		return RetCodeSilent
+	case "Q":
+		n, _ := strconv.Atoi(cmdNum)
+		switch n {
+		case 0:
+			m.quietMode = false
+			m.quietAnswer = false
+		case 1:
+			m.quietMode = true
+			m.quietAnswer = false
+		case 2: // quiet only when answering
+			m.quietMode = false
+			m.quietAnswer = true
+		default:
+			return RetCodeError
+		}
+	case "X":
+		n, _ := strconv.Atoi(cmdNum)
+		if n < 0 || n > 4 {
+			return RetCodeError
+		}
+		m.xLevel = n
+	case "&C": // DCD line, 0 = always on, 1 = follows carrier
+		n, _ := strconv.Atoi(cmdNum)
+		if n < 0 || n > 1 {
+			return RetCodeError
+		}
+		m.dcdMode = n
+		m.updateDCD()
+	case "&K": // local flow control: 0 = none, 3 = RTS/CTS, 4 = XON/XOFF, 5 = transparent XON/XOFF
+		n, _ := strconv.Atoi(cmdNum)
+		switch n {
+		case 0:
+			m.ifcDceByDte, m.ifcDteByDce = 0, 0
+		case 3:
+			m.ifcDceByDte, m.ifcDteByDce = 2, 2
+		case 4:
+			m.ifcDceByDte, m.ifcDteByDce = 1, 1
+		case 5:
+			m.ifcDceByDte, m.ifcDteByDce = 3, 1
+		default:
+			return RetCodeError
+		}
+	case "&T": // self tests and loopbacks
+		n, _ := strconv.Atoi(cmdNum)
+		return m.testCommand(n)
+	case "\\V": // protocol result codes: 0 = speed only, 1 = /ARQ and /V42BIS suffixes in CONNECT
+		n, _ := strconv.Atoi(cmdNum)
+		if n < 0 || n > 1 {
+			return RetCodeError
+		}
+		m.protocolResults = n == 1
+	case "\\B": // transmit a break to the remote side
+		if m.sendBreak() != nil {
+			return RetCodeError
+		}
+	case "&D": // DTR drop handling
+		n, _ := strconv.Atoi(cmdNum)
+		if n < 0 || n > 3 {
+			return RetCodeError
+		}
+		m.dtrMode = n
+	case "#LAT": // latency mode, 1 = interactive, 0 = bulk
+		if cmdQuery {
+			if cmdAssign {
+				m.respond("#LAT: (0,1)")
+			} else {
+				m.respond(fmt.Sprintf("#LAT: %d", boolToInt(!m.bulkMode)))
+			}
+			return RetCodeOk
+		}
+		switch cmdAssignVal {
+		case "0":
+			m.setBulkMode(true)
+		case "1":
+			m.setBulkMode(false)
+		default:
+			return RetCodeError
+		}
+	case "%L", "%Q": // line signal level and quality
+		if m.status() != StatusConnected && m.status() != StatusConnectedCmd {
+			return RetCodeError
+		}
+		lq := m.effectiveLinkQuality()
+		if lq == nil {
+			lq = &LinkQuality{}
+		}
+		v := lq.SignalLevel()
+		if cmdChar == "%Q" {
+			v = lq.SignalQuality()
+		}
+		m.respond(fmt.Sprintf("%03d", v))
+	case "+VCID": // caller ID reporting
+		if cmdQuery {
+			if cmdAssign {
+				m.respond("+VCID: (0,1)")
+			} else {
+				m.respond(fmt.Sprintf("+VCID: %d", m.callerIdMode))
+			}
+			return RetCodeOk
+		}
+		switch cmdAssignVal {
+		case "0":
+			m.callerIdMode = 0
+		case "1":
+			m.callerIdMode = 1
+		default:
+			return RetCodeError
+		}
+	case "+TELNET":
+		if cmdQuery {
+			if cmdAssign {
+				m.respond("+TELNET: (0,1)")
+			} else {
+				m.respond(fmt.Sprintf("+TELNET: %d", boolToInt(m.telnet)))
+			}
+			return RetCodeOk
+		}
+		switch cmdAssignVal {
+		case "0":
+			m.telnet = false
+		case "1":
+			m.telnet = true
+		default:
+			return RetCodeError
+		}
+	case "#LQ": // link quality preset
+		if cmdQuery {
+			if cmdAssign {
+				m.respond("#LQ: (" + strings.Join(LinkPresetNames(), ",") + ")")
+			} else {
+				name := "none"
+				if lq := m.effectiveLinkQuality(); lq != nil {
+					name = lq.Name
 				}
+				m.respond("#LQ: " + name)
+			}
+			return RetCodeOk
+		}
+		name := strings.ToLower(strings.TrimSpace(cmdAssignVal))
+		if name == "" || name == "none" {
+			m.setLinkQuality(nil)
+			return RetCodeOk
+		}
+		lq, err := LinkPreset(name)
+		if err != nil {
+			return RetCodeError
+		}
+		m.setLinkQuality(lq)
+	case "I":
+		n, _ := strconv.Atoi(cmdNum)
+		lines, ok := m.identityInfo(n)
+		if !ok {
+			return RetCodeError
+		}
+		if len(lines) > 0 {
+			m.respond(strings.Join(lines, m.eol()))
+		}
+	case "+GMI", "+GMM", "+GMR", "+GSN":
+		if cmdAssign {
+			if cmdQuery && cmdAssignVal == "" { // test command
+				return RetCodeOk
 			}
+			return RetCodeError
+		}
+		val := ""
+		switch cmdChar {
+		case "+GMI":
+			val = m.identity.Manufacturer
+		case "+GMM":
+			val = m.identity.Model
+		case "+GMR":
+			val = m.identity.Revision
+		case "+GSN":
+			val = m.identity.SerialNumber
+		}
+		m.respond(val)
+	case "+IPR", "+ICF", "+IFC":
+		return m.processDteCommand(cmdChar, cmdAssign, cmdQuery, cmdAssignVal)
+	case "&V":
+		m.printProfile()
+	case "&W":
+		n, _ := strconv.Atoi(cmdNum)
+		if m.saveProfile(n) != nil {
+			return RetCodeError
+		}
+	case "&Y":
+		n, _ := strconv.Atoi(cmdNum)
+		if m.setPowerOnProfile(n) != nil {
+			return RetCodeError
+		}
+	case "&Z":
+		n, _ := strconv.Atoi(cmdNum)
+		if n < 0 || n >= storedNumberSlots {
+			return RetCodeError
+		}
+		if cmdQuery {
+			m.ttyWriteStr(m.cr() + m.storedNumber(n) + m.eol())
+			break
+		}
+		if !cmdAssign || m.storeNumber(n, cmdAssignVal) != nil {
+			return RetCodeError
+		}
+	case "&F", "Z":
+		n, _ := strconv.Atoi(cmdNum)
+		if cmdChar == "Z" && (n < 0 || n >= profileSlots) {
+			return RetCodeError
+		}
+		m.factoryReset()
+		if cmdChar == "Z" {
+			m.loadProfile(n)
+		}
+		if m.status() == StatusConnected || m.status() == StatusConnectedCmd {
+			m.setStatus(StatusIdle)
+			return RetCodeSilent
+		}
+	}
+	return RetCodeOk
+}
+
+func (m *Modem) processAtCommand(cmd string) RetCode {
+	m.cancelRedial()
+	endSpan := m.traceCommand(cmd)
+	ret := m.processAtCommandLine(cmd)
+	endSpan(ret)
+	m.log.Debug("command", "line", cmd, "result", ret.String())
+	m.recordCommand(cmd, ret)
+	m.emit(ModemEvent{Type: EventCommand, Command: cmd, Result: ret})
+	return ret
+}
+
+func (m *Modem) processAtCommandLine(cmd string) RetCode {
+	if m.status() != StatusIdle && m.status() != StatusConnectedCmd && m.status() != StatusRinging {
+		return RetCodeError
+	}
+	m.storeNow(&m.metrics.lastAtCmd)
+	cmds, err := ParseCommandLine(cmd)
+	if err != nil {
+		m.parseError(cmd, err.(*ParseError))
+		m.metrics.cmdErrors.Add(1)
+		return RetCodeError
+	}
+	cmdRet := RetCodeOk
+	for _, p := range cmds {
+		cmdRet = m.processCommand(p)
+		if cmdRet == RetCodeError {
+			break
+		}
+	}
+	if cmdRet == RetCodeError {
+		m.metrics.cmdErrors.Add(1)
+	}
+	return cmdRet
+}
+
+// parseError logs a malformed command line and, with ParseDiagnostics, shows where it failed
+func (m *Modem) parseError(cmd string, err *ParseError) {
+	m.log.Debug("command syntax error", "line", cmd, "pos", err.Pos, "error", err.Msg)
+	if m.parseDiagnostics {
+		m.respond("AT" + cmd + m.eol() + strings.Repeat(" ", err.Pos+2) + "^ " + err.Msg)
+	}
+}
+
+func (m *Modem) ProcessAtCommand(cmd string) RetCode {
+	m.checkLock()
+	return m.processAtCommand(cmd)
+}
+
+func (m *Modem) ProcessAtCommandSync(cmd string) RetCode {
+	m.Lock()
+	defer m.Unlock()
+	return m.processAtCommand(cmd)
+}
+
+func (m *Modem) setTransparent(enable bool) error {
+	if enable && m.status() != StatusConnected && m.status() != StatusConnectedCmd {
+		return ErrInvalidStateTransition
+	}
+	m.transparent = enable
+	if enable {
+		m.xon() // a held XOFF would never be released
+	}
+	return nil
+}
+
+// SetTransparent enables or disables transparent binary mode for the current call.
+// In transparent mode escape sequence detection and software flow control are disabled so
+// the data path is 8-bit clean. It is cleared when the call ends, the next call starts
+// escape-capable. ModemConfig.Transparent negotiates it with vmodem peers at connect.
+// Modem lock must be held.
+func (m *Modem) SetTransparent(enable bool) error {
+	m.checkLock()
+	return m.setTransparent(enable)
+}
+
+// SetTransparentSync enables or disables transparent binary mode for the current call.
+// Modem lock is acquired and released.
+func (m *Modem) SetTransparentSync(enable bool) error {
+	m.Lock()
+	defer m.Unlock()
+	return m.setTransparent(enable)
+}
+
+// Transparent reports whether transparent binary mode is active. Modem lock must be held.
+func (m *Modem) Transparent() bool {
+	m.checkLock()
+	return m.transparent
+}
+
+// TransparentSync reports whether transparent binary mode is active. Modem lock is acquired and released.
+func (m *Modem) TransparentSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.transparent
+}
+
+func (m *Modem) effectiveLinkQuality() *LinkQuality {
+	if m.callLinkQuality != nil {
+		return m.callLinkQuality
+	}
+	return m.linkQuality
+}
+
+func (m *Modem) updateCallLinkQuality() {
+	if m.impaired != nil {
+		lq := m.effectiveLinkQuality()
+		if lq == nil {
+			lq = &LinkQuality{}
+		}
+		m.impaired.setLinkQuality(*lq)
+	}
+}
+
+func (m *Modem) setLinkQuality(lq *LinkQuality) {
+	m.linkQuality = lq
+	m.updateCallLinkQuality()
+}
+
+// SetLinkQuality sets the line impairments applied to every call of the modem (nil = none).
+// An active call is updated only if it was established with impairments.
+// Modem lock must be held.
+func (m *Modem) SetLinkQuality(lq *LinkQuality) {
+	m.checkLock()
+	m.setLinkQuality(lq)
+}
+
+// SetLinkQualitySync sets the line impairments applied to every call of the modem (nil = none).
+// Modem lock is acquired and released.
+func (m *Modem) SetLinkQualitySync(lq *LinkQuality) {
+	m.Lock()
+	defer m.Unlock()
+	m.setLinkQuality(lq)
+}
+
+func (m *Modem) setCallLinkQuality(lq *LinkQuality) {
+	m.callLinkQuality = lq
+	m.updateCallLinkQuality()
+}
+
+// SetCallLinkQuality overrides the line impairments for the current (or next) call only.
+// It can be called from the OutgoingCall hook or before IncomingCall to select them per call.
+// Modem lock must be held.
+func (m *Modem) SetCallLinkQuality(lq *LinkQuality) {
+	m.checkLock()
+	m.setCallLinkQuality(lq)
+}
 
-			if cmdChar == "" || cmdChar == "&" || cmdChar == "%" {
-				if (b == '&' || b == '%') && cmdChar == "" && cmdBuf.Len() > 0 {
-					cmdChar += string(b)
-					continue
-				}
-				if checkValidCmdChar(b) {
-					cmdChar += string(b)
-					if cmdChar == "d" || cmdChar == "D" {
-						cmdLong = true
-						cmdAssign = true
-					}
-				} else {
-					e = true
-					break
-				}
-			} else {
-				if checkValidNumChar(b) {
-					cmdNum += string(b)
-				} else {
-					cmdBuf.UnreadByte()
-					break
-				}
-			}
+// SetCallLinkQualitySync overrides the line impairments for the current (or next) call only.
+// Modem lock is acquired and released.
+func (m *Modem) SetCallLinkQualitySync(lq *LinkQuality) {
+	m.Lock()
+	defer m.Unlock()
+	m.setCallLinkQuality(lq)
+}
+
+// LinkQuality returns the line impairments in effect (nil = none). Modem lock must be held.
+func (m *Modem) LinkQuality() *LinkQuality {
+	m.checkLock()
+	return m.effectiveLinkQuality()
+}
+
+// LinkQualitySync returns the line impairments in effect (nil = none). Modem lock is acquired and released.
+func (m *Modem) LinkQualitySync() *LinkQuality {
+	m.Lock()
+	defer m.Unlock()
+	return m.effectiveLinkQuality()
+}
+
+func (m *Modem) setDTR(dtr bool) {
+	prev := m.dtr
+	m.dtr = dtr
+	if !prev || dtr || m.status() == StatusClosed {
+		return
+	}
+	switch m.dtrMode {
+	case 0: // ignored
+	case 1: // back to command mode keeping the call
+		if m.status() == StatusConnected {
+			m.setStatus(StatusConnectedCmd)
 		}
-		if !e {
-			cmdRet = m.processCommand(strings.ToUpper(cmdChar), cmdNum, cmdAssign, cmdQuery, cmdAssignVal)
-			if cmdRet == RetCodeError {
-				break
-			}
+	default: // hang up, also resetting with &D3
+		if m.status() == StatusConnected || m.status() == StatusConnectedCmd {
+			m.hangupReason = DisconnectDTR
+		}
+		if m.status() != StatusIdle {
+			m.setStatus(StatusIdle)
 		}
-		if cmdLong {
-			break // long commands don't support chaining
+		if m.dtrMode == 3 {
+			m.factoryReset()
+			m.loadProfile(m.powerOnProfile)
 		}
 	}
+}
+
+// SetDTR sets the DTR (Data Terminal Ready) line state driven by the DTE.
+// Dropping DTR acts as set with AT&D: ignored (&D0), returns to command mode keeping
+// the call (&D1), hangs up (&D2, default) or hangs up and resets (&D3).
+// Modem lock must be held.
+func (m *Modem) SetDTR(dtr bool) {
+	m.checkLock()
+	m.setDTR(dtr)
+}
+
+// SetDTRSync sets the DTR (Data Terminal Ready) line state driven by the DTE. Modem lock is acquired and released.
+func (m *Modem) SetDTRSync(dtr bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.setDTR(dtr)
+}
+
+// DTR returns the DTR line state (asserted by default). Modem lock must be held.
+func (m *Modem) DTR() bool {
+	m.checkLock()
+	return m.dtr
+}
+
+// DTRSync returns the DTR line state (asserted by default). Modem lock is acquired and released.
+func (m *Modem) DTRSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.dtr
+}
+
+// SetDTEPresent reports whether a DTE is attached (present by default), refusing incoming calls
+// with BusyOnNoDTE while absent. Unlike DTR it does not affect calls. Modem lock must be held.
+func (m *Modem) SetDTEPresent(present bool) {
+	m.checkLock()
+	m.dteAbsent = !present
+}
+
+// SetDTEPresentSync reports whether a DTE is attached. Modem lock is acquired and released.
+func (m *Modem) SetDTEPresentSync(present bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.dteAbsent = !present
+}
+
+// SetEcho enables or disables command mode echo (same as ATE). Modem lock must be held.
+func (m *Modem) SetEcho(enable bool) {
+	m.checkLock()
+	m.echo = enable
+}
+
+// SetEchoSync enables or disables command mode echo. Modem lock is acquired and released.
+func (m *Modem) SetEchoSync(enable bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.echo = enable
+}
+
+// Echo reports whether command mode echo is enabled. Modem lock must be held.
+func (m *Modem) Echo() bool {
+	m.checkLock()
+	return m.echo
+}
+
+// EchoSync reports whether command mode echo is enabled. Modem lock is acquired and released.
+func (m *Modem) EchoSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.echo
+}
+
+// SetQuietMode enables or disables result codes (same as ATQ1/ATQ0, also ending ATQ2).
+// Modem lock must be held.
+func (m *Modem) SetQuietMode(enable bool) {
+	m.checkLock()
+	m.quietMode = enable
+	m.quietAnswer = false
+}
+
+// SetQuietModeSync enables or disables result codes (same as ATQ1/ATQ0, also ending ATQ2).
+// Modem lock is acquired and released.
+func (m *Modem) SetQuietModeSync(enable bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.quietMode = enable
+	m.quietAnswer = false
+}
+
+// QuietMode reports whether result codes are suppressed. Modem lock must be held.
+func (m *Modem) QuietMode() bool {
+	m.checkLock()
+	return m.quietMode
+}
+
+// QuietModeSync reports whether result codes are suppressed. Modem lock is acquired and released.
+func (m *Modem) QuietModeSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.quietMode
+}
+
+// SetVerbose selects verbal (true) or numeric (false) result codes (same as ATV). Modem lock must be held.
+func (m *Modem) SetVerbose(enable bool) {
+	m.checkLock()
+	m.shortForm = !enable
+}
+
+// SetVerboseSync selects verbal (true) or numeric (false) result codes. Modem lock is acquired and released.
+func (m *Modem) SetVerboseSync(enable bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.shortForm = !enable
+}
+
+// Verbose reports whether result codes are verbal. Modem lock must be held.
+func (m *Modem) Verbose() bool {
+	m.checkLock()
+	return !m.shortForm
+}
+
+// VerboseSync reports whether result codes are verbal. Modem lock is acquired and released.
+func (m *Modem) VerboseSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return !m.shortForm
+}
+
+func (m *Modem) setConnectStr(s string) {
+	if s == "" {
+		s = "CONNECT"
+	}
+	m.connectStr = s
+}
+
+// SetConnectStr sets the verbal result reported on connect ("" restores "CONNECT").
+// A speed in the string is also used as the reported connect speed. Modem lock must be held.
+func (m *Modem) SetConnectStr(s string) {
+	m.checkLock()
+	m.setConnectStr(s)
+}
 
-	if e {
-		cmdRet = RetCodeError
+// SetConnectStrSync sets the verbal result reported on connect ("" restores "CONNECT").
+// Modem lock is acquired and released.
+func (m *Modem) SetConnectStrSync(s string) {
+	m.Lock()
+	defer m.Unlock()
+	m.setConnectStr(s)
+}
+
+// ConnectStr returns the verbal result reported on connect. Modem lock must be held.
+func (m *Modem) ConnectStr() string {
+	m.checkLock()
+	return m.connectStr
+}
+
+// ConnectStrSync returns the verbal result reported on connect. Modem lock is acquired and released.
+func (m *Modem) ConnectStrSync() string {
+	m.Lock()
+	defer m.Unlock()
+	return m.connectStr
+}
+
+func (m *Modem) effectiveTelnet() bool {
+	if m.callTelnet != nil {
+		return *m.callTelnet
 	}
-	return cmdRet
+	return m.telnet
 }
 
-func (m *Modem) ProcessAtCommand(cmd string) RetCode {
+// SetTelnet enables or disables Telnet protocol on the calls of the modem (same as AT+TELNET).
+// The setting applies from the next call. Modem lock must be held.
+func (m *Modem) SetTelnet(enable bool) {
 	m.checkLock()
-	return m.processAtCommand(cmd)
+	m.telnet = enable
 }
 
-func (m *Modem) ProcessAtCommandSync(cmd string) RetCode {
+// SetTelnetSync enables or disables Telnet protocol on the calls of the modem.
+// Modem lock is acquired and released.
+func (m *Modem) SetTelnetSync(enable bool) {
 	m.Lock()
 	defer m.Unlock()
-	return m.processAtCommand(cmd)
+	m.telnet = enable
+}
+
+// SetCallTelnet overrides the Telnet setting for the next call only. It can be called from
+// the OutgoingCall hook or before IncomingCall to select Telnet per call. Modem lock must be held.
+func (m *Modem) SetCallTelnet(enable bool) {
+	m.checkLock()
+	m.callTelnet = &enable
+}
+
+// SetCallTelnetSync overrides the Telnet setting for the next call only. Modem lock is acquired and released.
+func (m *Modem) SetCallTelnetSync(enable bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.callTelnet = &enable
+}
+
+func (m *Modem) applyLatencyMode() {
+	if lms, ok := m.conn.(LatencyModeSetter); ok {
+		lms.SetBulkMode(m.bulkMode)
+	}
+}
+
+func (m *Modem) setBulkMode(bulk bool) {
+	m.bulkMode = bulk
+	if m.conn != nil {
+		m.applyLatencyMode()
+	}
+}
+
+// SetBulkMode switches between bulk (coalesced writes) and interactive latency modes.
+// Modem lock must be held.
+func (m *Modem) SetBulkMode(bulk bool) {
+	m.checkLock()
+	m.setBulkMode(bulk)
 }
 
-func (m *Modem) Metrics() *Metrics {
+// SetBulkModeSync switches between bulk (coalesced writes) and interactive latency modes.
+// Modem lock is acquired and released.
+func (m *Modem) SetBulkModeSync(bulk bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.setBulkMode(bulk)
+}
+
+// BulkMode reports whether bulk latency mode is active. Modem lock must be held.
+func (m *Modem) BulkMode() bool {
+	m.checkLock()
+	return m.bulkMode
+}
+
+// BulkModeSync reports whether bulk latency mode is active. Modem lock is acquired and released.
+func (m *Modem) BulkModeSync() bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.bulkMode
+}
+
+// PortRate returns the DTE port rate set by +IPR (0 = autobaud). Modem lock must be held.
+func (m *Modem) PortRate() int {
+	m.checkLock()
+	return m.portRate
+}
+
+// PortRateSync returns the DTE port rate set by +IPR (0 = autobaud). Modem lock is acquired and released.
+func (m *Modem) PortRateSync() int {
+	m.Lock()
+	defer m.Unlock()
+	return m.portRate
+}
+
+func (m *Modem) framing() (format int, parity int) {
+	return m.icfFormat, m.icfParity
+}
+
+// Framing returns the character framing format and parity set by +ICF. Modem lock must be held.
+func (m *Modem) Framing() (format int, parity int) {
+	m.checkLock()
+	return m.framing()
+}
+
+// FramingSync returns the character framing format and parity set by +ICF. Modem lock is acquired and released.
+func (m *Modem) FramingSync() (format int, parity int) {
+	m.Lock()
+	defer m.Unlock()
+	return m.framing()
+}
+
+// XON/XOFF flow control characters (DC1/DC3)
+const (
+	charXON  = 0x11
+	charXOFF = 0x13
+)
+
+// softFlowControl interprets XON/XOFF sent by the DTE in online mode when enabled with
+// +IFC=1 or +IFC=3 (AT&K4/&K5): XOFF holds the data forwarded to the TTY until XON.
+// Returns the data to forward to the connection, without XON/XOFF with +IFC=1. Transparent
+// mode calls are 8-bit clean, XON/XOFF are data.
+func (m *Modem) softFlowControl(b []byte) []byte {
+	if (m.ifcDceByDte != 1 && m.ifcDceByDte != 3) || m.transparent {
+		return b
+	}
+	strip := m.ifcDceByDte == 1
+	var out []byte // stripped copy, allocated on the first flow control character
+	for i, c := range b {
+		switch c {
+		case charXOFF:
+			if m.xoff.Load() == nil {
+				xoff := make(chan struct{})
+				m.xoff.Store(&xoff)
+			}
+		case charXON:
+			m.xon()
+		default:
+			if out != nil {
+				out = append(out, c)
+			}
+			continue
+		}
+		if strip && out == nil {
+			out = append(make([]byte, 0, len(b)), b[:i]...)
+		}
+	}
+	if out == nil {
+		return b
+	}
+	return out
+}
+
+// xon resumes the data forwarded to the TTY held by XOFF
+func (m *Modem) xon() {
+	if xoff := m.xoff.Swap(nil); xoff != nil {
+		close(*xoff)
+	}
+}
+
+func (m *Modem) flowControl() (dceByDte int, dteByDce int) {
+	return m.ifcDceByDte, m.ifcDteByDce
+}
+
+// FlowControl returns the local flow control methods set by +IFC. Modem lock must be held.
+func (m *Modem) FlowControl() (dceByDte int, dteByDce int) {
 	m.checkLock()
-	copy := *m.metrics
-	copy.Status = m.status()
-	return &copy
+	return m.flowControl()
 }
 
-func (m *Modem) MetricsSync() *Metrics {
+// FlowControlSync returns the local flow control methods set by +IFC. Modem lock is acquired and released.
+func (m *Modem) FlowControlSync() (dceByDte int, dteByDce int) {
 	m.Lock()
 	defer m.Unlock()
-	return m.Metrics()
+	return m.flowControl()
 }
 
 func (m *Modem) ttyReadTask() {
+	defer m.ttyTaskAlive.Store(false)
 	aFlag := false
 	atFlag := false
 	buffer := *bytes.NewBuffer(nil)
-	byteBuff := make([]byte, 1)
+	readBuff := make([]byte, m.readBufferSize)
 	lastCmd := ""
 	plusCnt := 0
 	lastPlus := time.Time{}
 	lastNotPlus := time.Time{}
+	ties := tiesDetector{}
+	overflow := 0 // Characters typed past the command buffer limit
 
 	m.Lock()
 	for m.status() != StatusClosed {
+		chunk := m.lineChunk()
 		m.Unlock()
-		n, err := m.tty.Read(byteBuff)
+		n, err := m.tty.Read(readBuff[:chunk])
 		m.Lock()
 		if m.status() == StatusClosed {
 			break
@@ -754,104 +2405,176 @@ func (m *Modem) ttyReadTask() {
 			m.setStatus(StatusClosed)
 			break
 		}
-		m.metrics.LastTtyRxTime = time.Now()
-		m.metrics.TtyRxBytes += n
-		if m.status() == StatusConnected { // online mode pass-through
-			m.metrics.ConnTxBytes += n
-			if m.conn != nil {
-				m.conn.Write(byteBuff)
-			}
-			if byteBuff[0] == '+' {
-				if !m.disablePreGuard {
-					if time.Since(lastNotPlus) < time.Duration(m.sregs[12])*50*time.Millisecond {
+		if m.ttyTap != nil {
+			m.ttyTap.Write(readBuff[:n])
+		}
+		m.storeNow(&m.metrics.lastTtyRx)
+		m.metrics.ttyRx.Add(int64(n))
+		data := readBuff[:n]
+		for len(data) > 0 && m.status() != StatusClosed {
+			if m.status() == StatusConnected { // online mode pass-through, forwarded in bulk
+				guardTime := time.Duration(m.sregs[12]) * 50 * time.Millisecond
+				escChar := m.sregs[2]
+				now := m.clock.Now() // the bytes of a read arrive together, time.Now per byte dominated the data path
+				k := 0
+				escape := false
+				tiesCmd := ""
+				for k < len(data) && !escape {
+					c := data[k]
+					k++
+					if m.transparent {
+						continue
+					}
+					if m.ties {
+						if escChar <= 127 {
+							tiesCmd, escape = ties.feed(c, escChar, m.sregs[3], m.cmdBufferLimit)
+						}
+						continue
+					}
+					if c != escChar || escChar > 127 {
 						plusCnt = 0
-						lastNotPlus = time.Now()
+						lastNotPlus = now
 						continue
 					}
+					if !m.disablePreGuard && now.Sub(lastNotPlus) < guardTime {
+						plusCnt = 0
+						lastNotPlus = now
+						continue
+					}
+					if now.Sub(lastPlus) > guardTime {
+						plusCnt = 0
+					}
+					plusCnt++
+					lastPlus = now
+					if plusCnt == 3 {
+						if m.disablePostGuard {
+							escape = true // remaining bytes are processed in command mode
+						} else {
+							ctx := m.stCtx
+							m.goTask(func() {
+								select {
+								case <-m.clock.After(guardTime):
+								case <-ctx.Done():
+									return
+								}
+								m.Lock()
+								defer m.Unlock()
+								if ctx.Err() != nil || plusCnt != 3 {
+									return
+								}
+								m.setStatus(StatusConnectedCmd)
+							})
+						}
+					}
 				}
-
-				if time.Since(lastPlus) > time.Duration(m.sregs[12])*50*time.Millisecond {
-					plusCnt = 0
+				out := m.softFlowControl(data[:k])
+				if m.rdlActive.Load() { // the line is looped back to the remote modem, the DTE is cut off
+					out = nil
 				}
-				plusCnt++
-				lastPlus = time.Now()
-				if plusCnt == 3 {
-					if m.disablePostGuard {
-						m.setStatus(StatusConnectedCmd)
-					} else {
-						go func(ctx context.Context) {
-							time.Sleep(time.Duration(m.sregs[12]) * 50 * time.Millisecond)
-							m.Lock()
-							defer m.Unlock()
-							if ctx.Err() != nil || plusCnt != 3 {
-								return
-							}
-							m.setStatus(StatusConnectedCmd)
-						}(m.stCtx)
+				m.countCallTx(len(out))
+				if conn := m.conn; conn != nil { // a slow remote side must not hold the modem lock
+					m.Unlock()
+					conn.Write(out)
+					m.Lock()
+				}
+				m.feedTaps(TapTx, out)
+				data = data[k:]
+				if escape {
+					m.setStatus(StatusConnectedCmd)
+					if m.ties {
+						lastCmd = tiesCmd
+						if m.throttle(m.cmdLimiter, 1) {
+							m.printRetCode(m.processAtCommand(tiesCmd))
+						}
 					}
 				}
-			} else {
-				plusCnt = 0
-				lastNotPlus = time.Now()
+				m.pace(m.lineTx, k)
+				continue
 			}
-			continue
-		} else {
 			plusCnt = 0
-		}
-
-		if m.status() == StatusDialing {
-			m.setStatus(StatusIdle)
-			continue
-		}
+			ties.reset()
+			byteBuff := data[:1]
+			data = data[1:]
 
-		if !atFlag {
-			if m.echo {
-				m.ttyWrite(byteBuff)
-			}
-			if bytes.ToUpper(byteBuff)[0] == 'A' {
-				aFlag = true
+			if m.status() == StatusDialing { // any key aborts the dial unless AbortChar restricts it
+				if m.isAbortChar(byteBuff[0]) {
+					m.setStatus(StatusIdle)
+				}
 				continue
 			}
-			if aFlag && byteBuff[0] == '/' {
-				aFlag = false
-				if m.echo {
-					m.ttyWriteStr("\r")
+
+			if m.cmdPending {
+				if m.isAbortChar(byteBuff[0]) {
+					m.abortCommand()
 				}
-				r := m.processAtCommand(lastCmd)
-				m.printRetCode(r)
 				continue
 			}
-			if aFlag && bytes.ToUpper(byteBuff)[0] == 'T' {
-				atFlag = true
+
+			if !atFlag {
+				if !m.echoWrite(byteBuff) {
+					aFlag = false
+					continue
+				}
+				if bytes.ToUpper(byteBuff)[0] == 'A' {
+					aFlag = true
+					continue
+				}
+				if aFlag && byteBuff[0] == '/' {
+					aFlag = false
+					if !m.echoWrite([]byte{m.sregs[3]}) || !m.throttle(m.cmdLimiter, 1) {
+						continue
+					}
+					r := m.processAtCommand(lastCmd)
+					m.printRetCode(r)
+					continue
+				}
+				if aFlag && bytes.ToUpper(byteBuff)[0] == 'T' {
+					atFlag = true
+					aFlag = false
+					continue
+				}
 				aFlag = false
-				continue
-			}
-			aFlag = false
-		} else {
-			if byteBuff[0] == 0x7f {
-				if buffer.Len() > 0 {
-					buffer.Truncate(buffer.Len() - 1)
-					if m.echo {
-						m.ttyWriteStr("\x1b[D \x1b[D")
+			} else {
+				if byteBuff[0] == m.sregs[5] || byteBuff[0] == 0x7f {
+					if overflow > 0 {
+						overflow--
+						m.echoWrite([]byte("\x1b[D \x1b[D"))
+					} else if buffer.Len() > 0 {
+						buffer.Truncate(buffer.Len() - 1)
+						m.echoWrite([]byte("\x1b[D \x1b[D"))
 					}
+					continue
 				}
-				continue
-			}
-			if byteBuff[0] == '\r' {
-				atFlag = false
-				lastCmd = buffer.String()
-				if m.echo {
-					m.ttyWriteStr("\r")
+				if byteBuff[0] == m.sregs[3] && overflow > 0 {
+					atFlag = false
+					length := buffer.Len() + overflow
+					buffer.Reset()
+					overflow = 0
+					if !m.echoWrite([]byte{m.sregs[3]}) {
+						continue
+					}
+					m.log.Debug("command line overflow", "limit", m.cmdBufferLimit, "length", length)
+					m.metrics.cmdErrors.Add(1)
+					m.printRetCode(RetCodeError)
+					continue
 				}
-				r := m.processAtCommand(lastCmd)
-				m.printRetCode(r)
-				buffer.Reset()
-				continue
-			}
-			if buffer.Len() < 100 && strconv.IsPrint(rune(byteBuff[0])) {
-				buffer.Write(byteBuff)
-				if m.echo {
-					m.ttyWrite(byteBuff)
+				if byteBuff[0] == m.sregs[3] {
+					atFlag = false
+					lastCmd = buffer.String()
+					buffer.Reset()
+					if !m.echoWrite([]byte{m.sregs[3]}) || !m.throttle(m.cmdLimiter, 1) {
+						continue // the modem status changed while throttled, the line is abandoned
+					}
+					r := m.processAtCommand(lastCmd)
+					m.printRetCode(r)
+					continue
+				}
+				if strconv.IsPrint(rune(byteBuff[0])) && m.echoWrite(byteBuff) {
+					if buffer.Len() < m.cmdBufferLimit {
+						buffer.Write(byteBuff)
+					} else {
+						overflow++ // the line fails with ERROR, like the buffer overflow of real modems
+					}
 				}
 			}
 		}
@@ -859,6 +2582,8 @@ func (m *Modem) ttyReadTask() {
 	m.Unlock()
 }
 
+// NewModem creates a modem from config, see NewModemWithOptions. Returns an error wrapping
+// ErrInvalidValue describing the first nonsensical setting (see ModemConfig.Validate).
 func NewModem(config *ModemConfig) (*Modem, error) {
 	if config == nil {
 		return nil, ErrConfigRequired
@@ -868,35 +2593,153 @@ func NewModem(config *ModemConfig) (*Modem, error) {
 		return nil, ErrConfigRequired
 	}
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	m := &Modem{
 		st:               StatusIdle,
 		id:               config.Id,
 		outgoingCall:     config.OutgoingCall,
+		outgoingCallCtx:  config.OutgoingCallCtx,
+		dialPlan:         config.DialPlan,
 		commandHook:      config.CommandHook,
+		paramsHook:       config.CommandParamsHook,
 		statusTransition: config.StatusTransition,
+		resultHook:       config.ResultHook,
+		errorHook:        config.ErrorHook,
+		readBufferSize:   config.ReadBufferSize,
+		writeBufferSize:  config.WriteBufferSize,
+		coalesceDelay:    config.CoalesceDelay,
+		lineSpeed:        config.LineSpeed,
+		callEnded:        config.CallEnded,
+		incomingFilter:   config.IncomingCallFilter,
+		idleTimeout:      config.IdleTimeout,
+		maxCallDuration:  config.MaxCallDuration,
 		tty:              config.TTY,
 		connectStr:       config.ConnectStr,
+		errorCorrection:  config.ErrorCorrection,
+		compression:      config.Compression,
+		protocolResults:  true,
+		defaultRDL:       config.RemoteLoopback,
+		binaryMode:       config.Transparent,
 		ringMax:          config.RingMax,
-		answerChar:       config.AnswerChar,
+		handshake:        config.Handshake,
+		abortChar:        config.AbortChar,
+		identity:         config.Identity,
 		disablePreGuard:  config.DisablePreGuard,
 		disablePostGuard: config.DisablePostGuard,
+		escapeChar:       byte(config.EscapeChar),
+		ties:             config.TIES,
+		sregPolicy:       config.SRegPolicy,
+		parseDiagnostics: config.ParseDiagnostics,
 		echo:             true,
+		xLevel:           4,
+		bulkMode:         config.BulkMode,
+		defaultBulkMode:  config.BulkMode,
+		linkQuality:      config.LinkQuality,
+		telnet:           config.Telnet,
+		defaultTelnet:    config.Telnet,
+		busyOnNoDTE:      config.BusyOnNoDTE,
+		profileStore:     config.ProfileStore,
+		autoAnswerRings:  config.AutoAnswerRings,
+		cmdHistoryLen:    config.CmdHistoryLen,
+		cmdBufferLimit:   config.CmdBufferLimit,
+		dtr:              true,
+		dtrMode:          2,
+		dcdMode:          1,
+		controlLines:     config.ControlLines,
+		breakHook:        config.BreakHook,
+		log:              config.Logger,
+		ttyTap:           config.TtyTap,
+		connTap:          config.ConnTap,
+		recordDir:        config.RecordSessions,
+		clock:            config.Clock,
+		handshakeTime:    config.HandshakeTime,
+		dialTimeout:      config.DialTimeout,
+		redial:           config.Redial,
+		preserveDialCase: config.PreserveDialCase,
+		pingInterval:     config.PingInterval,
+		pingTimeout:      config.PingTimeout,
+		tracer:           config.Tracer,
+		expvar:           config.Expvar,
+		closed:           make(chan struct{}),
 		sregs:            make(map[byte]byte),
-		metrics:          &Metrics{},
+		commands:         make(map[string]CommandHandler),
 	}
 
 	m.stCtx, m.stCtxCancel = context.WithCancel(context.Background())
 
-	if m.connectStr == "" {
-		m.connectStr = "CONNECT"
-	}
+	m.setConnectStr(m.connectStr)
 
+	if m.outgoingCall == nil && m.outgoingCallCtx == nil && m.dialPlan != nil {
+		m.outgoingCallCtx = TransportCall
+	}
+	if m.readBufferSize <= 0 {
+		m.readBufferSize = defaultReadBufferSize
+	}
+	if m.coalesceDelay <= 0 {
+		m.coalesceDelay = defaultCoalesceDelay
+	}
 	if m.ringMax == 0 {
 		m.ringMax = 5
 	}
 
+	if m.cmdHistoryLen == 0 {
+		m.cmdHistoryLen = 32
+	}
+
+	if m.cmdBufferLimit == 0 {
+		m.cmdBufferLimit = defaultCmdBufferLimit
+	}
+
+	if m.profileStore == nil {
+		m.profileStore = &MemoryProfileStore{} // AT&W keeps working, profiles are lost on exit
+	}
+
+	if m.identity.Manufacturer == "" {
+		m.identity.Manufacturer = "vmodem"
+	}
+
+	if m.identity.Model == "" {
+		m.identity.Model = "vmodem"
+	}
+
+	if m.identity.Revision == "" {
+		m.identity.Revision = "1.0"
+	}
+
+	if m.identity.SerialNumber == "" {
+		m.identity.SerialNumber = m.id
+	}
+
+	if m.log == nil {
+		m.log = slog.New(discardHandler{})
+	}
+	if m.clock == nil {
+		m.clock = systemClock{}
+	}
+	m.cmdLimiter = newRateLimiter(config.CmdRateLimit, m.clock)
+	m.echoLimiter = newRateLimiter(config.EchoRateLimit, m.clock)
+	if m.handshake == nil && config.AnswerChar != "" {
+		m.handshake = &Handshake{Token: config.AnswerChar[0:1]}
+	}
+	m.log = m.log.With("modem", m.id)
+
+	m.resetSRegs()
 	m.sregs[12] = byte(config.GuardTime)
+	m.resetDteInterface()
+	m.rdlGrant.Store(m.defaultRDL)
+	m.loadPowerOnProfile()
+	m.dcd = m.dcdLine()
+	if m.controlLines != nil {
+		m.controlLines.SetDCD(m.dcd)
+	}
 
-	go m.ttyReadTask()
+	m.ttyTaskAlive.Store(true)
+	if m.expvar {
+		m.publishExpvar()
+	}
+	m.goTask(m.ttyReadTask)
 	return m, nil
 }
@@ -0,0 +1,320 @@
+package vmodem
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket protocol (RFC 6455)
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+
+	wsMaxControlLen = 125
+)
+
+// WebSocketConn carries a byte stream over WebSocket (RFC 6455) messages. Text and binary
+// messages are read as data and data is written as binary messages.
+type WebSocketConn struct {
+	net.Conn
+	br        *bufio.Reader
+	client    bool // client side frames are masked
+	wmu       sync.Mutex
+	remaining uint64
+	masked    bool
+	mask      [4]byte
+	maskPos   int
+	closeOnce sync.Once
+	pong      chan struct{}
+}
+
+// NewWebSocketConn wraps an already upgraded connection. br holds data buffered during the
+// handshake (nil = none), client selects masked frames as required on the client side.
+func NewWebSocketConn(conn net.Conn, br *bufio.Reader, client bool) *WebSocketConn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &WebSocketConn{Conn: conn, br: br, client: client, pong: make(chan struct{}, 1)}
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// writeFrame sends a single frame. data is masked in place on the client side.
+func (ws *WebSocketConn) writeFrame(op byte, data []byte) error {
+	hdr := []byte{0x80 | op, 0}
+	maskBit := byte(0)
+	if ws.client {
+		maskBit = 0x80
+	}
+	switch l := len(data); {
+	case l <= wsMaxControlLen:
+		hdr[1] = maskBit | byte(l)
+	case l <= 0xffff:
+		hdr[1] = maskBit | 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(l))
+	default:
+		hdr[1] = maskBit | 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(l))
+	}
+	if ws.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		hdr = append(hdr, mask[:]...)
+		for i := range data {
+			data[i] ^= mask[i%4]
+		}
+	}
+	frame := append(hdr, data...)
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	_, err := ws.Conn.Write(frame)
+	return err
+}
+
+// readHeader reads frame headers answering control frames until a data frame arrives
+func (ws *WebSocketConn) readHeader() error {
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+			return err
+		}
+		op := hdr[0] & 0x0f
+		ws.masked = hdr[1]&0x80 != 0
+		length := uint64(hdr[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if ws.masked {
+			if _, err := io.ReadFull(ws.br, ws.mask[:]); err != nil {
+				return err
+			}
+		}
+		ws.maskPos = 0
+		switch op {
+		case wsOpContinuation, wsOpText, wsOpBinary:
+			ws.remaining = length
+			if length > 0 {
+				return nil
+			}
+			continue
+		}
+		if length > wsMaxControlLen {
+			return fmt.Errorf("websocket control frame too long")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(ws.br, payload); err != nil {
+			return err
+		}
+		if ws.masked {
+			for i := range payload {
+				payload[i] ^= ws.mask[i%4]
+			}
+		}
+		switch op {
+		case wsOpPing:
+			ws.writeFrame(wsOpPong, payload)
+		case wsOpPong:
+			select {
+			case ws.pong <- struct{}{}:
+			default:
+			}
+		case wsOpClose:
+			ws.closeOnce.Do(func() { ws.writeFrame(wsOpClose, payload) })
+			return io.EOF
+		}
+	}
+}
+
+// Read implements io.Reader returning the payload of data frames
+func (ws *WebSocketConn) Read(b []byte) (int, error) {
+	if ws.remaining == 0 {
+		if err := ws.readHeader(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(b)) > ws.remaining {
+		b = b[:ws.remaining]
+	}
+	n, err := ws.br.Read(b)
+	if ws.masked {
+		for i := range b[:n] {
+			b[i] ^= ws.mask[ws.maskPos%4]
+			ws.maskPos++
+		}
+	}
+	ws.remaining -= uint64(n)
+	return n, err
+}
+
+// Write implements io.Writer sending b as a binary message
+func (ws *WebSocketConn) Write(b []byte) (int, error) {
+	if err := ws.writeFrame(wsOpBinary, append([]byte(nil), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close implements io.Closer sending a close frame before closing the connection
+func (ws *WebSocketConn) Close() error {
+	ws.closeOnce.Do(func() { ws.writeFrame(wsOpClose, []byte{0x03, 0xe8}) }) // 1000 normal closure
+	return ws.Conn.Close()
+}
+
+// Ping implements Pinger sending a ping frame and waiting for the pong. Pongs are read along
+// the data, so Ping only completes while the connection is being read.
+func (ws *WebSocketConn) Ping(ctx context.Context) error {
+	select {
+	case <-ws.pong: // stale pong of a timed out ping
+	default:
+	}
+	if err := ws.writeFrame(wsOpPing, []byte("vmodem")); err != nil {
+		return err
+	}
+	select {
+	case <-ws.pong:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WebSocketTransport connects to ws:// and wss:// URL targets
+type WebSocketTransport struct {
+	TLSConfig *tls.Config         // Client configuration of wss (nil = defaults)
+	KeepAlive net.KeepAliveConfig // TCP keepalive probes (zero value = Go defaults)
+}
+
+// Dial implements Transport
+func (t *WebSocketTransport) Dial(ctx context.Context, target string) (io.ReadWriteCloser, error) {
+	return dialWebSocket(ctx, target, t.TLSConfig, t.KeepAlive)
+}
+
+// DialWebSocket connects to a ws:// or wss:// URL
+func DialWebSocket(ctx context.Context, rawURL string, tlsConfig *tls.Config) (*WebSocketConn, error) {
+	return dialWebSocket(ctx, rawURL, tlsConfig, net.KeepAliveConfig{})
+}
+
+func dialWebSocket(ctx context.Context, rawURL string, tlsConfig *tls.Config, keepAlive net.KeepAliveConfig) (*WebSocketConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	secure := strings.ToLower(u.Scheme) == "wss"
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	var conn net.Conn
+	nd := &net.Dialer{KeepAliveConfig: keepAlive}
+	if secure {
+		d := tls.Dialer{NetDialer: nd, Config: tlsConfig}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = nd.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Host:       u.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrWebSocketHandshake, resp.Status)
+	}
+	return NewWebSocketConn(conn, br, true), nil
+}
+
+// AcceptWebSocket upgrades an HTTP request to WebSocket taking over its connection.
+// The first subprotocol offered by the client, if any, is accepted.
+func AcceptWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocketConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, ErrWebSocketHandshake
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, ErrWebSocketHandshake
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n"
+	if proto := r.Header.Get("Sec-WebSocket-Protocol"); proto != "" {
+		resp += "Sec-WebSocket-Protocol: " + strings.TrimSpace(strings.Split(proto, ",")[0]) + "\r\n"
+	}
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return NewWebSocketConn(conn, brw.Reader, false), nil
+}